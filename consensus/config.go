@@ -1,8 +1,17 @@
 package consensus
 
-import "github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
+import (
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
+)
 
 type Config struct {
+	// IndexPruneDepth is a number of decided frames, after which vectors of confirmed events
+	// are pruned from the DAG index. Zero disables the pruning.
+	// Events which have pruned parents are rejected by the DAG index, so the depth should be
+	// big enough to never prune events which may be still referenced by honest validators.
+	// Events whose self-parents are at the pruned frames (i.e. forks of old events) are rejected too.
+	IndexPruneDepth idx.Frame
 }
 
 // DefaultConfig for livenet.
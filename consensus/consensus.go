@@ -44,6 +44,9 @@ func (p *Consensus) confirmEvents(frame idx.Frame, event hash.Event, onEventConf
 		}
 		// mark all the walked events as confirmed
 		p.store.SetEventConfirmedOn(e.ID(), frame)
+		if p.config.IndexPruneDepth != 0 {
+			p.store.AddPrunableEvent(e.ID(), frame)
+		}
 		if onEventConfirmed != nil {
 			onEventConfirmed(e)
 		}
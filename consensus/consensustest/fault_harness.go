@@ -0,0 +1,345 @@
+package consensustest
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/unicornultrafoundation/go-hashgraph/consensus"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/types"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/fallible"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/flushable"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/adapters"
+	"github.com/unicornultrafoundation/go-hashgraph/vecfc"
+)
+
+// CrashPoint is a place where FaultHarness may crash the consensus.
+type CrashPoint int
+
+const (
+	// CrashOnWrite is a storage failure, injected by fallible into main or epoch DB
+	CrashOnWrite CrashPoint = iota
+	// CrashOnBeginBlock is a crash in onFrameDecided, before the block is applied
+	CrashOnBeginBlock
+	// CrashOnApplyEvent is a crash in the middle of events confirmation
+	CrashOnApplyEvent
+	// CrashOnEndBlock is a crash right before epoch sealing
+	CrashOnEndBlock
+	// CrashOnEpochDBOpen is a crash in sealEpoch, on opening of a new epoch DB
+	CrashOnEpochDBOpen
+	// NumCrashPoints is a number of the crash points
+	NumCrashPoints
+)
+
+// ErrInjectedCrash is a panic value of the injected crashes.
+var ErrInjectedCrash = errors.New("injected crash")
+
+// FaultHarnessConfig is a FaultHarness config.
+type FaultHarnessConfig struct {
+	Seed int64
+	// CrashProbability is a probability of a crash during processing of an event
+	CrashProbability float64
+	// MaxHits is a max number of times the armed crash point is passed before the crash
+	MaxHits int
+	// Points are the enabled crash points, all the points if empty
+	Points []CrashPoint
+
+	// MaxEpochBlocks is a number of blocks after which epoch gets sealed
+	MaxEpochBlocks idx.Frame
+	// MutateValidators changes validators on each epoch sealing. Optional.
+	MutateValidators func(validators *pos.Validators) *pos.Validators
+}
+
+// BlockKey identifies a block.
+type BlockKey struct {
+	Epoch idx.Epoch
+	Frame idx.Frame
+}
+
+// Block is a block, observed by the application.
+type Block struct {
+	Key      BlockKey
+	Atropos  hash.Event
+	Cheaters types.Cheaters
+	Events   hash.Events
+}
+
+// FaultHarness runs consensus over flushable DBs and crashes it at random points.
+// Consensus state and application blocks are persisted only after an event is processed successfully,
+// so a crash rolls back everything up to the last processed event, like in a real node.
+// After a crash, consensus is restarted from the persisted DBs via Bootstrap and the event is processed again.
+type FaultHarness struct {
+	cfg  FaultHarnessConfig
+	r    *rand.Rand
+	crit func(error)
+
+	// persisted DBs, which survive crashes
+	mainDB   u2udb.Store
+	epochDBs map[idx.Epoch]u2udb.Store
+
+	// DBs of a current run
+	mainFlushable  *flushable.Flushable
+	epochFlushable *flushable.Flushable
+	mainFallible   *fallible.Fallible
+	epochFallible  *fallible.Fallible
+
+	input *eventStore
+	store *consensus.Store
+	lch   *consensus.Indexed
+
+	armed   CrashPoint
+	hits    int
+	crashed CrashPoint
+
+	blocks  []Block
+	pending []Block
+
+	Crashes  [NumCrashPoints]int
+	Restarts int
+}
+
+// persistentDB ignores closing and dropping, so DB content survives crashes.
+type persistentDB struct {
+	u2udb.Store
+}
+
+func (persistentDB) Close() error {
+	return nil
+}
+
+func (persistentDB) Drop() {}
+
+// eventStore is a consensus.EventSource over memory map.
+type eventStore struct {
+	db map[hash.Event]dag.Event
+}
+
+func (s *eventStore) SetEvent(e dag.Event) {
+	s.db[e.ID()] = e
+}
+
+func (s *eventStore) GetEvent(h hash.Event) dag.Event {
+	return s.db[h]
+}
+
+func (s *eventStore) HasEvent(h hash.Event) bool {
+	_, ok := s.db[h]
+	return ok
+}
+
+// NewFaultHarness creates FaultHarness with applied genesis.
+func NewFaultHarness(validators *pos.Validators, cfg FaultHarnessConfig) *FaultHarness {
+	h := &FaultHarness{
+		cfg:      cfg,
+		r:        rand.New(rand.NewSource(cfg.Seed)), // nolint:gosec
+		mainDB:   persistentDB{memorydb.New()},
+		epochDBs: map[idx.Epoch]u2udb.Store{},
+		input:    &eventStore{db: map[hash.Event]dag.Event{}},
+		armed:    -1,
+		crashed:  -1,
+	}
+	h.crit = func(err error) {
+		panic(err)
+	}
+	if len(h.cfg.Points) == 0 {
+		for p := CrashPoint(0); p < NumCrashPoints; p++ {
+			h.cfg.Points = append(h.cfg.Points, p)
+		}
+	}
+	if h.cfg.MaxHits == 0 {
+		h.cfg.MaxHits = 1
+	}
+
+	h.open()
+	err := h.store.ApplyGenesis(&consensus.Genesis{
+		Validators: validators,
+		Epoch:      consensus.FirstEpoch,
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = h.lch.Bootstrap(h.callbacks())
+	if err != nil {
+		panic(err)
+	}
+	h.commit()
+	return h
+}
+
+// open creates consensus over persisted DBs, dropping not flushed data.
+func (h *FaultHarness) open() {
+	h.mainFlushable = flushable.Wrap(h.mainDB)
+	h.mainFallible = fallible.Wrap(h.mainFlushable)
+	h.mainFallible.SetWriteCount(math.MaxInt32)
+	h.epochFlushable = nil
+	h.epochFallible = nil
+
+	h.store = consensus.NewStore(h.mainFallible, h.openEpochDB, h.crit, consensus.LiteStoreConfig())
+	dagIndexer := &adapters.VectorToDagIndexer{Index: vecfc.NewIndex(h.crit, vecfc.LiteConfig())}
+	h.lch = consensus.NewIndexed(h.store, h.input, dagIndexer, h.crit, consensus.LiteConfig())
+}
+
+func (h *FaultHarness) openEpochDB(epoch idx.Epoch) u2udb.Store {
+	h.hit(CrashOnEpochDBOpen)
+
+	db := h.epochDBs[epoch]
+	if db == nil {
+		db = persistentDB{memorydb.New()}
+		h.epochDBs[epoch] = db
+	}
+	h.epochFlushable = flushable.Wrap(db)
+	h.epochFallible = fallible.Wrap(h.epochFlushable)
+	h.epochFallible.SetWriteCount(math.MaxInt32)
+	return h.epochFallible
+}
+
+func (h *FaultHarness) callbacks() types.ConsensusCallbacks {
+	return types.ConsensusCallbacks{
+		BeginBlock: func(block *types.Block) types.BlockCallbacks {
+			h.hit(CrashOnBeginBlock)
+			b := Block{
+				Atropos:  block.Event,
+				Cheaters: block.Cheaters,
+			}
+			return types.BlockCallbacks{
+				ApplyEvent: func(e dag.Event) {
+					h.hit(CrashOnApplyEvent)
+					b.Events = append(b.Events, e.ID())
+				},
+				EndBlock: func() (sealEpoch *pos.Validators) {
+					h.hit(CrashOnEndBlock)
+					b.Key = BlockKey{
+						Epoch: h.store.GetEpoch(),
+						Frame: h.store.GetLastDecidedFrame() + 1,
+					}
+					h.pending = append(h.pending, b)
+					if b.Key.Frame != h.cfg.MaxEpochBlocks {
+						return nil
+					}
+					if h.cfg.MutateValidators != nil {
+						return h.cfg.MutateValidators(h.store.GetValidators())
+					}
+					return h.store.GetValidators()
+				},
+			}
+		},
+	}
+}
+
+// hit crashes the consensus if the point is armed and its hits are over.
+func (h *FaultHarness) hit(p CrashPoint) {
+	if h.armed != p {
+		return
+	}
+	if h.hits > 0 {
+		h.hits--
+		return
+	}
+	h.crashed = p
+	panic(ErrInjectedCrash)
+}
+
+// arm randomly picks a crash point for the next event processing.
+func (h *FaultHarness) arm() {
+	h.armed = -1
+	h.crashed = -1
+	if h.r.Float64() >= h.cfg.CrashProbability {
+		return
+	}
+	p := h.cfg.Points[h.r.Intn(len(h.cfg.Points))]
+	hits := h.r.Intn(h.cfg.MaxHits)
+	if p == CrashOnWrite {
+		if h.r.Intn(2) == 0 {
+			h.mainFallible.SetWriteCount(hits)
+		} else {
+			h.epochFallible.SetWriteCount(hits)
+		}
+		return
+	}
+	h.armed = p
+	h.hits = hits
+}
+
+func (h *FaultHarness) disarm() {
+	h.armed = -1
+	h.mainFallible.SetWriteCount(math.MaxInt32)
+	h.epochFallible.SetWriteCount(math.MaxInt32)
+}
+
+// commit persists the current state, like a node does after each processed event.
+func (h *FaultHarness) commit() {
+	if err := h.epochFlushable.Flush(); err != nil {
+		panic(err)
+	}
+	if err := h.mainFlushable.Flush(); err != nil {
+		panic(err)
+	}
+	h.blocks = append(h.blocks, h.pending...)
+	h.pending = nil
+}
+
+// restart drops not persisted data and bootstraps consensus from the persisted DBs.
+func (h *FaultHarness) restart() error {
+	h.Restarts++
+	h.armed = -1
+	h.pending = nil
+	h.open()
+	return h.lch.Bootstrap(h.callbacks())
+}
+
+// tryProcess processes the event and reports whether an injected crash has happened.
+// Panics which aren't caused by the harness are propagated.
+func (h *FaultHarness) tryProcess(e dag.Event) (crashed bool, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if h.crashed < 0 && (h.mainFallible.GetWriteCount() < 0 || (h.epochFallible != nil && h.epochFallible.GetWriteCount() < 0)) {
+			h.crashed = CrashOnWrite
+		}
+		if h.crashed < 0 {
+			panic(r)
+		}
+		h.Crashes[h.crashed]++
+		crashed = true
+	}()
+	return false, h.lch.Process(e)
+}
+
+// Process processes the event, restarting the consensus after each crash until the event is processed.
+func (h *FaultHarness) Process(e dag.Event) error {
+	h.input.SetEvent(e)
+	for {
+		h.arm()
+		crashed, err := h.tryProcess(e)
+		if !crashed {
+			h.disarm()
+			if err != nil {
+				return err
+			}
+			h.commit()
+			return nil
+		}
+		err = h.restart()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Blocks returns persisted blocks.
+func (h *FaultHarness) Blocks() []Block {
+	return h.blocks
+}
+
+// Store returns the consensus store of a current run.
+func (h *FaultHarness) Store() *consensus.Store {
+	return h.store
+}
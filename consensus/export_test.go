@@ -0,0 +1,57 @@
+package consensus
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/types"
+)
+
+// MutateValidators is exported for the external tests.
+var MutateValidators = mutateValidators
+
+// GenFaultInjectionEvents generates events of several epochs, sealing epoch on decided frame == maxEpochBlocks.
+// It returns the decided blocks and the events in the processing order.
+func GenFaultInjectionEvents(t *testing.T, nodes []idx.ValidatorID, weights []pos.Weight, cheatersCount int, epochs idx.Epoch, maxEpochBlocks idx.Frame, mutateWeights bool) (map[BlockKey]*BlockResult, dag.Events) {
+	generator, _, input, _ := FakeConsensus(nodes, weights)
+	generator.applyBlock = func(block *types.Block) *pos.Validators {
+		if generator.store.GetLastDecidedFrame()+1 == maxEpochBlocks {
+			if mutateWeights {
+				return mutateValidators(generator.store.GetValidators())
+			}
+			return generator.store.GetValidators()
+		}
+		return nil
+	}
+
+	var ordered dag.Events
+	parentCount := 5
+	if parentCount > len(nodes) {
+		parentCount = len(nodes)
+	}
+	r := rand.New(rand.NewSource(int64(len(nodes) + cheatersCount))) // nolint:gosec
+	for epoch := idx.Epoch(1); epoch <= epochs; epoch++ {
+		tdag.ForEachRandFork(nodes, nodes[:cheatersCount], TestMaxEpochEvents, parentCount, 10, r, tdag.ForEachEvent{
+			Process: func(e dag.Event, name string) {
+				input.SetEvent(e)
+				require.NoError(t, generator.Process(e))
+				ordered = append(ordered, e)
+			},
+			Build: func(e dag.MutableEvent, name string) error {
+				if epoch != generator.store.GetEpoch() {
+					return errors.New("epoch already sealed, skip")
+				}
+				e.SetEpoch(epoch)
+				return generator.Build(e)
+			},
+		})
+	}
+	return generator.blocks, ordered
+}
@@ -0,0 +1,103 @@
+package consensus_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicornultrafoundation/go-hashgraph/consensus"
+	"github.com/unicornultrafoundation/go-hashgraph/consensus/consensustest"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+)
+
+func TestFaultInjection_4(t *testing.T) {
+	testFaultInjection(t, []pos.Weight{1, 2, 3, 4}, 0, false)
+}
+
+func TestFaultInjection_3_1(t *testing.T) {
+	testFaultInjection(t, []pos.Weight{1, 1, 1, 1}, 1, false)
+}
+
+func TestFaultInjection_67_33_5(t *testing.T) {
+	testFaultInjection(t, []pos.Weight{11, 11, 11, 33, 34}, 0, true)
+}
+
+func testFaultInjection(t *testing.T, weights []pos.Weight, cheatersCount int, mutateWeights bool) {
+	const epochs = 4
+	maxEpochBlocks := idx.Frame(consensus.TestMaxEpochEvents / 4)
+
+	nodes := tdag.GenNodes(len(weights))
+	blocks, ordered := consensus.GenFaultInjectionEvents(t, nodes, weights, cheatersCount, epochs, maxEpochBlocks, mutateWeights)
+
+	var mutate func(*pos.Validators) *pos.Validators
+	if mutateWeights {
+		mutate = consensus.MutateValidators
+	}
+
+	// uninterrupted run
+	expected := consensustest.NewFaultHarness(genesisValidators(nodes, weights), consensustest.FaultHarnessConfig{
+		MaxEpochBlocks:   maxEpochBlocks,
+		MutateValidators: mutate,
+	})
+	for _, e := range ordered {
+		require.NoError(t, expected.Process(e))
+	}
+	require.Equal(t, 0, expected.Restarts)
+	require.Equal(t, int(maxEpochBlocks)*epochs, len(expected.Blocks()))
+	for _, b := range expected.Blocks() {
+		block := blocks[consensus.BlockKey(b.Key)]
+		require.NotNil(t, block, b.Key)
+		require.Equal(t, block.Event, b.Atropos, b.Key)
+		require.Equal(t, block.Cheaters, b.Cheaters, b.Key)
+	}
+
+	runs := []struct {
+		name    string
+		points  []consensustest.CrashPoint
+		maxHits int
+	}{
+		{"all", nil, 20},
+		{"write", []consensustest.CrashPoint{consensustest.CrashOnWrite}, 20},
+		{"beginBlock", []consensustest.CrashPoint{consensustest.CrashOnBeginBlock}, 2},
+		{"applyEvent", []consensustest.CrashPoint{consensustest.CrashOnApplyEvent}, 20},
+		{"endBlock", []consensustest.CrashPoint{consensustest.CrashOnEndBlock}, 2},
+		// new epoch DB is opened only once per epoch
+		{"epochDB", []consensustest.CrashPoint{consensustest.CrashOnEpochDBOpen}, 1},
+	}
+	for i, run := range runs {
+		points := run.points
+		t.Run(run.name, func(t *testing.T) {
+			faulty := consensustest.NewFaultHarness(genesisValidators(nodes, weights), consensustest.FaultHarnessConfig{
+				Seed:             int64(len(nodes) + i),
+				CrashProbability: 0.5,
+				MaxHits:          run.maxHits,
+				Points:           points,
+				MaxEpochBlocks:   maxEpochBlocks,
+				MutateValidators: mutate,
+			})
+			for _, e := range ordered {
+				require.NoError(t, faulty.Process(e))
+			}
+
+			assert.NotZero(t, faulty.Restarts)
+			for _, p := range points {
+				assert.NotZero(t, faulty.Crashes[p], fmt.Sprintf("crash point %d", p))
+			}
+			assert.Equal(t, expected.Blocks(), faulty.Blocks())
+			assert.Equal(t, *expected.Store().GetLastDecidedState(), *faulty.Store().GetLastDecidedState())
+			assert.Equal(t, expected.Store().GetEpochState().String(), faulty.Store().GetEpochState().String())
+		})
+	}
+}
+
+func genesisValidators(nodes []idx.ValidatorID, weights []pos.Weight) *pos.Validators {
+	validators := make(pos.ValidatorsBuilder, len(nodes))
+	for i, v := range nodes {
+		validators[v] = weights[i]
+	}
+	return validators.Build()
+}
@@ -0,0 +1,99 @@
+package consensus
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb"
+)
+
+func TestIndexPruning(t *testing.T) {
+	testIndexPruning(t, []pos.Weight{1, 1, 1, 1}, 0)
+	testIndexPruning(t, []pos.Weight{1, 2, 3, 4}, 0)
+	testIndexPruning(t, []pos.Weight{11, 11, 11, 67}, 0)
+	testIndexPruning(t, []pos.Weight{1, 1, 1, 1}, 1)
+	testIndexPruning(t, []pos.Weight{1, 2, 3, 4, 5}, 2)
+}
+
+func testIndexPruning(t *testing.T, weights []pos.Weight, cheatersCount int) {
+	t.Helper()
+	assertar := assert.New(t)
+
+	const (
+		GENERATOR = 0 // event generator, without pruning
+		PRUNED    = 1 // the same events, with pruning
+	)
+	nodes := tdag.GenNodes(len(weights))
+
+	lchs := make([]*TestConsensus, 0, 2)
+	inputs := make([]*EventStore, 0, 2)
+	for i := 0; i < 2; i++ {
+		lch, _, input, dagIndexer := FakeConsensus(nodes, weights)
+		lchs = append(lchs, lch)
+		inputs = append(inputs, input)
+		if i == PRUNED {
+			lch.config.IndexPruneDepth = 3
+			dagIndexer.EnablePruning()
+		}
+	}
+
+	parentCount := 3
+	if parentCount > len(nodes) {
+		parentCount = len(nodes)
+	}
+	r := rand.New(rand.NewSource(int64(len(nodes)))) // nolint:gosec
+	// forks are inserted into the first events of cheaters, so they don't reference pruned self-parents
+	events := tdag.ForEachRandFork(nodes, nodes[:cheatersCount], TestMaxEpochEvents, parentCount, 3, r, tdag.ForEachEvent{
+		Process: func(e dag.Event, name string) {
+			for i := range lchs {
+				inputs[i].SetEvent(e)
+				assertar.NoError(
+					lchs[i].Process(e))
+			}
+		},
+		Build: func(e dag.MutableEvent, name string) error {
+			e.SetEpoch(FirstEpoch)
+			return lchs[GENERATOR].Build(e)
+		},
+	})
+	if !assertar.Greater(int(lchs[GENERATOR].store.GetLastDecidedFrame()), int(lchs[PRUNED].config.IndexPruneDepth)*2) {
+		return
+	}
+
+	compareResults(t, lchs)
+	assertar.Equal(lchs[GENERATOR].blocks, lchs[PRUNED].blocks)
+
+	countKeys := func(db u2udb.Store) int {
+		count := 0
+		it := db.NewIterator(nil, nil)
+		defer it.Release()
+		for it.Next() {
+			count++
+		}
+		return count
+	}
+	full := countKeys(lchs[GENERATOR].store.epochTable.VectorIndex)
+	pruned := countKeys(lchs[PRUNED].store.epochTable.VectorIndex)
+	assertar.Less(pruned, full/2)
+
+	// a fork of an old event is rejected instead of being processed over the pruned roots
+	creator := nodes[0]
+	selfParent := events[creator][0]
+	fork := &tdag.TestEvent{}
+	fork.SetEpoch(FirstEpoch)
+	fork.SetCreator(creator)
+	fork.SetSeq(selfParent.Seq() + 1)
+	fork.SetParents(hash.Events{selfParent.ID()})
+	for _, other := range nodes[1:] {
+		fork.AddParent(events[other][len(events[other])-1].ID())
+	}
+	fork.SetLamport(TestMaxEpochEvents * 2)
+	fork.SetID([24]byte{0xff})
+	inputs[PRUNED].SetEvent(fork)
+	assertar.Equal(ErrPrunedSelfParent, lchs[PRUNED].Process(fork))
+}
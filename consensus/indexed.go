@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"errors"
 	"math/big"
 
 	"github.com/unicornultrafoundation/go-u2u/common"
@@ -17,6 +18,10 @@ import (
 
 var _ types.Consensus = (*Indexed)(nil)
 
+var (
+	ErrPrunedSelfParent = errors.New("self-parent frame is pruned from the DAG index")
+)
+
 // Indexed performs events ordering and detects cheaters
 // It's a wrapper around Orderer, which adds features which might potentially be application-specific:
 // confirmed events traversal, DAG index updates and cheaters detection.
@@ -38,6 +43,13 @@ type DagIndexer interface {
 	Reset(validators *pos.Validators, db u2udb.FlushableKVStore, getEvent func(hash.Event) dag.Event)
 }
 
+// DagIndexPruner is implemented by DAG indexers which are able to drop vectors of old events.
+type DagIndexPruner interface {
+	// EnablePruning makes the indexer tolerate missing vectors of the pruned events
+	EnablePruning()
+	Prune(ids hash.Events)
+}
+
 // New creates Indexed instance.
 func NewIndexed(store *Store, input EventSource, dagIndexer DagIndexer, crit func(error), config Config) *Indexed {
 	p := &Indexed{
@@ -54,8 +66,13 @@ func NewIndexed(store *Store, input EventSource, dagIndexer DagIndexer, crit fun
 func (p *Indexed) Build(e dag.MutableEvent) error {
 	e.SetID(p.uniqueDirtyID.sample())
 
+	err := p.checkPrunedSelfParent(e)
+	if err != nil {
+		return err
+	}
+
 	defer p.dagIndexer.DropNotFlushed()
-	err := p.dagIndexer.Add(e)
+	err = p.dagIndexer.Add(e)
 	if err != nil {
 		return err
 	}
@@ -68,6 +85,11 @@ func (p *Indexed) Build(e dag.MutableEvent) error {
 // All the event checkers must be launched.
 // Process is not safe for concurrent use.
 func (p *Indexed) Process(e dag.Event) (err error) {
+	err = p.checkPrunedSelfParent(e)
+	if err != nil {
+		return err
+	}
+
 	defer p.dagIndexer.DropNotFlushed()
 	err = p.dagIndexer.Add(e)
 	if err != nil {
@@ -78,10 +100,55 @@ func (p *Indexed) Process(e dag.Event) (err error) {
 	if err != nil {
 		return err
 	}
+	p.pruneIndex()
 	p.dagIndexer.Flush()
 	return nil
 }
 
+// prunedFrame returns the highest frame, whose roots may be pruned from the DAG index
+func (p *Indexed) prunedFrame() idx.Frame {
+	if p.config.IndexPruneDepth == 0 {
+		return 0
+	}
+	if _, ok := p.dagIndexer.(DagIndexPruner); !ok {
+		return 0
+	}
+	lastDecidedFrame := p.store.GetLastDecidedFrame()
+	if lastDecidedFrame <= p.config.IndexPruneDepth {
+		return 0
+	}
+	return lastDecidedFrame - p.config.IndexPruneDepth
+}
+
+// checkPrunedSelfParent rejects events whose frame calculation starts from a frame with pruned roots.
+// It's the case for forks which reference old self-parents.
+func (p *Indexed) checkPrunedSelfParent(e dag.Event) error {
+	if e.SelfParent() == nil {
+		return nil
+	}
+	prunedFrame := p.prunedFrame()
+	if prunedFrame == 0 {
+		return nil
+	}
+	selfParent := p.input.GetEvent(*e.SelfParent())
+	if selfParent != nil && selfParent.Frame() <= prunedFrame {
+		return ErrPrunedSelfParent
+	}
+	return nil
+}
+
+// pruneIndex drops vectors of events which are confirmed more than IndexPruneDepth frames ago
+func (p *Indexed) pruneIndex() {
+	prunedFrame := p.prunedFrame()
+	if prunedFrame == 0 {
+		return
+	}
+	ids := p.store.PopPrunableEvents(prunedFrame)
+	if len(ids) != 0 {
+		p.dagIndexer.(DagIndexPruner).Prune(ids)
+	}
+}
+
 func (p *Indexed) Bootstrap(callback types.ConsensusCallbacks) error {
 	base := p.Consensus.OrdererCallbacks()
 	ordererCallbacks := OrdererCallbacks{
@@ -91,6 +158,9 @@ func (p *Indexed) Bootstrap(callback types.ConsensusCallbacks) error {
 				base.EpochDBLoaded(epoch)
 			}
 			p.dagIndexer.Reset(p.store.GetValidators(), flushable.Wrap(p.store.epochTable.VectorIndex), p.input.GetEvent)
+			if pruner, ok := p.dagIndexer.(DagIndexPruner); ok && p.config.IndexPruneDepth != 0 {
+				pruner.EnablePruning()
+			}
 		},
 	}
 	return p.Consensus.BootstrapWithOrderer(callback, ordererCallbacks)
@@ -35,6 +35,7 @@ type Store struct {
 		Roots          u2udb.Store `table:"r"`
 		VectorIndex    u2udb.Store `table:"v"`
 		ConfirmedEvent u2udb.Store `table:"C"`
+		PrunableEvent  u2udb.Store `table:"P"`
 	}
 }
 
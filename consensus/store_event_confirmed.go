@@ -1,8 +1,13 @@
 package consensus
 
 import (
-	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"bytes"
+	"fmt"
+
+	"github.com/unicornultrafoundation/go-u2u/common"
+
 	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
 )
 
 // SetEventConfirmedOn stores confirmed event hash.
@@ -28,3 +33,49 @@ func (s *Store) GetEventConfirmedOn(e hash.Event) idx.Frame {
 
 	return idx.BytesToFrame(buf)
 }
+
+func prunableEventKey(on idx.Frame, e hash.Event) []byte {
+	key := bytes.Buffer{}
+	key.Write(on.Bytes())
+	key.Write(e.Bytes())
+	return key.Bytes()
+}
+
+// AddPrunableEvent stores confirmed event hash, indexed by the frame it's confirmed on.
+func (s *Store) AddPrunableEvent(e hash.Event, on idx.Frame) {
+	if err := s.epochTable.PrunableEvent.Put(prunableEventKey(on, e), []byte{}); err != nil {
+		s.crit(err)
+	}
+}
+
+// PopPrunableEvents returns and erases hashes of all the events confirmed on frames up to the specified one.
+func (s *Store) PopPrunableEvents(upTo idx.Frame) hash.Events {
+	var (
+		ids  hash.Events
+		keys [][]byte
+	)
+
+	it := s.epochTable.PrunableEvent.NewIterator(nil, nil)
+	for it.Next() {
+		key := it.Key()
+		if len(key) != frameSize+eventIDSize {
+			s.crit(fmt.Errorf("prunable events table: incorrect key len=%d", len(key)))
+		}
+		if idx.BytesToFrame(key[:frameSize]) > upTo {
+			break
+		}
+		ids = append(ids, hash.BytesToEvent(key[frameSize:]))
+		keys = append(keys, common.CopyBytes(key))
+	}
+	if it.Error() != nil {
+		s.crit(it.Error())
+	}
+	it.Release()
+
+	for _, key := range keys {
+		if err := s.epochTable.PrunableEvent.Delete(key); err != nil {
+			s.crit(err)
+		}
+	}
+	return ids
+}
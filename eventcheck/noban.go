@@ -8,4 +8,5 @@ var (
 	ErrAlreadyConnectedEvent = errors.New("event is connected already")
 	ErrSpilledEvent          = errors.New("event is spilled")
 	ErrDuplicateEvent        = errors.New("event is duplicated")
+	ErrQuarantinedEvent      = errors.New("event is quarantined")
 )
@@ -1,11 +1,13 @@
 module github.com/unicornultrafoundation/go-hashgraph
 
-go 1.19
+go 1.20
 
 require (
+	github.com/DataDog/zstd v1.5.2
 	github.com/cockroachdb/pebble v0.0.0-20230209160836-829675f94811
 	github.com/emirpasic/gods v1.18.1
 	github.com/golang/mock v1.6.0
+	github.com/golang/snappy v0.0.5-0.20220116011046-fa5810519dcb
 	github.com/hashicorp/golang-lru v1.0.2
 	github.com/pkg/errors v0.9.1
 	github.com/status-im/keycard-go v0.2.0
@@ -15,7 +17,6 @@ require (
 )
 
 require (
-	github.com/DataDog/zstd v1.5.2 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/cockroachdb/errors v1.9.1 // indirect
@@ -26,7 +27,6 @@ require (
 	github.com/getsentry/sentry-go v0.18.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/golang/snappy v0.0.5-0.20220116011046-fa5810519dcb // indirect
 	github.com/klauspost/compress v1.15.15 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
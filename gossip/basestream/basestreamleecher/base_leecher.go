@@ -1,6 +1,7 @@
 package basestreamleecher
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -51,6 +52,21 @@ func (d *BaseLeecher) Start() {
 	}()
 }
 
+// StartContext boots up the leecher, which gets terminated once ctx is done.
+// Stop is still required to wait for the goroutines.
+func (d *BaseLeecher) StartContext(ctx context.Context) {
+	d.Start()
+	d.Wg.Add(1)
+	go func() {
+		defer d.Wg.Done()
+		select {
+		case <-ctx.Done():
+			d.Terminate()
+		case <-d.Quit:
+		}
+	}()
+}
+
 func (d *BaseLeecher) Routine() {
 	if d.Terminated {
 		return
@@ -118,6 +134,9 @@ func (d *BaseLeecher) Terminate() {
 	d.Mu.Lock()
 	defer d.Mu.Unlock()
 
+	if d.Terminated {
+		return
+	}
 	d.Terminated = true
 	close(d.Quit)
 	d.callback.TerminateSession()
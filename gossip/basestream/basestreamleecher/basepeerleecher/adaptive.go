@@ -0,0 +1,185 @@
+package basepeerleecher
+
+import (
+	"time"
+)
+
+const (
+	// maxInFlight limits the tracked requested chunks, relatively to the max parallelism
+	maxInFlight = 4
+	// ewmaWeight is a weight of a new sample in the moving averages
+	ewmaWeight = 0.2
+)
+
+// bounds of an adaptive value
+type bounds struct {
+	min, max float64
+}
+
+func newBounds(min, def, max float64) bounds {
+	if min == 0 || min > def {
+		min = def
+	}
+	if max < def {
+		max = def
+	}
+	return bounds{min, max}
+}
+
+// limit lowers the bounds down to a hard limit. Zero limit means no limit.
+func (b bounds) limit(limit float64) bounds {
+	if limit == 0 {
+		return b
+	}
+	if b.max > limit {
+		b.max = limit
+	}
+	if b.min > limit {
+		b.min = limit
+	}
+	return b
+}
+
+func (b bounds) clamp(v float64) float64 {
+	if v < b.min {
+		return b.min
+	}
+	if v > b.max {
+		return b.max
+	}
+	return v
+}
+
+// Stats are the measured peer's performance and the current chunk parameters
+type Stats struct {
+	RTT time.Duration
+	// Throughput is in bytes per second
+	Throughput float64
+
+	ChunkItemsNum          uint32
+	ChunkItemsSize         uint64
+	ParallelChunksDownload int
+}
+
+// chunksController adapts chunk sizes and parallelism with AIMD, based on chunks round-trip time
+type chunksController struct {
+	targetRTT time.Duration
+
+	num, size, parallel                   float64
+	numBounds, sizeBounds, parallelBounds bounds
+
+	// request times of the chunks in flight
+	requested    []time.Time
+	lastReceived time.Time
+	lastDecrease time.Time
+
+	rtt        time.Duration
+	throughput float64
+}
+
+func newChunksController(cfg EpochDownloaderConfig) *chunksController {
+	c := &chunksController{
+		targetRTT: cfg.TargetRTT,
+		num:       float64(cfg.DefaultChunkItemsNum),
+		size:      float64(cfg.DefaultChunkItemsSize),
+		parallel:  float64(cfg.ParallelChunksDownload),
+	}
+	if c.targetRTT == 0 {
+		c.numBounds = bounds{c.num, c.num}
+		c.sizeBounds = bounds{c.size, c.size}
+		c.parallelBounds = bounds{c.parallel, c.parallel}
+	} else {
+		c.numBounds = newBounds(float64(cfg.MinChunkItemsNum), c.num, float64(cfg.MaxChunkItemsNum))
+		c.sizeBounds = newBounds(float64(cfg.MinChunkItemsSize), c.size, float64(cfg.MaxChunkItemsSize))
+		c.parallelBounds = newBounds(float64(cfg.MinParallelChunksDownload), c.parallel, float64(cfg.MaxParallelChunksDownload))
+	}
+	c.numBounds = c.numBounds.limit(float64(cfg.MaxPayloadNum))
+	c.sizeBounds = c.sizeBounds.limit(float64(cfg.MaxPayloadSize))
+	c.num = c.numBounds.clamp(c.num)
+	c.size = c.sizeBounds.clamp(c.size)
+	return c
+}
+
+func (c *chunksController) chunkItemsNum() uint32 {
+	return uint32(c.num)
+}
+
+func (c *chunksController) chunkItemsSize() uint64 {
+	return uint64(c.size)
+}
+
+func (c *chunksController) parallelChunks() int {
+	return int(c.parallel)
+}
+
+func (c *chunksController) maxParallelChunks() int {
+	return int(c.parallelBounds.max)
+}
+
+func (c *chunksController) onRequested(chunks int, now time.Time) {
+	for i := 0; i < chunks; i++ {
+		c.requested = append(c.requested, now)
+	}
+	// forget chunks which are never received
+	if limit := c.maxParallelChunks() * maxInFlight; len(c.requested) > limit {
+		c.requested = c.requested[len(c.requested)-limit:]
+	}
+}
+
+// onReceived updates measurements and adapts the chunk parameters. size is zero if unknown.
+func (c *chunksController) onReceived(size uint64, now time.Time) {
+	prevReceived := c.lastReceived
+	if size != 0 && !c.lastReceived.IsZero() {
+		if dt := now.Sub(c.lastReceived).Seconds(); dt > 0 {
+			c.throughput = ewma(c.throughput, float64(size)/dt)
+		}
+	}
+	c.lastReceived = now
+
+	if len(c.requested) == 0 {
+		return
+	}
+	// chunks of one request are sent one after another, so a chunk is sent not earlier than the previous one is received
+	sent := c.requested[0]
+	if prevReceived.After(sent) {
+		sent = prevReceived
+	}
+	rtt := now.Sub(sent)
+	c.requested = c.requested[1:]
+	c.rtt = time.Duration(ewma(float64(c.rtt), float64(rtt)))
+
+	if c.targetRTT == 0 {
+		return
+	}
+	if rtt > c.targetRTT {
+		// multiplicative decrease, at most once per round trip
+		if now.Sub(c.lastDecrease) >= c.rtt {
+			c.num = c.numBounds.clamp(c.num / 2)
+			c.size = c.sizeBounds.clamp(c.size / 2)
+			c.parallel = c.parallelBounds.clamp(c.parallel / 2)
+			c.lastDecrease = now
+		}
+		return
+	}
+	// additive increase, by a min value per round trip
+	c.num = c.numBounds.clamp(c.num + c.numBounds.min/c.parallel)
+	c.size = c.sizeBounds.clamp(c.size + c.sizeBounds.min/c.parallel)
+	c.parallel = c.parallelBounds.clamp(c.parallel + 1/c.parallel)
+}
+
+func (c *chunksController) stats() Stats {
+	return Stats{
+		RTT:                    c.rtt,
+		Throughput:             c.throughput,
+		ChunkItemsNum:          c.chunkItemsNum(),
+		ChunkItemsSize:         c.chunkItemsSize(),
+		ParallelChunksDownload: c.parallelChunks(),
+	}
+}
+
+func ewma(prev, sample float64) float64 {
+	if prev == 0 {
+		return sample
+	}
+	return prev*(1-ewmaWeight) + sample*ewmaWeight
+}
@@ -0,0 +1,124 @@
+package basepeerleecher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunksController(t *testing.T) {
+	cfg := EpochDownloaderConfig{
+		DefaultChunkItemsNum:      100,
+		DefaultChunkItemsSize:     1000,
+		ParallelChunksDownload:    4,
+		TargetRTT:                 100 * time.Millisecond,
+		MinChunkItemsNum:          10,
+		MaxChunkItemsNum:          200,
+		MinChunkItemsSize:         100,
+		MaxChunkItemsSize:         2000,
+		MinParallelChunksDownload: 1,
+		MaxParallelChunksDownload: 8,
+	}
+	c := newChunksController(cfg)
+	now := time.Unix(1000, 0)
+
+	// fast peer, additive increase up to the max bounds
+	for i := 0; i < 1000; i++ {
+		c.onRequested(1, now)
+		now = now.Add(10 * time.Millisecond)
+		c.onReceived(100, now)
+	}
+	stats := c.stats()
+	require.Equal(t, 10*time.Millisecond, stats.RTT)
+	require.InDelta(t, 10000.0, stats.Throughput, 1)
+	require.Equal(t, uint32(200), stats.ChunkItemsNum)
+	require.Equal(t, uint64(2000), stats.ChunkItemsSize)
+	require.Equal(t, 8, stats.ParallelChunksDownload)
+
+	// slow peer, multiplicative decrease at most once per round trip
+	c.onRequested(2, now)
+	now = now.Add(time.Second)
+	c.onReceived(0, now)
+	now = now.Add(150 * time.Millisecond)
+	c.onReceived(0, now)
+	require.Equal(t, uint32(100), c.chunkItemsNum())
+	require.Equal(t, uint64(1000), c.chunkItemsSize())
+	require.Equal(t, 4, c.parallelChunks())
+
+	// down to the min bounds
+	for i := 0; i < 100; i++ {
+		c.onRequested(1, now)
+		now = now.Add(time.Second)
+		c.onReceived(0, now)
+	}
+	require.Equal(t, uint32(10), c.chunkItemsNum())
+	require.Equal(t, uint64(100), c.chunkItemsSize())
+	require.Equal(t, 1, c.parallelChunks())
+}
+
+func TestChunksControllerDisabled(t *testing.T) {
+	cfg := EpochDownloaderConfig{
+		DefaultChunkItemsNum:   100,
+		DefaultChunkItemsSize:  1000,
+		ParallelChunksDownload: 4,
+		MaxChunkItemsNum:       200,
+	}
+	c := newChunksController(cfg)
+	now := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		c.onRequested(1, now)
+		now = now.Add(time.Second)
+		c.onReceived(0, now)
+	}
+	require.Equal(t, time.Second, c.stats().RTT)
+	require.Equal(t, uint32(100), c.chunkItemsNum())
+	require.Equal(t, uint64(1000), c.chunkItemsSize())
+	require.Equal(t, 4, c.parallelChunks())
+	require.Equal(t, 4, c.maxParallelChunks())
+}
+
+func TestChunksControllerBatchRTT(t *testing.T) {
+	cfg := EpochDownloaderConfig{
+		DefaultChunkItemsNum:   100,
+		DefaultChunkItemsSize:  1000,
+		ParallelChunksDownload: 4,
+		TargetRTT:              100 * time.Millisecond,
+	}
+	c := newChunksController(cfg)
+	now := time.Unix(1000, 0)
+
+	// chunks of one request are received one after another, RTT of a chunk doesn't include the previous chunks
+	c.onRequested(4, now)
+	for i := 0; i < 4; i++ {
+		now = now.Add(50 * time.Millisecond)
+		c.onReceived(0, now)
+	}
+	require.Equal(t, 50*time.Millisecond, c.stats().RTT)
+}
+
+func TestChunksControllerPayloadLimits(t *testing.T) {
+	cfg := EpochDownloaderConfig{
+		DefaultChunkItemsNum:      100,
+		DefaultChunkItemsSize:     1000,
+		ParallelChunksDownload:    4,
+		TargetRTT:                 100 * time.Millisecond,
+		MaxChunkItemsNum:          200,
+		MaxChunkItemsSize:         2000,
+		MaxParallelChunksDownload: 8,
+		MaxPayloadNum:             150,
+		MaxPayloadSize:            500,
+	}
+	c := newChunksController(cfg)
+	require.Equal(t, uint64(500), c.chunkItemsSize())
+
+	now := time.Unix(1000, 0)
+	for i := 0; i < 1000; i++ {
+		c.onRequested(1, now)
+		now = now.Add(10 * time.Millisecond)
+		c.onReceived(100, now)
+	}
+	require.Equal(t, uint32(150), c.chunkItemsNum())
+	require.Equal(t, uint64(500), c.chunkItemsSize())
+	require.Equal(t, 8, c.parallelChunks())
+}
@@ -9,4 +9,21 @@ type EpochDownloaderConfig struct {
 	DefaultChunkItemsNum   uint32
 	DefaultChunkItemsSize  uint64
 	ParallelChunksDownload int
+
+	// TargetRTT enables adaptive chunk sizing if non-zero.
+	// Chunk sizes and parallelism are increased additively while chunks round-trip time is below TargetRTT,
+	// and decreased multiplicatively otherwise.
+	TargetRTT time.Duration
+	// Bounds of the adaptive chunk sizing. Zero bound means the default value.
+	MinChunkItemsNum          uint32
+	MaxChunkItemsNum          uint32
+	MinChunkItemsSize         uint64
+	MaxChunkItemsSize         uint64
+	MinParallelChunksDownload int
+	MaxParallelChunksDownload int
+
+	// MaxPayloadNum and MaxPayloadSize are the hard limits of a requested chunk, e.g. the seeder's limits,
+	// as the seeder truncates bigger chunks anyway. Chunk sizes never exceed them. Zero means no limit.
+	MaxPayloadNum  uint32
+	MaxPayloadSize uint64
 }
@@ -13,7 +13,10 @@ var (
 type IsProcessed func(id interface{}) bool
 
 type receivedChunk struct {
-	id interface{}
+	id        interface{}
+	processed bool
+	size      uint64
+	at        time.Time
 }
 
 type EpochDownloaderCallbacks struct {
@@ -24,6 +27,10 @@ type EpochDownloaderCallbacks struct {
 	Suspend func() bool
 
 	Done func() bool
+
+	// Processed is called with the id of the last received chunk, which is processed along with all the previous chunks.
+	// It allows to persist the session progress and to resume the session from it. Optional.
+	Processed func(id interface{})
 }
 
 // BasePeerLeecher is responsible for scheduling items for retrieval.
@@ -33,10 +40,16 @@ type BasePeerLeecher struct {
 	totalRequested int
 	totalProcessed int
 
+	// received chunks in the order of arrival, processed chunks are kept until all the previous chunks are processed
 	processingChunks []receivedChunk
+	// progressLost is true if a received chunk wasn't tracked, so the progress cannot be reported anymore
+	progressLost bool
 
 	notifyReceivedChunk chan *receivedChunk
 
+	ctrlMu sync.Mutex
+	ctrl   *chunksController
+
 	quitMu sync.Mutex
 	quit   chan struct{}
 	done   bool
@@ -50,9 +63,11 @@ type BasePeerLeecher struct {
 // New creates an items fetcher to retrieve items chunk-by-chunk. Works only with 1 peer.
 func New(wg *sync.WaitGroup, cfg EpochDownloaderConfig, callback EpochDownloaderCallbacks) *BasePeerLeecher {
 	quit := make(chan struct{})
+	ctrl := newChunksController(cfg)
 	return &BasePeerLeecher{
-		processingChunks:    make([]receivedChunk, 0, cfg.ParallelChunksDownload*2),
-		notifyReceivedChunk: make(chan *receivedChunk, cfg.ParallelChunksDownload*2),
+		processingChunks:    make([]receivedChunk, 0, ctrl.maxParallelChunks()*2),
+		notifyReceivedChunk: make(chan *receivedChunk, ctrl.maxParallelChunks()*2),
+		ctrl:                ctrl,
 		quit:                quit,
 		cfg:                 cfg,
 		callback:            callback,
@@ -92,8 +107,15 @@ func (d *BasePeerLeecher) Stopped() bool {
 
 // NotifyChunkReceived injects new pack infos from a peer
 func (d *BasePeerLeecher) NotifyChunkReceived(id interface{}) error {
+	return d.NotifyChunkReceivedSize(id, 0)
+}
+
+// NotifyChunkReceivedSize is the same as NotifyChunkReceived, but also reports the chunk size for throughput measurement
+func (d *BasePeerLeecher) NotifyChunkReceivedSize(id interface{}, size uint64) error {
 	op := &receivedChunk{
-		id: id,
+		id:   id,
+		size: size,
+		at:   time.Now(),
 	}
 	select {
 	case d.notifyReceivedChunk <- op:
@@ -119,9 +141,14 @@ func (d *BasePeerLeecher) loop() {
 				d.Terminate()
 				continue
 			}
-			if len(d.processingChunks) < d.cfg.ParallelChunksDownload*2 {
+			d.ctrlMu.Lock()
+			d.ctrl.onReceived(op.size, op.at)
+			d.ctrlMu.Unlock()
+			if len(d.processingChunks) < d.ctrl.maxParallelChunks()*2 {
 				d.processingChunks = append(d.processingChunks, *op)
 				d.routine()
+			} else {
+				d.progressLost = true
 			}
 
 		case <-syncTicker.C:
@@ -141,12 +168,22 @@ func (d *BasePeerLeecher) routine() {
 
 func (d *BasePeerLeecher) sweepProcessedChunks() []receivedChunk {
 	notProcessed := make([]receivedChunk, 0, len(d.processingChunks))
+	var lastProcessed interface{}
+	prefix := true
 	for _, op := range d.processingChunks {
-		if d.callback.IsProcessed(op.id) {
+		if !op.processed && d.callback.IsProcessed(op.id) {
+			op.processed = true
 			d.totalProcessed++
-		} else {
-			notProcessed = append(notProcessed, op)
 		}
+		if op.processed && prefix {
+			lastProcessed = op.id
+			continue
+		}
+		prefix = false
+		notProcessed = append(notProcessed, op)
+	}
+	if lastProcessed != nil && !d.progressLost && d.callback.Processed != nil {
+		d.callback.Processed(lastProcessed)
 	}
 	return notProcessed
 }
@@ -156,9 +193,25 @@ func (d *BasePeerLeecher) tryToSync() {
 		return
 	}
 
-	if d.totalRequested < d.totalProcessed+d.cfg.ParallelChunksDownload {
-		requestsToSend := (d.totalProcessed + d.cfg.ParallelChunksDownload) - d.totalRequested
+	d.ctrlMu.Lock()
+	parallel := d.ctrl.parallelChunks()
+	num, size := d.ctrl.chunkItemsNum(), d.ctrl.chunkItemsSize()
+	requestsToSend := 0
+	if d.totalRequested < d.totalProcessed+parallel {
+		requestsToSend = (d.totalProcessed + parallel) - d.totalRequested
 		d.totalRequested += requestsToSend
-		_ = d.callback.RequestChunks(d.cfg.DefaultChunkItemsNum, d.cfg.DefaultChunkItemsSize, uint32(requestsToSend))
+		d.ctrl.onRequested(requestsToSend, time.Now())
+	}
+	d.ctrlMu.Unlock()
+
+	if requestsToSend != 0 {
+		_ = d.callback.RequestChunks(num, size, uint32(requestsToSend))
 	}
 }
+
+// Stats returns the measured peer's performance and the current chunk parameters
+func (d *BasePeerLeecher) Stats() Stats {
+	d.ctrlMu.Lock()
+	defer d.ctrlMu.Unlock()
+	return d.ctrl.stats()
+}
@@ -0,0 +1,418 @@
+package basestreamleecher
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+)
+
+var (
+	ErrOutOfRange = errors.New("response is out of the requested range")
+)
+
+// ParallelConfig is a config of ParallelLeecher
+type ParallelConfig struct {
+	RecheckInterval time.Duration
+	// Ranges is a number of ranges which the whole range is split into
+	Ranges int
+	// RangeTimeout is a time without responses, after which a range is reassigned to another peer
+	RangeTimeout time.Duration
+	// MaxChunks is a number of chunks requested at once
+	MaxChunks uint32
+	// MaxBufferedChunks is a max number of received chunks of a range, which wait for the previous ranges
+	MaxBufferedChunks int
+}
+
+type ParallelCallbacks struct {
+	// Split returns locators which split [start, stop) into up to n consecutive ranges.
+	// Locators which aren't ascending or lie outside of (start, stop) are ignored.
+	Split func(start, stop basestream.Locator, n int) []basestream.Locator
+	// Request requests next maxChunks chunks of the session from the peer
+	Request func(peer string, session basestream.Session, maxChunks uint32) error
+	// Bounds returns locators of the first and the last items of a non-empty payload
+	Bounds func(payload basestream.Payload) (first, last basestream.Locator)
+	// Deliver is called for received payloads in the stream order.
+	// If a basestream.LocalError is returned, then the payload is delivered again on the next recheck.
+	// If another error is returned, then the peer is reported as misbehaving and the rest of the range is downloaded again.
+	// Deliver is called under the leecher's lock, so it must not call the leecher's methods.
+	Deliver func(peer string, payload basestream.Payload) error
+	// Misbehaviour is optional
+	Misbehaviour func(peer string, err error)
+}
+
+type parallelChunk struct {
+	peer    string
+	first   basestream.Locator
+	payload basestream.Payload
+}
+
+type parallelSession struct {
+	id        uint32
+	peer      string
+	start     basestream.Locator
+	chunks    uint32
+	lastChunk time.Time
+	ongoing   bool
+	// paused is true if the next chunks aren't requested until buffered chunks are delivered
+	paused bool
+}
+
+type parallelRange struct {
+	stop basestream.Locator
+	// next is the first not received locator
+	next basestream.Locator
+	// done is true if all the items are received
+	done     bool
+	buffered []parallelChunk
+	session  parallelSession
+	// failedPeer is the last peer which was slow or misbehaved within the range
+	failedPeer string
+}
+
+// ParallelLeecher is a generic items downloader, which splits a range into several ranges
+// and downloads them from different peers concurrently.
+// Received items are delivered in the stream order.
+type ParallelLeecher struct {
+	cfg      ParallelConfig
+	callback ParallelCallbacks
+
+	// not delivered ranges in ascending order
+	ranges          []*parallelRange
+	sessionsCounter uint32
+
+	Peers map[string]struct{}
+
+	Quit chan struct{}
+
+	Wg sync.WaitGroup
+
+	Mu *sync.RWMutex
+
+	Terminated bool
+}
+
+// NewParallel creates a downloader of items in range [start, stop)
+func NewParallel(cfg ParallelConfig, start, stop basestream.Locator, callback ParallelCallbacks) *ParallelLeecher {
+	if cfg.MaxBufferedChunks <= 0 {
+		cfg.MaxBufferedChunks = 1
+	}
+	d := &ParallelLeecher{
+		cfg:      cfg,
+		callback: callback,
+		Peers:    make(map[string]struct{}),
+		Quit:     make(chan struct{}),
+		Mu:       new(sync.RWMutex),
+		// sessions of a restarted leecher must not collide with the sessions known by seeders
+		sessionsCounter: rand.Uint32(), // nolint:gosec
+	}
+	bounds := []basestream.Locator{start}
+	if callback.Split != nil && cfg.Ranges > 1 {
+		for _, b := range callback.Split(start, stop, cfg.Ranges) {
+			if b.Compare(bounds[len(bounds)-1]) > 0 && b.Compare(stop) < 0 {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	bounds = append(bounds, stop)
+	for i := 0; i < len(bounds)-1; i++ {
+		d.ranges = append(d.ranges, &parallelRange{
+			next: bounds[i],
+			stop: bounds[i+1],
+			done: bounds[i].Compare(bounds[i+1]) >= 0,
+		})
+	}
+	d.deliver()
+	return d
+}
+
+func (d *ParallelLeecher) Start() {
+	d.Wg.Add(1)
+	go func() {
+		defer d.Wg.Done()
+		d.loop()
+	}()
+}
+
+// StartContext boots up the leecher, which gets terminated once ctx is done.
+// Stop is still required to wait for the goroutines.
+func (d *ParallelLeecher) StartContext(ctx context.Context) {
+	d.Start()
+	d.Wg.Add(1)
+	go func() {
+		defer d.Wg.Done()
+		select {
+		case <-ctx.Done():
+			d.Terminate()
+		case <-d.Quit:
+		}
+	}()
+}
+
+func (d *ParallelLeecher) loop() {
+	syncTicker := time.NewTicker(d.cfg.RecheckInterval)
+	defer syncTicker.Stop()
+	for {
+		select {
+		case <-d.Quit:
+			return
+		case <-syncTicker.C:
+			d.Mu.Lock()
+			if !d.Terminated {
+				// retry deliveries which failed due to local errors
+				d.deliver()
+			}
+			d.Routine()
+			d.Mu.Unlock()
+		}
+	}
+}
+
+// Routine reassigns ranges of slow peers and assigns not downloaded ranges to idle peers.
+func (d *ParallelLeecher) Routine() {
+	if d.Terminated {
+		return
+	}
+	now := time.Now()
+	busy := make(map[string]bool, len(d.Peers))
+	for _, r := range d.ranges {
+		s := &r.session
+		if !s.ongoing {
+			continue
+		}
+		if s.paused {
+			if len(r.buffered) < d.cfg.MaxBufferedChunks {
+				d.request(r)
+			}
+		} else if now.Sub(s.lastChunk) > d.cfg.RangeTimeout {
+			// peer is slow, reassign the range
+			r.failedPeer = s.peer
+			s.ongoing = false
+		}
+		if s.ongoing {
+			busy[s.peer] = true
+		}
+	}
+
+	idle := make([]string, 0, len(d.Peers))
+	for p := range d.Peers {
+		if !busy[p] {
+			idle = append(idle, p)
+		}
+	}
+	// lower ranges first, as they are delivered first
+	for _, r := range d.ranges {
+		if len(idle) == 0 {
+			return
+		}
+		if r.done || r.session.ongoing || len(r.buffered) >= d.cfg.MaxBufferedChunks {
+			continue
+		}
+		i := rand.Intn(len(idle)) // nolint:gosec
+		if idle[i] == r.failedPeer && len(idle) > 1 {
+			i = (i + 1) % len(idle)
+		}
+		peer := idle[i]
+		idle = append(idle[:i], idle[i+1:]...)
+		d.startSession(r, peer)
+	}
+}
+
+func (d *ParallelLeecher) startSession(r *parallelRange, peer string) {
+	d.sessionsCounter++
+	r.session = parallelSession{
+		id:      d.sessionsCounter,
+		peer:    peer,
+		start:   r.next,
+		ongoing: true,
+	}
+	d.request(r)
+}
+
+func (d *ParallelLeecher) request(r *parallelRange) {
+	s := &r.session
+	s.chunks = 0
+	s.paused = false
+	s.lastChunk = time.Now()
+	err := d.callback.Request(s.peer, basestream.Session{
+		ID:    s.id,
+		Start: s.start,
+		Stop:  r.stop,
+	}, d.cfg.MaxChunks)
+	if err != nil {
+		s.ongoing = false
+	}
+}
+
+func (d *ParallelLeecher) misbehaviour(r *parallelRange, peer string, err error) {
+	r.failedPeer = peer
+	r.session.ongoing = false
+	d.reportMisbehaviour(peer, err)
+}
+
+func (d *ParallelLeecher) reportMisbehaviour(peer string, err error) {
+	if d.callback.Misbehaviour != nil {
+		d.callback.Misbehaviour(peer, err)
+	}
+}
+
+// deliver passes the received chunks in the stream order and drops the delivered ranges
+func (d *ParallelLeecher) deliver() {
+	for len(d.ranges) != 0 {
+		r := d.ranges[0]
+		for len(r.buffered) != 0 {
+			c := r.buffered[0]
+			if err := d.callback.Deliver(c.peer, c.payload); err != nil {
+				if basestream.IsLocalError(err) {
+					// the peer isn't guilty, keep the chunk until the next retry
+					return
+				}
+				// download the rest of the range again
+				r.next = c.first
+				r.buffered = nil
+				r.done = false
+				if r.session.ongoing && r.session.peer != c.peer {
+					// the chunk was received from a previous peer of the range,
+					// so the current peer keeps the range, but restarts from the dropped chunk
+					r.failedPeer = c.peer
+					d.reportMisbehaviour(c.peer, err)
+					d.startSession(r, r.session.peer)
+					return
+				}
+				d.misbehaviour(r, c.peer, err)
+				return
+			}
+			r.buffered[0] = parallelChunk{}
+			r.buffered = r.buffered[1:]
+		}
+		if !r.done {
+			return
+		}
+		d.ranges[0] = nil
+		d.ranges = d.ranges[1:]
+	}
+}
+
+// NotifyChunkReceived injects a response from a peer
+func (d *ParallelLeecher) NotifyChunkReceived(peer string, resp basestream.Response) error {
+	d.Mu.Lock()
+	defer d.Mu.Unlock()
+
+	if d.Terminated {
+		return nil
+	}
+	var r *parallelRange
+	for _, rr := range d.ranges {
+		if rr.session.ongoing && rr.session.peer == peer && rr.session.id == resp.SessionID {
+			r = rr
+			break
+		}
+	}
+	if r == nil {
+		// response of an outdated session
+		return nil
+	}
+	s := &r.session
+	if resp.Payload != nil && resp.Payload.Len() != 0 {
+		first, last := d.callback.Bounds(resp.Payload)
+		if first.Compare(r.next) < 0 || last.Compare(r.stop) >= 0 {
+			d.misbehaviour(r, peer, ErrOutOfRange)
+			d.Routine()
+			return nil
+		}
+		r.buffered = append(r.buffered, parallelChunk{
+			peer:    peer,
+			first:   first,
+			payload: resp.Payload,
+		})
+		r.next = last.Inc()
+	}
+	s.chunks++
+	s.lastChunk = time.Now()
+	if resp.Done {
+		r.done = true
+		s.ongoing = false
+	}
+
+	d.deliver()
+
+	if s.ongoing && s.chunks >= d.cfg.MaxChunks {
+		if len(r.buffered) >= d.cfg.MaxBufferedChunks {
+			s.paused = true
+		} else {
+			d.request(r)
+		}
+	}
+	if !s.ongoing {
+		// peer is idle now
+		d.Routine()
+	}
+	return nil
+}
+
+// Done returns true if the whole range is downloaded and delivered
+func (d *ParallelLeecher) Done() bool {
+	d.Mu.RLock()
+	defer d.Mu.RUnlock()
+
+	return len(d.ranges) == 0
+}
+
+// RegisterPeer injects a new download peer to download items from.
+func (d *ParallelLeecher) RegisterPeer(peer string) error {
+	d.Mu.Lock()
+	defer d.Mu.Unlock()
+
+	if d.Terminated {
+		return nil
+	}
+	d.Peers[peer] = struct{}{}
+	d.Routine()
+	return nil
+}
+
+func (d *ParallelLeecher) PeersNum() int {
+	d.Mu.RLock()
+	defer d.Mu.RUnlock()
+
+	return len(d.Peers)
+}
+
+// UnregisterPeer removes a peer from the known list, reassigning its ranges to other peers
+func (d *ParallelLeecher) UnregisterPeer(peer string) error {
+	d.Mu.Lock()
+	defer d.Mu.Unlock()
+
+	delete(d.Peers, peer)
+	for _, r := range d.ranges {
+		if r.session.ongoing && r.session.peer == peer {
+			r.session.ongoing = false
+		}
+	}
+	d.Routine()
+	return nil
+}
+
+func (d *ParallelLeecher) Terminate() {
+	d.Mu.Lock()
+	defer d.Mu.Unlock()
+
+	if d.Terminated {
+		return
+	}
+	d.Terminated = true
+	close(d.Quit)
+	for _, r := range d.ranges {
+		r.session.ongoing = false
+	}
+}
+
+// Stop interrupts the leecher, canceling all the pending operations.
+// Stop waits until all the internal goroutines have finished.
+func (d *ParallelLeecher) Stop() {
+	d.Terminate()
+	d.Wg.Wait()
+}
@@ -0,0 +1,125 @@
+package basestreamleecher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+)
+
+type testLocator int
+
+func (l testLocator) Compare(b basestream.Locator) int {
+	if l < b.(testLocator) {
+		return -1
+	}
+	if l > b.(testLocator) {
+		return 1
+	}
+	return 0
+}
+
+func (l testLocator) Inc() basestream.Locator {
+	return l + 1
+}
+
+// testPayload contains items [first, last]
+type testPayload struct {
+	first, last testLocator
+}
+
+func (p testPayload) Len() int {
+	return int(p.last-p.first) + 1
+}
+
+func (p testPayload) TotalSize() uint64 {
+	return uint64(p.Len())
+}
+
+func (p testPayload) TotalMemSize() int {
+	return p.Len()
+}
+
+func TestParallelLeecherMisbehaviourOfPreviousPeer(t *testing.T) {
+	require := require.New(t)
+
+	errWrongItem := errors.New("wrong item")
+	sessions := map[string]basestream.Session{}
+	var delivered []testPayload
+	var misbehaved []string
+	d := NewParallel(ParallelConfig{
+		RecheckInterval:   time.Hour,
+		Ranges:            2,
+		RangeTimeout:      time.Hour,
+		MaxChunks:         10,
+		MaxBufferedChunks: 3,
+	}, testLocator(0), testLocator(100), ParallelCallbacks{
+		Split: func(start, stop basestream.Locator, n int) []basestream.Locator {
+			return []basestream.Locator{testLocator(50)}
+		},
+		Request: func(peer string, session basestream.Session, maxChunks uint32) error {
+			sessions[peer] = session
+			return nil
+		},
+		Bounds: func(payload basestream.Payload) (first, last basestream.Locator) {
+			p := payload.(testPayload)
+			return p.first, p.last
+		},
+		Deliver: func(peer string, payload basestream.Payload) error {
+			if peer == "liar" {
+				return errWrongItem
+			}
+			delivered = append(delivered, payload.(testPayload))
+			return nil
+		},
+		Misbehaviour: func(peer string, err error) {
+			require.Equal(errWrongItem, err)
+			misbehaved = append(misbehaved, peer)
+		},
+	})
+	defer d.Stop()
+
+	// first range is assigned to the first peer, second range to the liar
+	require.NoError(d.RegisterPeer("first"))
+	require.NoError(d.RegisterPeer("liar"))
+	require.Equal(testLocator(0), sessions["first"].Start)
+	require.Equal(testLocator(50), sessions["liar"].Start)
+
+	// liar's chunk is buffered until the first range is delivered, then the range is reassigned
+	require.NoError(d.NotifyChunkReceived("liar", basestream.Response{
+		SessionID: sessions["liar"].ID,
+		Payload:   testPayload{50, 54},
+	}))
+	require.NoError(d.UnregisterPeer("liar"))
+	require.NoError(d.RegisterPeer("honest"))
+	require.Equal(testLocator(55), sessions["honest"].Start)
+	require.NoError(d.NotifyChunkReceived("honest", basestream.Response{
+		SessionID: sessions["honest"].ID,
+		Payload:   testPayload{55, 59},
+	}))
+	honestSession := sessions["honest"]
+
+	// delivery of the liar's chunk fails
+	require.NoError(d.NotifyChunkReceived("first", basestream.Response{
+		SessionID: sessions["first"].ID,
+		Payload:   testPayload{0, 49},
+		Done:      true,
+	}))
+	require.Equal([]testPayload{{0, 49}}, delivered)
+	require.Equal([]string{"liar"}, misbehaved)
+
+	// the honest peer keeps the range, restarting from the dropped chunk
+	require.NotEqual(honestSession.ID, sessions["honest"].ID)
+	require.Equal(testLocator(50), sessions["honest"].Start)
+	require.NoError(d.NotifyChunkReceived("honest", basestream.Response{
+		SessionID: sessions["honest"].ID,
+		Payload:   testPayload{50, 99},
+		Done:      true,
+	}))
+	require.True(d.Done())
+	require.Equal([]testPayload{{0, 49}, {50, 99}}, delivered)
+	require.Equal([]string{"liar"}, misbehaved)
+}
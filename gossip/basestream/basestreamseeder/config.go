@@ -1,5 +1,7 @@
 package basestreamseeder
 
+import "github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+
 type Config struct {
 	SenderThreads           int
 	MaxSenderTasks          int
@@ -7,4 +9,17 @@ type Config struct {
 	MaxResponsePayloadNum   uint32
 	MaxResponsePayloadSize  uint64
 	MaxResponseChunks       uint32
+
+	// MaxPeerSessions is a max number of sessions per peer, the oldest session is dropped when exceeded.
+	// Defaults to 3 if zero.
+	MaxPeerSessions int
+	// MaxPeerResponsesRate is a max size of responses payload per second for a single peer. Zero means unlimited.
+	MaxPeerResponsesRate uint64
+	// PeerResponsesBurst is a max size of responses payload which a peer may receive at once after being idle.
+	// Defaults to MaxPeerResponsesRate if zero.
+	PeerResponsesBurst uint64
+	// Compressions are the supported payload compressions. Sessions with other compressions are served uncompressed.
+	Compressions []basestream.Compression
 }
+
+const defaultMaxPeerSessions = 3
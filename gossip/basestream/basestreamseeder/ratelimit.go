@@ -0,0 +1,56 @@
+package basestreamseeder
+
+import (
+	"time"
+)
+
+// tokenBucket limits a rate of responses for a single peer.
+// Tokens may go below zero, so a single response isn't limited by the burst size,
+// but following responses are delayed until the debt is paid off.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst uint64, now time.Time) *tokenBucket {
+	if burst == 0 {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// delay returns a time to wait until a response may be sent, or 0 if it may be sent now.
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens > 0 {
+		return 0
+	}
+	d := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// take consumes the tokens for a sent response.
+func (b *tokenBucket) take(size uint64, now time.Time) {
+	b.refill(now)
+	b.tokens -= float64(size)
+}
@@ -1,6 +1,7 @@
 package basestreamseeder
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -13,6 +14,7 @@ import (
 var (
 	ErrSelectorMismatch = errors.New("session selector mismatch")
 	ErrTooManyChunks    = errors.New("too many request chunks")
+	ErrPeerBanned       = errors.New("peer is banned")
 	errTerminated       = errors.New("terminated")
 )
 
@@ -22,6 +24,13 @@ type BaseSeeder struct {
 	peerSessions map[string][]uint32
 	sessions     map[sessionIDAndPeer]sessionState
 
+	// pending chunks of each peer, which are served in a round-robin order across peers
+	peerJobs  map[string][]*chunksJob
+	peerRates map[string]*tokenBucket
+	// peers with pending chunks, in a round-robin order
+	queue []string
+	now   func() time.Time
+
 	notifyUnregisteredPeer chan string
 	notifyReceivedRequest  chan *requestAndPeer
 	quit                   chan struct{}
@@ -29,7 +38,9 @@ type BaseSeeder struct {
 
 	cfg Config
 
-	wg sync.WaitGroup
+	wg            sync.WaitGroup
+	terminateOnce sync.Once
+	stopOnce      sync.Once
 
 	senders              []*workers.Workers
 	pendingResponsesSize int64
@@ -41,12 +52,18 @@ func New(cfg Config, callbacks Callbacks) *BaseSeeder {
 		callback:               callbacks,
 		peerSessions:           make(map[string][]uint32),
 		sessions:               make(map[sessionIDAndPeer]sessionState),
+		peerJobs:               make(map[string][]*chunksJob),
+		peerRates:              make(map[string]*tokenBucket),
+		now:                    time.Now,
 		notifyUnregisteredPeer: make(chan string, 128),
 		notifyReceivedRequest:  make(chan *requestAndPeer, 16),
 		senders:                make([]*workers.Workers, cfg.SenderThreads),
 		quit:                   make(chan struct{}),
 		cfg:                    cfg,
 	}
+	if s.cfg.MaxPeerSessions <= 0 {
+		s.cfg.MaxPeerSessions = defaultMaxPeerSessions
+	}
 	for i := 0; i < cfg.SenderThreads; i++ {
 		s.senders[i] = workers.New(&s.wg, s.quit, s.cfg.MaxSenderTasks)
 	}
@@ -55,6 +72,8 @@ func New(cfg Config, callbacks Callbacks) *BaseSeeder {
 
 type Callbacks struct {
 	ForEachItem func(start basestream.Locator, rType basestream.RequestType, onKey func(key basestream.Locator) bool, onAppended func(items basestream.Payload) bool) basestream.Payload
+	// Banned returns true if requests of the peer should be rejected. Optional.
+	Banned func(peer string) bool
 }
 
 type Peer struct {
@@ -73,10 +92,17 @@ type requestAndPeer struct {
 	peer    Peer
 }
 
+type chunksJob struct {
+	sid        sessionIDAndPeer
+	request    basestream.Request
+	chunksLeft uint32
+}
+
 type sessionState struct {
 	origSelector basestream.Locator
 	next         basestream.Locator
 	stop         basestream.Locator
+	compression  basestream.Compression
 	done         bool
 	senderI      int
 	sendChunk    func(basestream.Response) error
@@ -96,15 +122,42 @@ func (s *BaseSeeder) Start() {
 // Stop interrupts the seeder, canceling all the pending operations.
 // Stop waits until all the internal goroutines have finished.
 func (s *BaseSeeder) Stop() {
-	close(s.quit)
-	s.done = true
-	for i := 0; i < s.cfg.SenderThreads; i++ {
-		s.senders[i].Drain()
-	}
-	s.wg.Wait()
+	s.stopOnce.Do(func() {
+		s.terminate()
+		s.wg.Wait()
+	})
+}
+
+// terminate interrupts the seeder, without waiting for its goroutines
+func (s *BaseSeeder) terminate() {
+	s.terminateOnce.Do(func() {
+		close(s.quit)
+		s.done = true
+		for i := 0; i < s.cfg.SenderThreads; i++ {
+			s.senders[i].Drain()
+		}
+	})
+}
+
+// StartContext boots up the seeder, which gets terminated once ctx is done.
+// Stop is still required to wait for the goroutines.
+func (s *BaseSeeder) StartContext(ctx context.Context) {
+	s.Start()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-ctx.Done():
+			s.terminate()
+		case <-s.quit:
+		}
+	}()
 }
 
 func (s *BaseSeeder) NotifyRequestReceived(peer Peer, r basestream.Request) (err error, peerErr error) {
+	if s.callback.Banned != nil && s.callback.Banned(peer.ID) {
+		return nil, ErrPeerBanned
+	}
 	if r.MaxChunks > s.cfg.MaxResponseChunks {
 		return nil, ErrTooManyChunks
 	}
@@ -150,84 +203,215 @@ func (s *BaseSeeder) waitPendingResponsesBelowLimit() {
 
 func (s *BaseSeeder) readerLoop() {
 	for {
+		sent, delay := s.sendNext()
+		if sent {
+			// handle outside events between chunks, but don't wait for them
+			select {
+			case <-s.quit:
+				return
+			case peerID := <-s.notifyUnregisteredPeer:
+				s.onUnregisteredPeer(peerID)
+			case op := <-s.notifyReceivedRequest:
+				s.onRequest(op)
+			default:
+			}
+			continue
+		}
+
+		// all the peers are either idle or rate limited
+		var wake <-chan time.Time
+		var timer *time.Timer
+		if delay > 0 {
+			timer = time.NewTimer(delay)
+			wake = timer.C
+		}
 		// Wait for an outside event to occur
 		select {
 		case <-s.quit:
 			// terminating, abort all operations
 			return
-
 		case peerID := <-s.notifyUnregisteredPeer:
-			sessions := s.peerSessions[peerID]
-			for _, sid := range sessions {
-				delete(s.sessions, sessionIDAndPeer{sid, peerID})
-			}
-			delete(s.peerSessions, peerID)
-
+			s.onUnregisteredPeer(peerID)
 		case op := <-s.notifyReceivedRequest:
-			s.waitPendingResponsesBelowLimit()
-
-			// prune oldest session
-			sessions := s.peerSessions[op.peer.ID]
-			if len(sessions) > 2 {
-				oldest := sessions[0]
-				sessions = sessions[1:]
-				delete(s.sessions, sessionIDAndPeer{oldest, op.peer.ID})
-			}
+			s.onRequest(op)
+		case <-wake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *BaseSeeder) onUnregisteredPeer(peerID string) {
+	sessions := s.peerSessions[peerID]
+	for _, sid := range sessions {
+		delete(s.sessions, sessionIDAndPeer{sid, peerID})
+	}
+	delete(s.peerSessions, peerID)
+	delete(s.peerJobs, peerID)
+	delete(s.peerRates, peerID)
+	s.dequeue(peerID)
+}
+
+func (s *BaseSeeder) onRequest(op *requestAndPeer) {
+	sid := sessionIDAndPeer{op.request.Session.ID, op.peer.ID}
+	session, ok := s.sessions[sid]
+	if !ok {
+		// prune oldest sessions
+		sessions := s.peerSessions[op.peer.ID]
+		for len(sessions) >= s.cfg.MaxPeerSessions {
+			delete(s.sessions, sessionIDAndPeer{sessions[0], op.peer.ID})
+			sessions = sessions[1:]
+		}
+		// add session
+		session.origSelector = op.request.Session.Start
+		session.next = op.request.Session.Start
+		session.stop = op.request.Session.Stop
+		session.compression = s.negotiateCompression(op.request.Compression)
+		session.sendChunk = op.peer.SendChunk
+		session.senderI = int(s.sessionsCounter % uint32(s.cfg.SenderThreads))
+		s.sessions[sid] = session
+		s.peerSessions[op.peer.ID] = append(sessions, op.request.Session.ID)
+		s.sessionsCounter++
+	}
 
-			// add session
-			session, ok := s.sessions[sessionIDAndPeer{op.request.Session.ID, op.peer.ID}]
-			if !ok {
-				session.origSelector = op.request.Session.Start
-				session.next = op.request.Session.Start
-				session.stop = op.request.Session.Stop
-				session.sendChunk = op.peer.SendChunk
-				session.senderI = int(s.sessionsCounter % uint32(s.cfg.SenderThreads))
-				sessions = append(sessions, op.request.Session.ID)
-				s.peerSessions[op.peer.ID] = sessions
-				s.sessionsCounter++
+	// sanity check (cannot change session parameters after it's created)
+	if session.origSelector.Compare(op.request.Session.Start) != 0 {
+		op.peer.Misbehaviour(ErrSelectorMismatch)
+		return
+	}
+
+	if op.request.MaxChunks == 0 || session.done {
+		return
+	}
+	// merge with a pending job of the same session, so a number of jobs is limited by a number of sessions
+	for _, job := range s.peerJobs[op.peer.ID] {
+		if job.sid == sid {
+			job.request = op.request
+			job.chunksLeft += op.request.MaxChunks
+			if job.chunksLeft > s.cfg.MaxResponseChunks {
+				job.chunksLeft = s.cfg.MaxResponseChunks
 			}
+			return
+		}
+	}
+	if s.cfg.MaxPeerResponsesRate != 0 && s.peerRates[op.peer.ID] == nil {
+		s.peerRates[op.peer.ID] = newTokenBucket(s.cfg.MaxPeerResponsesRate, s.cfg.PeerResponsesBurst, s.now())
+	}
+	if len(s.peerJobs[op.peer.ID]) == 0 {
+		s.queue = append(s.queue, op.peer.ID)
+	}
+	s.peerJobs[op.peer.ID] = append(s.peerJobs[op.peer.ID], &chunksJob{
+		sid:        sid,
+		request:    op.request,
+		chunksLeft: op.request.MaxChunks,
+	})
+}
 
-			// sanity check (cannot change session parameters after it's created)
-			if session.origSelector.Compare(op.request.Session.Start) != 0 {
-				op.peer.Misbehaviour(ErrSelectorMismatch)
+// negotiateCompression returns the requested compression if it's supported, or no compression otherwise
+func (s *BaseSeeder) negotiateCompression(c basestream.Compression) basestream.Compression {
+	for _, supported := range s.cfg.Compressions {
+		if supported == c {
+			return c
+		}
+	}
+	return basestream.CompressionNone
+}
+
+func (s *BaseSeeder) dequeue(peerID string) {
+	for i, p := range s.queue {
+		if p == peerID {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// sendNext sends a chunk of the next peer in a round-robin order, skipping rate limited peers.
+// If no chunks were sent, it returns a time to wait until a rate limited peer may proceed.
+func (s *BaseSeeder) sendNext() (sent bool, delay time.Duration) {
+	now := s.now()
+	for n := len(s.queue); n > 0; n-- {
+		peerID := s.queue[0]
+		s.queue = s.queue[1:]
+		if rate := s.peerRates[peerID]; rate != nil {
+			if d := rate.delay(now); d > 0 {
+				s.queue = append(s.queue, peerID)
+				if delay == 0 || d < delay {
+					delay = d
+				}
 				continue
 			}
+		}
+		s.sendChunk(peerID)
+		if len(s.peerJobs[peerID]) != 0 {
+			s.queue = append(s.queue, peerID)
+		} else {
+			delete(s.peerJobs, peerID)
+		}
+		return true, 0
+	}
+	return false, delay
+}
 
-			for i := uint32(0); i < op.request.MaxChunks && !session.done; i++ {
-				allConsumed := true
-				resp := basestream.Response{}
-				lastKey := session.next
-				resp.Payload = s.callback.ForEachItem(session.next, op.request.Type, func(key basestream.Locator) bool {
-					if key.Compare(session.stop) >= 0 {
-						return false
-					}
-					lastKey = key
-					return true
-				}, func(items basestream.Payload) bool {
-					numReached := uint32(items.Len()) >= op.request.MaxPayloadNum
-					sizeReached := items.TotalSize() >= op.request.MaxPayloadSize
-					if numReached || sizeReached {
-						allConsumed = false
-						return false
-					}
-					return true
-				})
-				// update session
-				session.next = lastKey.Inc()
-				session.done = allConsumed
-				s.sessions[sessionIDAndPeer{op.request.Session.ID, op.peer.ID}] = session
-
-				resp.Done = allConsumed
-				resp.SessionID = op.request.Session.ID
-
-				memSize := resp.Payload.TotalMemSize()
-				s.waitPendingResponsesBelowLimit()
-				atomic.AddInt64(&s.pendingResponsesSize, int64(memSize))
-				_ = s.senders[session.senderI].Enqueue(func() {
-					_ = session.sendChunk(resp)
-					atomic.AddInt64(&s.pendingResponsesSize, -int64(memSize))
-				})
-			}
+// sendChunk sends a next chunk of the peer's oldest job.
+func (s *BaseSeeder) sendChunk(peerID string) {
+	jobs := s.peerJobs[peerID]
+	job := jobs[0]
+	session, ok := s.sessions[job.sid]
+	if !ok || session.done {
+		// session is pruned or finished
+		s.peerJobs[peerID] = jobs[1:]
+		return
+	}
+
+	s.waitPendingResponsesBelowLimit()
+
+	allConsumed := true
+	resp := basestream.Response{}
+	lastKey := session.next
+	resp.Payload = s.callback.ForEachItem(session.next, job.request.Type, func(key basestream.Locator) bool {
+		if key.Compare(session.stop) >= 0 {
+			return false
+		}
+		lastKey = key
+		return true
+	}, func(items basestream.Payload) bool {
+		numReached := uint32(items.Len()) >= job.request.MaxPayloadNum
+		sizeReached := items.TotalSize() >= job.request.MaxPayloadSize
+		if numReached || sizeReached {
+			allConsumed = false
+			return false
+		}
+		return true
+	})
+	// update session
+	session.next = lastKey.Inc()
+	session.done = allConsumed
+	s.sessions[job.sid] = session
+
+	job.chunksLeft--
+	if job.chunksLeft == 0 || session.done {
+		s.peerJobs[peerID] = jobs[1:]
+	}
+	// compress before accounting, so the limits are applied to the sent bytes
+	if p, ok := resp.Payload.(basestream.CompressiblePayload); ok && session.compression != basestream.CompressionNone {
+		if compressed, err := p.Compress(session.compression); err == nil {
+			resp.Payload = compressed
+			resp.Compression = session.compression
 		}
 	}
+	if rate := s.peerRates[peerID]; rate != nil {
+		rate.take(resp.Payload.TotalSize(), s.now())
+	}
+
+	resp.Done = allConsumed
+	resp.SessionID = job.request.Session.ID
+
+	memSize := resp.Payload.TotalMemSize()
+	atomic.AddInt64(&s.pendingResponsesSize, int64(memSize))
+	_ = s.senders[session.senderI].Enqueue(func() {
+		_ = session.sendChunk(resp)
+		atomic.AddInt64(&s.pendingResponsesSize, -int64(memSize))
+	})
 }
@@ -193,3 +193,170 @@ func testSeederResponsesOrder(t *testing.T, maxPeers int, maxEvents int) {
 		}
 	}
 }
+
+func newOrderedEvents(num int) dag.Events {
+	events := make(dag.Events, num)
+	for i := range events {
+		e := &tdag.TestEvent{}
+		// testLocator.Inc strips leading zeros, so IDs must not start with a zero byte
+		e.SetEpoch(1 << 24)
+		var rID [24]byte
+		copy(rID[:], big.NewInt(int64(i+1)).Bytes())
+		events[i] = e.Build(rID)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return bytes.Compare(events[i].ID().Bytes(), events[j].ID().Bytes()) < 0
+	})
+	return events
+}
+
+func forEachTestItem(events dag.Events) func(start basestream.Locator, rType basestream.RequestType, onKey func(key basestream.Locator) bool, onAppended func(items basestream.Payload) bool) basestream.Payload {
+	return func(start basestream.Locator, rType basestream.RequestType, onKey func(key basestream.Locator) bool, onAppended func(items basestream.Payload) bool) basestream.Payload {
+		res := testPayload{}
+		for _, e := range events {
+			if bytes.Compare(e.ID().Bytes(), start.(testLocator).B) < 0 {
+				continue
+			}
+			if !onKey(testLocator{e.ID().Bytes()}) {
+				break
+			}
+			res.IDs = append(res.IDs, e.ID())
+			res.Events = append(res.Events, e)
+			res.Size += uint64(e.Size())
+			if !onAppended(res) {
+				break
+			}
+		}
+		return res
+	}
+}
+
+func oneEventRequest(sessionID uint32, events dag.Events, chunks uint32) basestream.Request {
+	return basestream.Request{
+		Session: basestream.Session{
+			ID:    sessionID,
+			Start: testLocator{events[0].ID().Bytes()},
+			Stop:  testLocator{events[len(events)-1].ID().Bytes()},
+		},
+		Type:           1,
+		MaxPayloadNum:  1,
+		MaxPayloadSize: 1 << 20,
+		MaxChunks:      chunks,
+	}
+}
+
+func TestSeederFairness(t *testing.T) {
+	config := defaultConfig()
+	config.SenderThreads = 1
+	events := newOrderedEvents(100)
+	seeder := New(config, Callbacks{
+		ForEachItem: forEachTestItem(events),
+	})
+
+	var mu sync.Mutex
+	var sent []string
+	peer := func(id string) Peer {
+		return Peer{
+			ID: id,
+			SendChunk: func(response basestream.Response) error {
+				mu.Lock()
+				defer mu.Unlock()
+				sent = append(sent, id)
+				return nil
+			},
+			Misbehaviour: func(err error) {},
+		}
+	}
+
+	// aggressive peer requests many chunks before an honest peer
+	for i := uint32(0); i < 3; i++ {
+		err, peerErr := seeder.NotifyRequestReceived(peer("aggressive"), oneEventRequest(i, events, config.MaxResponseChunks))
+		require.NoError(t, err)
+		require.NoError(t, peerErr)
+	}
+	err, peerErr := seeder.NotifyRequestReceived(peer("honest"), oneEventRequest(0, events, 4))
+	require.NoError(t, err)
+	require.NoError(t, peerErr)
+
+	seeder.Start()
+	defer seeder.Stop()
+	total := 3*int(config.MaxResponseChunks) + 4
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == total
+	}, 5*time.Second, time.Millisecond)
+
+	// honest peer isn't starved, its chunks are interleaved with the aggressive peer's chunks
+	lastHonest := 0
+	for i, p := range sent {
+		if p == "honest" {
+			lastHonest = i
+		}
+	}
+	require.Less(t, lastHonest, 4*3)
+}
+
+func TestSeederPeerSessionsLimit(t *testing.T) {
+	config := defaultConfig()
+	config.MaxPeerSessions = 2
+	events := newOrderedEvents(10)
+	seeder := New(config, Callbacks{
+		ForEachItem: forEachTestItem(events),
+	})
+	seeder.Start()
+	defer seeder.Stop()
+
+	p := Peer{
+		ID:           "peer",
+		SendChunk:    func(response basestream.Response) error { return nil },
+		Misbehaviour: func(err error) {},
+	}
+	for i := uint32(0); i < 5; i++ {
+		err, peerErr := seeder.NotifyRequestReceived(p, oneEventRequest(i, events, 1))
+		require.NoError(t, err)
+		require.NoError(t, peerErr)
+	}
+	// an unregistration is handled after the requests, when all the sessions are created
+	require.NoError(t, seeder.UnregisterPeer("other"))
+	require.Eventually(t, func() bool {
+		return len(seeder.notifyUnregisteredPeer) == 0 && len(seeder.notifyReceivedRequest) == 0
+	}, time.Second, time.Millisecond)
+	seeder.Stop()
+	require.Equal(t, []uint32{3, 4}, seeder.peerSessions["peer"])
+	require.Len(t, seeder.sessions, 2)
+}
+
+func TestSeederRateLimit(t *testing.T) {
+	events := newOrderedEvents(20)
+	eventSize := uint64(events[0].Size())
+
+	config := defaultConfig()
+	config.MaxPeerResponsesRate = 100 * eventSize
+	config.PeerResponsesBurst = eventSize
+	seeder := New(config, Callbacks{
+		ForEachItem: forEachTestItem(events),
+	})
+	seeder.Start()
+	defer seeder.Stop()
+
+	const chunks = 11
+	var sent int32
+	start := time.Now()
+	err, peerErr := seeder.NotifyRequestReceived(Peer{
+		ID: "peer",
+		SendChunk: func(response basestream.Response) error {
+			atomic.AddInt32(&sent, 1)
+			return nil
+		},
+		Misbehaviour: func(err error) {},
+	}, oneEventRequest(0, events, chunks))
+	require.NoError(t, err)
+	require.NoError(t, peerErr)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&sent) == chunks
+	}, 5*time.Second, time.Millisecond)
+	// first chunk is covered by the burst, each next chunk takes 10ms
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
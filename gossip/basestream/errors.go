@@ -0,0 +1,23 @@
+package basestream
+
+import "errors"
+
+// LocalError is an error of a payload delivery, which is caused by the local node rather than by a peer,
+// e.g. by a full processing queue. Leechers retry such deliveries and don't report the peer as misbehaving.
+type LocalError struct {
+	Err error
+}
+
+func (e *LocalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LocalError) Unwrap() error {
+	return e.Err
+}
+
+// IsLocalError returns true if err is or wraps a LocalError
+func IsLocalError(err error) bool {
+	var local *LocalError
+	return errors.As(err, &local)
+}
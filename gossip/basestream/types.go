@@ -6,12 +6,16 @@ type Request struct {
 	MaxPayloadNum  uint32
 	MaxPayloadSize uint64
 	MaxChunks      uint32
+	// Compression is a preferred payload compression of the session
+	Compression Compression
 }
 
 type Response struct {
 	SessionID uint32
 	Done      bool
 	Payload   Payload
+	// Compression is a compression of the payload
+	Compression Compression
 }
 
 type Session struct {
@@ -31,4 +35,20 @@ type Payload interface {
 	TotalMemSize() int
 }
 
+// CompressiblePayload is a Payload which may be compressed before sending
+type CompressiblePayload interface {
+	Payload
+	// Compress returns the compressed payload, whose TotalSize is a size of the compressed data
+	Compress(c Compression) (Payload, error)
+}
+
 type RequestType uint8
+
+// Compression is a payload compression algorithm
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionZstd
+)
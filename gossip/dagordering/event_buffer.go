@@ -3,6 +3,7 @@ package dagordering
 import (
 	"math"
 	"sync"
+	"time"
 
 	"github.com/unicornultrafoundation/go-hashgraph/eventcheck"
 	"github.com/unicornultrafoundation/go-hashgraph/hash"
@@ -19,12 +20,23 @@ type (
 		peer     string
 		err      error
 		released bool
+		// replayed is true if the event is replayed from the quarantine, so it's released already
+		replayed bool
+	}
+
+	// missingRequest is an outstanding request of a missing parent
+	missingRequest struct {
+		at       time.Time
+		attempts int
 	}
 
 	// Callback is a set of EventsBuffer()'s args.
 	Callback struct {
 		Process  func(e dag.Event) error
 		Released func(e dag.Event, peer string, err error)
+		// Replayed is called instead of Released for the events replayed from the quarantine,
+		// which are released with eventcheck.ErrQuarantinedEvent already. Optional.
+		Replayed func(e dag.Event, peer string, err error)
 		Get      func(hash.Event) dag.Event
 		Exists   func(hash.Event) bool
 		Check    func(e dag.Event, parents dag.Events) error
@@ -33,16 +45,34 @@ type (
 
 type EventsBuffer struct {
 	incompletes *wlru.Cache // event hash -> event
-	callback    Callback
-	mu          sync.Mutex
+	// waiting is an index of incomplete events by their missing parents,
+	// so connecting an event wakes up only its dependents
+	waiting map[hash.Event][]*event // missing parent hash -> waiting children
+	// requested is a subset of missing parents, which are already requested
+	requested map[hash.Event]*missingRequest
+	callback  Callback
+	mu        sync.Mutex
+
+	// quarantine keeps the spilled events, optional
+	quarantine *Quarantine
 
 	limit dag.Metric
 }
 
 func New(limit dag.Metric, callback Callback) *EventsBuffer {
+	return NewWithQuarantine(limit, nil, callback)
+}
+
+// NewWithQuarantine creates EventsBuffer, which moves spilled events into the quarantine instead of dropping them.
+// Quarantined events are released with eventcheck.ErrQuarantinedEvent, and replayed once their parents are connected.
+// The result of a replay is reported with Callback.Replayed.
+func NewWithQuarantine(limit dag.Metric, quarantine *Quarantine, callback Callback) *EventsBuffer {
 	buf := &EventsBuffer{
-		callback: callback,
-		limit:    limit,
+		callback:   callback,
+		limit:      limit,
+		waiting:    make(map[hash.Event][]*event),
+		requested:  make(map[hash.Event]*missingRequest),
+		quarantine: quarantine,
 	}
 	buf.incompletes, _ = wlru.New(math.MaxInt32, math.MaxInt32)
 	return buf
@@ -57,78 +87,234 @@ func (buf *EventsBuffer) PushEvent(de dag.Event, peer string) (complete bool) {
 	buf.mu.Lock()
 	defer buf.mu.Unlock()
 
-	if _, ok := buf.incompletes.Peek(e.event.ID()); ok {
-		// duplicate
+	if buf.IsBuffered(e.event.ID()) {
+		// duplicate, either buffered or quarantined
 		buf.dropEvent(e, eventcheck.ErrDuplicateEvent)
 		buf.releaseEvent(e)
 		return false
 	}
-	complete = buf.pushEvent(e, nil, false)
-	buf.spillIncompletes(buf.limit)
+	complete = buf.pushEvent(e)
+	buf.spillIncompletes(buf.limit, true)
 	return complete
 }
 
-func (buf *EventsBuffer) pushEvent(e *event, incompleteEventsList []*event, recheck bool) bool {
+func (buf *EventsBuffer) pushEvent(e *event) bool {
+	if !buf.connectEvent(e, false) {
+		return false
+	}
+	// now child events may become complete, check them again
+	buf.connectChildren(buf.popWaiting(e.event.ID()), buf.popQuarantined(e.event.ID()))
+	return true
+}
+
+// connectChildren connects the buffered and the quarantined events, which may become complete, and their descendants
+func (buf *EventsBuffer) connectChildren(queue, quarantined []*event) {
+	for len(queue) != 0 || len(quarantined) != 0 {
+		var child *event
+		var connected bool
+		if len(queue) != 0 {
+			child = queue[0]
+			queue = queue[1:]
+			connected = buf.connectEvent(child, true)
+		} else {
+			// quarantined events aren't buffered, so they are connected as new ones
+			child = quarantined[0]
+			quarantined = quarantined[1:]
+			if _, ok := buf.incompletes.Peek(child.event.ID()); ok {
+				// a fresh copy of the event is buffered already
+				buf.dropEvent(child, eventcheck.ErrDuplicateEvent)
+				buf.releaseEvent(child)
+				continue
+			}
+			connected = buf.connectEvent(child, false)
+		}
+		if connected {
+			queue = append(queue, buf.popWaiting(child.event.ID())...)
+			quarantined = append(quarantined, buf.popQuarantined(child.event.ID())...)
+		}
+	}
+}
+
+// ReplayQuarantined replays the quarantined events, whose missing parents are connected already,
+// but not through the buffer, e.g. before a restart.
+func (buf *EventsBuffer) ReplayQuarantined() {
+	if buf.quarantine == nil {
+		return
+	}
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	for _, p := range buf.quarantine.waitedParents() {
+		if buf.callback.Exists(p) {
+			buf.connectChildren(nil, buf.popQuarantined(p))
+		}
+	}
+	buf.spillIncompletes(buf.limit, true)
+}
+
+// popQuarantined returns and removes the quarantined events which are waiting for the parent
+func (buf *EventsBuffer) popQuarantined(parent hash.Event) []*event {
+	if buf.quarantine == nil {
+		return nil
+	}
+	return buf.quarantine.popChildren(parent)
+}
+
+// connectEvent processes the event if it's complete, or buffers it otherwise.
+// recheck is true if the event is already buffered.
+func (buf *EventsBuffer) connectEvent(e *event, recheck bool) bool {
 	if buf.callback.Exists(e.event.ID()) {
-		buf.incompletes.Remove(e.event.ID())
+		buf.forgetIncomplete(e)
 		if !recheck {
 			buf.dropEvent(e, eventcheck.ErrAlreadyConnectedEvent)
 		}
 		buf.releaseEvent(e)
 		return false
 	}
-	parents := buf.completeEventParents(e)
-	if parents == nil {
+	parents, missing := buf.completeEventParents(e)
+	if len(missing) != 0 {
 		if !recheck {
 			buf.incompletes.Add(e.event.ID(), e, uint(e.event.Size()))
+			for _, p := range missing {
+				buf.waiting[p] = append(buf.waiting[p], e)
+			}
 		}
 		return false
 	}
 
 	ok := buf.processCompleteEvent(e, parents)
 	buf.releaseEvent(e)
+	buf.forgetIncomplete(e)
+	return ok
+}
 
-	if ok {
-		// now child events may become complete, check it again
-		eHash := e.event.ID()
-		if incompleteEventsList == nil {
-			incompleteEventsList = buf.getIncompleteEventsList()
-		}
-		for _, child := range incompleteEventsList {
-			for _, parent := range child.event.Parents() {
-				if parent == eHash {
-					buf.pushEvent(child, incompleteEventsList, true)
-					break
-				}
-			}
-		}
+// popWaiting returns and unindexes the events which are waiting for the parent
+func (buf *EventsBuffer) popWaiting(parent hash.Event) []*event {
+	children := buf.waiting[parent]
+	delete(buf.waiting, parent)
+	delete(buf.requested, parent)
+	return children
+}
+
+// forgetIncomplete removes the event from the buffer and from the waiting index
+func (buf *EventsBuffer) forgetIncomplete(e *event) {
+	if !buf.incompletes.Remove(e.event.ID()) {
+		return
 	}
-	buf.incompletes.Remove(e.event.ID())
-	return ok
+	buf.unindexWaiting(e)
 }
 
-func (buf *EventsBuffer) getIncompleteEventsList() []*event {
-	res := make([]*event, 0, buf.incompletes.Len())
-	for _, childID := range buf.incompletes.Keys() {
-		child, _ := buf.incompletes.Peek(childID)
-		if child == nil {
+func (buf *EventsBuffer) unindexWaiting(e *event) {
+	for _, p := range e.event.Parents() {
+		children, ok := buf.waiting[p]
+		if !ok {
 			continue
 		}
-		res = append(res, child.(*event))
+		for i := 0; i < len(children); i++ {
+			if children[i] == e {
+				children = append(children[:i], children[i+1:]...)
+				i--
+			}
+		}
+		if len(children) == 0 {
+			delete(buf.waiting, p)
+			delete(buf.requested, p)
+		} else {
+			buf.waiting[p] = children
+		}
 	}
-	return res
 }
 
-func (buf *EventsBuffer) completeEventParents(e *event) dag.Events {
-	parents := make(dag.Events, len(e.event.Parents()))
+// completeEventParents returns the event's parents, or the missing parents if some of them are unknown
+func (buf *EventsBuffer) completeEventParents(e *event) (parents dag.Events, missing hash.Events) {
+	parents = make(dag.Events, len(e.event.Parents()))
 	for i, p := range e.event.Parents() {
 		parent := buf.callback.Get(p)
 		if parent == nil {
-			return nil
+			if !containsEvent(missing, p) {
+				missing = append(missing, p)
+			}
+			continue
 		}
 		parents[i] = parent
 	}
-	return parents
+	return parents, missing
+}
+
+// MissingParents returns the parents which are missing for the buffered events
+func (buf *EventsBuffer) MissingParents() hash.Events {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	missing := make(hash.Events, 0, len(buf.waiting))
+	for p := range buf.waiting {
+		missing = append(missing, p)
+	}
+	return missing
+}
+
+// RequestMissingParents returns the missing parents of a buffered event, which weren't requested yet.
+// The returned parents are marked as requested, so they won't be returned again until they expire.
+func (buf *EventsBuffer) RequestMissingParents(id hash.Event) hash.Events {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	val, ok := buf.incompletes.Peek(id)
+	if !ok {
+		return nil
+	}
+	e := val.(*event)
+	now := time.Now()
+	var toRequest hash.Events
+	for _, p := range e.event.Parents() {
+		if _, waited := buf.waiting[p]; !waited {
+			continue
+		}
+		if _, requested := buf.requested[p]; requested {
+			continue
+		}
+		buf.requested[p] = &missingRequest{
+			at: now,
+		}
+		toRequest = append(toRequest, p)
+	}
+	return toRequest
+}
+
+// ExpiredRequests returns the requested missing parents which didn't arrive within the timeout, grouped by peers.
+// Every re-request is addressed to another peer which sent an event waiting for the parent, if there are many.
+// The returned parents are marked as requested again.
+func (buf *EventsBuffer) ExpiredRequests(timeout time.Duration) map[string]hash.Events {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	now := time.Now()
+	res := make(map[string]hash.Events)
+	for p, r := range buf.requested {
+		if now.Sub(r.at) < timeout {
+			continue
+		}
+		children := buf.waiting[p]
+		if len(children) == 0 {
+			// unreachable, requested parents are always waited
+			delete(buf.requested, p)
+			continue
+		}
+		r.attempts++
+		r.at = now
+		peer := children[r.attempts%len(children)].peer
+		res[peer] = append(res[peer], p)
+	}
+	return res
+}
+
+func containsEvent(ids hash.Events, id hash.Event) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
 }
 
 func (buf *EventsBuffer) processCompleteEvent(e *event, parents dag.Events) bool {
@@ -151,14 +337,24 @@ func (buf *EventsBuffer) processCompleteEvent(e *event, parents dag.Events) bool
 	return true
 }
 
-func (buf *EventsBuffer) spillIncompletes(limit dag.Metric) {
+// spillIncompletes drops the oldest incomplete events until the limit is met.
+// If toQuarantine is true, then the events are moved into the quarantine if possible.
+func (buf *EventsBuffer) spillIncompletes(limit dag.Metric, toQuarantine bool) {
 	for idx.Event(buf.incompletes.Len()) > limit.Num || uint64(buf.incompletes.Weight()) > limit.Size {
 		_, val, ok := buf.incompletes.RemoveOldest()
 		if !ok {
 			break
 		}
 		e := val.(*event)
-		buf.dropEvent(e, eventcheck.ErrSpilledEvent)
+		buf.unindexWaiting(e)
+		err := eventcheck.ErrSpilledEvent
+		if toQuarantine && buf.quarantine != nil && e.err == nil {
+			_, missing := buf.completeEventParents(e)
+			if buf.quarantine.put(e.event, e.peer, missing) {
+				err = eventcheck.ErrQuarantinedEvent
+			}
+		}
+		buf.dropEvent(e, err)
 		buf.releaseEvent(e)
 	}
 }
@@ -170,21 +366,28 @@ func (buf *EventsBuffer) dropEvent(e *event, err error) {
 }
 
 func (buf *EventsBuffer) releaseEvent(e *event) {
-	if buf.callback.Released != nil && !e.released {
-		buf.callback.Released(e.event, e.peer, e.err)
+	if !e.released {
+		if !e.replayed {
+			if buf.callback.Released != nil {
+				buf.callback.Released(e.event, e.peer, e.err)
+			}
+		} else if buf.callback.Replayed != nil {
+			buf.callback.Replayed(e.event, e.peer, e.err)
+		}
 	}
 	e.released = true
 }
 
+// IsBuffered returns true if the event is either buffered or quarantined
 func (buf *EventsBuffer) IsBuffered(id hash.Event) bool {
-	// wlru is thread-safe, no need for a mutex here
-	return buf.incompletes.Contains(id)
+	// wlru and quarantine are thread-safe, no need for a mutex here
+	return buf.incompletes.Contains(id) || (buf.quarantine != nil && buf.quarantine.Contains(id))
 }
 
 func (buf *EventsBuffer) Clear() {
 	buf.mu.Lock()
 	defer buf.mu.Unlock()
-	buf.spillIncompletes(dag.Metric{})
+	buf.spillIncompletes(dag.Metric{}, false)
 }
 
 // Total returns the total weight and number of items in the cache.
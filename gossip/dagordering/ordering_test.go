@@ -8,10 +8,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck"
 	"github.com/unicornultrafoundation/go-hashgraph/hash"
 	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
 	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
 	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
 )
 
 func TestEventsBuffer(t *testing.T) {
@@ -99,6 +104,62 @@ func testEventsBuffer(t *testing.T, try int64) {
 	if checked != len(processed) {
 		t.Fatal("not all the events were checked")
 	}
+	// nothing is left in the buffer
+	if buffer.Total().Num != 0 || len(buffer.waiting) != 0 {
+		t.Fatal("buffer isn't empty", buffer.Total().Num, len(buffer.waiting))
+	}
+}
+
+func TestEventsBufferLarge(t *testing.T) {
+	nodes := tdag.GenNodes(5)
+
+	var ordered dag.Events
+	_ = tdag.ForEachRandEvent(nodes, 4000, 3, rand.New(rand.NewSource(0)), tdag.ForEachEvent{ // nolint:gosec
+		Process: func(e dag.Event, name string) {
+			ordered = append(ordered, e)
+		},
+		Build: func(e dag.MutableEvent, name string) error {
+			e.SetEpoch(1)
+			e.SetFrame(idx.Frame(e.Seq()))
+			return nil
+		},
+	})
+
+	processed := make(map[hash.Event]dag.Event)
+	buffer := New(dag.Metric{Num: idx.Event(len(ordered)), Size: ordered.Metric().Size}, Callback{
+		Process: func(e dag.Event) error {
+			for _, p := range e.Parents() {
+				if _, ok := processed[p]; !ok {
+					t.Fatalf("got %s before parent %s", e.String(), p.String())
+				}
+			}
+			processed[e.ID()] = e
+			return nil
+		},
+		Released: func(e dag.Event, peer string, err error) {
+			if err != nil {
+				t.Fatalf("%s unexpectedly dropped with '%s'", e.String(), err)
+			}
+		},
+		Exists: func(id hash.Event) bool {
+			return processed[id] != nil
+		},
+		Get: func(id hash.Event) dag.Event {
+			return processed[id]
+		},
+	})
+
+	// worst case: every event is buffered until the first one arrives
+	for i := len(ordered) - 1; i >= 0; i-- {
+		buffer.PushEvent(ordered[i], "")
+	}
+
+	if len(processed) != len(ordered) {
+		t.Fatal("not all the events were processed", len(processed), len(ordered))
+	}
+	if buffer.Total().Num != 0 || len(buffer.waiting) != 0 {
+		t.Fatal("buffer isn't empty", buffer.Total().Num, len(buffer.waiting))
+	}
 }
 
 func TestEventsBufferReleasing(t *testing.T) {
@@ -209,4 +270,366 @@ func testEventsBufferReleasing(t *testing.T, maxEvents int, try int64) {
 	if uint32(len(ordered)) != released {
 		t.Fatal("not all the events were released", len(ordered), released)
 	}
+	// the waiting index doesn't leak
+	if len(buffer.waiting) != 0 {
+		t.Fatal("waiting index isn't empty", len(buffer.waiting))
+	}
+}
+
+func TestEventsBufferMissingParents(t *testing.T) {
+	nodes := tdag.GenNodes(3)
+	newEvent := func(creator idx.ValidatorID, lamport idx.Lamport, parents ...dag.Event) dag.Event {
+		e := &tdag.TestEvent{}
+		e.SetCreator(creator)
+		e.SetSeq(1)
+		e.SetLamport(lamport)
+		e.SetEpoch(1)
+		for _, p := range parents {
+			e.AddParent(p.ID())
+		}
+		e.SetID([24]byte{byte(creator), byte(lamport)})
+		return e
+	}
+	root := newEvent(nodes[0], 1)
+	a := newEvent(nodes[1], 2, root)
+	b := newEvent(nodes[2], 2, root)
+
+	processed := make(map[hash.Event]dag.Event)
+	buffer := New(dag.Metric{Num: 10, Size: 10000}, Callback{
+		Process: func(e dag.Event) error {
+			processed[e.ID()] = e
+			return nil
+		},
+		Exists: func(id hash.Event) bool {
+			return processed[id] != nil
+		},
+		Get: func(id hash.Event) dag.Event {
+			return processed[id]
+		},
+	})
+
+	// push the children without the common parent
+	if buffer.PushEvent(a, "peerA") {
+		t.Fatal("event is complete without parents")
+	}
+	missing := buffer.RequestMissingParents(a.ID())
+	if len(missing) != 1 || missing[0] != root.ID() {
+		t.Fatal("wrong missing parents", missing)
+	}
+	// the request is deduplicated across peers
+	buffer.PushEvent(b, "peerB")
+	if missing := buffer.RequestMissingParents(b.ID()); len(missing) != 0 {
+		t.Fatal("already requested parent is requested again", missing)
+	}
+	if missing := buffer.MissingParents(); len(missing) != 1 || missing[0] != root.ID() {
+		t.Fatal("wrong missing parents", missing)
+	}
+
+	// re-requests rotate the peers
+	if len(buffer.ExpiredRequests(time.Hour)) != 0 {
+		t.Fatal("request expired too early")
+	}
+	peers := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		for peer, ids := range buffer.ExpiredRequests(0) {
+			if len(ids) != 1 || ids[0] != root.ID() {
+				t.Fatal("wrong re-requested parents", ids)
+			}
+			peers[peer] = true
+		}
+	}
+	if !peers["peerA"] || !peers["peerB"] {
+		t.Fatal("re-requests aren't addressed to all the peers", peers)
+	}
+
+	// the parent arrives
+	if !buffer.PushEvent(root, "peerC") {
+		t.Fatal("parentless event isn't complete")
+	}
+	if len(processed) != 3 {
+		t.Fatal("waiting children aren't processed")
+	}
+	if len(buffer.MissingParents()) != 0 || len(buffer.ExpiredRequests(0)) != 0 || len(buffer.requested) != 0 {
+		t.Fatal("arrived parent is still missing")
+	}
+
+	// spilled events don't leave missing parents
+	buffer.PushEvent(newEvent(nodes[1], 5, newEvent(nodes[0], 4)), "peerA")
+	if len(buffer.MissingParents()) != 1 {
+		t.Fatal("parent isn't reported as missing")
+	}
+	buffer.Clear()
+	if len(buffer.MissingParents()) != 0 || len(buffer.requested) != 0 {
+		t.Fatal("missing parents are leaked")
+	}
+}
+
+// testQuarantineCallback unmarshals events by their IDs
+func testQuarantineCallback(events dag.Events) QuarantineCallback {
+	byID := make(map[hash.Event]dag.Event, len(events))
+	for _, e := range events {
+		byID[e.ID()] = e
+	}
+	return QuarantineCallback{
+		Marshal: func(e dag.Event) ([]byte, error) {
+			return e.(*tdag.TestEvent).Bytes(), nil
+		},
+		Unmarshal: func(b []byte) (dag.Event, error) {
+			var m tdag.TestEventMarshaling
+			if err := rlp.DecodeBytes(b, &m); err != nil {
+				return nil, err
+			}
+			return byID[m.ID], nil
+		},
+	}
+}
+
+func TestEventsBufferQuarantine(t *testing.T) {
+	nodes := tdag.GenNodes(5)
+
+	var ordered dag.Events
+	_ = tdag.ForEachRandEvent(nodes, 50, 3, rand.New(rand.NewSource(0)), tdag.ForEachEvent{ // nolint:gosec
+		Process: func(e dag.Event, name string) {
+			ordered = append(ordered, e)
+		},
+		Build: func(e dag.MutableEvent, name string) error {
+			e.SetEpoch(1)
+			return nil
+		},
+	})
+
+	db := memorydb.New()
+	crit := func(err error) {
+		t.Fatal(err)
+	}
+	quarantine := NewQuarantine(db, dag.Metric{Num: idx.Event(len(ordered)), Size: ordered.Metric().Size}, testQuarantineCallback(ordered), crit)
+
+	processed := make(map[hash.Event]dag.Event)
+	released := make(map[hash.Event]int)
+	callback := Callback{
+		Process: func(e dag.Event) error {
+			require.NotContains(t, processed, e.ID())
+			for _, p := range e.Parents() {
+				require.Contains(t, processed, p, "got %s before parent %s", e.String(), p.String())
+			}
+			processed[e.ID()] = e
+			return nil
+		},
+		Released: func(e dag.Event, peer string, err error) {
+			released[e.ID()]++
+			if err != nil {
+				require.ErrorIs(t, err, eventcheck.ErrQuarantinedEvent)
+			}
+		},
+		Exists: func(id hash.Event) bool {
+			return processed[id] != nil
+		},
+		Get: func(id hash.Event) dag.Event {
+			return processed[id]
+		},
+	}
+	buffer := NewWithQuarantine(dag.Metric{Num: 3, Size: ordered.Metric().Size}, quarantine, callback)
+
+	// push all the events except the parentless ones in a reversed order, so all of them are incomplete
+	var roots, rest dag.Events
+	for _, e := range ordered {
+		if len(e.Parents()) == 0 {
+			roots = append(roots, e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	for i := len(rest) - 1; i >= 0; i-- {
+		buffer.PushEvent(rest[i], "peer")
+	}
+	require.Empty(t, processed)
+	require.Equal(t, idx.Event(3), buffer.Total().Num)
+	require.Equal(t, idx.Event(len(rest)-3), quarantine.Total().Num)
+	for _, e := range rest {
+		require.True(t, buffer.IsBuffered(e.ID()))
+	}
+
+	// quarantine survives a restart
+	quarantine = NewQuarantine(db, dag.Metric{Num: idx.Event(len(ordered)), Size: ordered.Metric().Size}, testQuarantineCallback(ordered), crit)
+	require.Equal(t, idx.Event(len(rest)-3), quarantine.Total().Num)
+	restarted := NewWithQuarantine(dag.Metric{Num: 3, Size: ordered.Metric().Size}, quarantine, callback)
+	for _, e := range buffer.incompletes.Keys() {
+		val, _ := buffer.incompletes.Peek(e)
+		restarted.PushEvent(val.(*event).event, "peer")
+	}
+	for _, e := range roots {
+		restarted.PushEvent(e, "peer")
+	}
+
+	// all the events are replayed from the quarantine, and every event is released exactly once
+	require.Len(t, processed, len(ordered))
+	for _, e := range ordered {
+		require.Equal(t, 1, released[e.ID()], e.String())
+	}
+	require.Equal(t, dag.Metric{}, quarantine.Total())
+	require.Equal(t, idx.Event(0), restarted.Total().Num)
+}
+
+func TestEventsBufferQuarantineReplayFailure(t *testing.T) {
+	parent := &tdag.TestEvent{}
+	parent.SetEpoch(1)
+	parent.SetSeq(1)
+	parent.SetID([24]byte{1})
+	children := make(dag.Events, 2)
+	for i := range children {
+		e := &tdag.TestEvent{}
+		e.SetEpoch(1)
+		e.SetSeq(2)
+		e.SetParents(hash.Events{parent.ID()})
+		e.SetID([24]byte{byte(i + 2)})
+		children[i] = e
+	}
+	invalid := children[0]
+	errInvalid := errors.New("invalid event")
+
+	quarantine := NewQuarantine(memorydb.New(), dag.Metric{Num: 10, Size: children.Metric().Size}, testQuarantineCallback(children), func(err error) {
+		t.Fatal(err)
+	})
+	processed := make(map[hash.Event]dag.Event)
+	released := make(map[hash.Event]error)
+	replayed := make(map[hash.Event]error)
+	callback := Callback{
+		Process: func(e dag.Event) error {
+			processed[e.ID()] = e
+			return nil
+		},
+		Released: func(e dag.Event, peer string, err error) {
+			require.NotContains(t, released, e.ID())
+			released[e.ID()] = err
+		},
+		Replayed: func(e dag.Event, peer string, err error) {
+			require.NotContains(t, replayed, e.ID())
+			require.Equal(t, "peer", peer)
+			replayed[e.ID()] = err
+		},
+		Exists: func(id hash.Event) bool {
+			return processed[id] != nil
+		},
+		Get: func(id hash.Event) dag.Event {
+			return processed[id]
+		},
+		Check: func(e dag.Event, parents dag.Events) error {
+			if e.ID() == invalid.ID() {
+				return errInvalid
+			}
+			return nil
+		},
+	}
+	buffer := NewWithQuarantine(dag.Metric{Num: 0, Size: children.Metric().Size}, quarantine, callback)
+
+	for _, e := range children {
+		buffer.PushEvent(e, "peer")
+		require.ErrorIs(t, released[e.ID()], eventcheck.ErrQuarantinedEvent)
+	}
+	require.Equal(t, idx.Event(2), quarantine.Total().Num)
+
+	// the failure of the replayed event is reported with the original peer
+	buffer.PushEvent(parent, "another")
+	require.NoError(t, released[parent.ID()])
+	require.Len(t, released, 3)
+	require.Len(t, replayed, 2)
+	require.ErrorIs(t, replayed[invalid.ID()], errInvalid)
+	require.NoError(t, replayed[children[1].ID()])
+	require.NotContains(t, processed, invalid.ID())
+	require.Contains(t, processed, children[1].ID())
+	require.Equal(t, dag.Metric{}, quarantine.Total())
+}
+
+func TestEventsBufferQuarantineSweep(t *testing.T) {
+	parent := &tdag.TestEvent{}
+	parent.SetEpoch(1)
+	parent.SetSeq(1)
+	parent.SetID([24]byte{1})
+	child := &tdag.TestEvent{}
+	child.SetEpoch(1)
+	child.SetSeq(2)
+	child.SetParents(hash.Events{parent.ID()})
+	child.SetID([24]byte{2})
+
+	quarantine := NewQuarantine(memorydb.New(), dag.Metric{Num: 10, Size: uint64(child.Size()) * 10}, testQuarantineCallback(dag.Events{child}), func(err error) {
+		t.Fatal(err)
+	})
+	processed := make(map[hash.Event]dag.Event)
+	released := make(map[hash.Event][]error)
+	replayed := make(map[hash.Event][]error)
+	callback := Callback{
+		Process: func(e dag.Event) error {
+			processed[e.ID()] = e
+			return nil
+		},
+		Released: func(e dag.Event, peer string, err error) {
+			released[e.ID()] = append(released[e.ID()], err)
+		},
+		Replayed: func(e dag.Event, peer string, err error) {
+			replayed[e.ID()] = append(replayed[e.ID()], err)
+		},
+		Exists: func(id hash.Event) bool {
+			return processed[id] != nil
+		},
+		Get: func(id hash.Event) dag.Event {
+			return processed[id]
+		},
+	}
+	buffer := NewWithQuarantine(dag.Metric{Num: 0, Size: uint64(child.Size()) * 10}, quarantine, callback)
+
+	buffer.PushEvent(child, "peer")
+	require.Equal(t, []error{eventcheck.ErrQuarantinedEvent}, released[child.ID()])
+
+	// a quarantined event is a duplicate, so the fresh copy isn't buffered
+	buffer.PushEvent(child, "another")
+	require.Equal(t, []error{eventcheck.ErrQuarantinedEvent, eventcheck.ErrDuplicateEvent}, released[child.ID()])
+	require.Equal(t, idx.Event(0), buffer.Total().Num)
+	require.Equal(t, idx.Event(1), quarantine.Total().Num)
+
+	// the parent is connected not through the buffer
+	processed[parent.ID()] = parent
+	buffer.ReplayQuarantined()
+	require.Contains(t, processed, child.ID())
+	require.Equal(t, []error{nil}, replayed[child.ID()])
+	require.Len(t, released[child.ID()], 2)
+	require.Equal(t, dag.Metric{}, quarantine.Total())
+}
+
+func TestQuarantineLimit(t *testing.T) {
+	events := make(dag.Events, 10)
+	parents := hash.FakeEvents(1)
+	for i := range events {
+		e := &tdag.TestEvent{}
+		e.SetEpoch(1)
+		e.SetSeq(idx.Event(i + 2))
+		e.SetParents(parents)
+		e.SetID([24]byte{byte(i + 1)})
+		events[i] = e
+	}
+	quarantine := NewQuarantine(memorydb.New(), dag.Metric{Num: 5, Size: events.Metric().Size}, testQuarantineCallback(events), func(err error) {
+		t.Fatal(err)
+	})
+	for _, e := range events {
+		require.True(t, quarantine.put(e, "peer", parents))
+		require.True(t, quarantine.put(e, "peer", parents))
+	}
+	// the oldest events are evicted
+	require.Equal(t, idx.Event(5), quarantine.Total().Num)
+	for i, e := range events {
+		require.Equal(t, i >= 5, quarantine.Contains(e.ID()))
+	}
+	children := quarantine.popChildren(parents[0])
+	require.Len(t, children, 5)
+	for _, c := range children {
+		require.True(t, c.replayed)
+		require.False(t, c.released)
+		require.Equal(t, "peer", c.peer)
+	}
+	require.Equal(t, dag.Metric{}, quarantine.Total())
+
+	require.False(t, quarantine.put(events[0], "peer", nil))
+	require.True(t, quarantine.put(events[0], "peer", parents))
+	quarantine.Clear()
+	require.False(t, quarantine.Contains(events[0].ID()))
+	require.Equal(t, dag.Metric{}, quarantine.Total())
 }
@@ -0,0 +1,284 @@
+package dagordering
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/table"
+)
+
+// QuarantineCallback is a set of NewQuarantine()'s args.
+type QuarantineCallback struct {
+	Marshal   func(e dag.Event) ([]byte, error)
+	Unmarshal func(b []byte) (dag.Event, error)
+}
+
+// quarantinedEvent is a DB record of a quarantined event
+type quarantinedEvent struct {
+	Seq     uint64
+	Peer    string
+	Missing hash.Events
+	Size    uint64
+	Event   []byte
+}
+
+// Quarantine is a disk-backed storage of incomplete events, which are spilled from EventsBuffer.
+// Quarantined events are replayed once one of their missing parents is connected.
+type Quarantine struct {
+	callback QuarantineCallback
+	limit    dag.Metric
+
+	table struct {
+		// event hash -> quarantinedEvent
+		Events u2udb.Store `table:"e"`
+		// missing parent hash + event hash -> nil
+		Waiting u2udb.Store `table:"w"`
+		// seq + event hash -> nil, in quarantining order
+		Order u2udb.Store `table:"o"`
+	}
+
+	total dag.Metric
+	seq   uint64
+	mu    sync.Mutex
+
+	crit func(error)
+}
+
+// NewQuarantine creates a Quarantine. If limit is exceeded, then the oldest events are dropped.
+func NewQuarantine(db u2udb.Store, limit dag.Metric, callback QuarantineCallback, crit func(error)) *Quarantine {
+	q := &Quarantine{
+		callback: callback,
+		limit:    limit,
+		crit:     crit,
+	}
+	table.MigrateTables(&q.table, db)
+
+	// restore the counters of the events quarantined before a restart
+	it := q.table.Events.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		var r quarantinedEvent
+		if err := rlp.DecodeBytes(it.Value(), &r); err != nil {
+			q.crit(err)
+		}
+		q.total.Num++
+		q.total.Size += r.Size
+		if r.Seq >= q.seq {
+			q.seq = r.Seq + 1
+		}
+	}
+	if it.Error() != nil {
+		q.crit(it.Error())
+	}
+	return q
+}
+
+func orderKey(seq uint64, id hash.Event) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, seq)
+	return append(key, id.Bytes()...)
+}
+
+func waitingKey(parent, id hash.Event) []byte {
+	return append(parent.Bytes(), id.Bytes()...)
+}
+
+// put stores the event, which waits for the missing parents.
+// Returns false if the event cannot be quarantined.
+func (q *Quarantine) put(e dag.Event, peer string, missing hash.Events) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	size := uint64(e.Size())
+	if len(missing) == 0 || size > q.limit.Size || q.limit.Num == 0 {
+		return false
+	}
+	id := e.ID()
+	if ok, err := q.table.Events.Has(id.Bytes()); err != nil {
+		q.crit(err)
+	} else if ok {
+		// already quarantined
+		return true
+	}
+	raw, err := q.callback.Marshal(e)
+	if err != nil {
+		return false
+	}
+	b, err := rlp.EncodeToBytes(&quarantinedEvent{
+		Seq:     q.seq,
+		Peer:    peer,
+		Missing: missing,
+		Size:    size,
+		Event:   raw,
+	})
+	if err != nil {
+		q.crit(err)
+	}
+	if err := q.table.Events.Put(id.Bytes(), b); err != nil {
+		q.crit(err)
+	}
+	for _, p := range missing {
+		if err := q.table.Waiting.Put(waitingKey(p, id), []byte{}); err != nil {
+			q.crit(err)
+		}
+	}
+	if err := q.table.Order.Put(orderKey(q.seq, id), []byte{}); err != nil {
+		q.crit(err)
+	}
+	q.seq++
+	q.total.Num++
+	q.total.Size += size
+
+	q.evictOldest()
+	return true
+}
+
+func (q *Quarantine) evictOldest() {
+	for q.total.Num > q.limit.Num || q.total.Size > q.limit.Size {
+		it := q.table.Order.NewIterator(nil, nil)
+		ok := it.Next()
+		var id hash.Event
+		if ok {
+			id = hash.BytesToEvent(it.Key()[8:])
+		}
+		it.Release()
+		if !ok {
+			return
+		}
+		q.remove(id)
+	}
+}
+
+// get returns the quarantined record, or nil if the event isn't quarantined
+func (q *Quarantine) get(id hash.Event) *quarantinedEvent {
+	b, err := q.table.Events.Get(id.Bytes())
+	if err != nil {
+		q.crit(err)
+	}
+	if b == nil {
+		return nil
+	}
+	r := &quarantinedEvent{}
+	if err := rlp.DecodeBytes(b, r); err != nil {
+		q.crit(err)
+	}
+	return r
+}
+
+// remove erases the event and its indexes
+func (q *Quarantine) remove(id hash.Event) *quarantinedEvent {
+	r := q.get(id)
+	if r == nil {
+		return nil
+	}
+	if err := q.table.Events.Delete(id.Bytes()); err != nil {
+		q.crit(err)
+	}
+	for _, p := range r.Missing {
+		if err := q.table.Waiting.Delete(waitingKey(p, id)); err != nil {
+			q.crit(err)
+		}
+	}
+	if err := q.table.Order.Delete(orderKey(r.Seq, id)); err != nil {
+		q.crit(err)
+	}
+	q.total.Num--
+	q.total.Size -= r.Size
+	return r
+}
+
+// popChildren removes and returns the events which wait for the parent.
+// Events which cannot be unmarshaled are dropped.
+func (q *Quarantine) popChildren(parent hash.Event) []*event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids hash.Events
+	it := q.table.Waiting.NewIterator(parent.Bytes(), nil)
+	for it.Next() {
+		ids = append(ids, hash.BytesToEvent(it.Key()[len(parent):]))
+	}
+	if it.Error() != nil {
+		q.crit(it.Error())
+	}
+	it.Release()
+
+	children := make([]*event, 0, len(ids))
+	for _, id := range ids {
+		r := q.remove(id)
+		if r == nil {
+			continue
+		}
+		e, err := q.callback.Unmarshal(r.Event)
+		if err != nil {
+			continue
+		}
+		children = append(children, &event{
+			event:    e,
+			peer:     r.Peer,
+			replayed: true,
+		})
+	}
+	return children
+}
+
+// waitedParents returns the missing parents, which the quarantined events wait for
+func (q *Quarantine) waitedParents() hash.Events {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var parents hash.Events
+	it := q.table.Waiting.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		p := hash.BytesToEvent(it.Key()[:len(hash.Event{})])
+		if len(parents) == 0 || parents[len(parents)-1] != p {
+			parents = append(parents, p)
+		}
+	}
+	if it.Error() != nil {
+		q.crit(it.Error())
+	}
+	return parents
+}
+
+// Contains returns true if the event is quarantined
+func (q *Quarantine) Contains(id hash.Event) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ok, err := q.table.Events.Has(id.Bytes())
+	if err != nil {
+		q.crit(err)
+	}
+	return ok
+}
+
+// Total returns the total size and number of quarantined events
+func (q *Quarantine) Total() dag.Metric {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.total
+}
+
+// Clear drops all the quarantined events
+func (q *Quarantine) Clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids hash.Events
+	it := q.table.Events.NewIterator(nil, nil)
+	for it.Next() {
+		ids = append(ids, hash.BytesToEvent(it.Key()))
+	}
+	it.Release()
+	for _, id := range ids {
+		q.remove(id)
+	}
+	q.total = dag.Metric{}
+}
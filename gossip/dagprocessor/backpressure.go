@@ -0,0 +1,174 @@
+package dagprocessor
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadLevel is a coarse level of the processor's load
+type LoadLevel uint8
+
+const (
+	// LoadNormal means that events may be requested at the full speed
+	LoadNormal LoadLevel = iota
+	// LoadHigh means that peers should slow down, e.g. announces should be throttled
+	LoadHigh
+	// LoadOverloaded means that no new events should be requested, e.g. stream sessions should be suspended
+	LoadOverloaded
+)
+
+func (l LoadLevel) String() string {
+	switch l {
+	case LoadNormal:
+		return "normal"
+	case LoadHigh:
+		return "high"
+	case LoadOverloaded:
+		return "overloaded"
+	}
+	return "unknown"
+}
+
+// Load is a snapshot of the processor's load
+type Load struct {
+	Level LoadLevel
+	// Ratio is the highest fill ratio of the task queues and the events semaphore, in [0, 1]
+	Ratio float64
+	// Queued is a number of enqueued events, which aren't inserted yet
+	Queued int
+	// Delay is an estimated time before a newly enqueued event gets inserted
+	Delay time.Duration
+}
+
+// loadState tracks the insertion speed and the last reported load level
+type loadState struct {
+	queued int64 // atomic
+	// perEvent is an exponential moving average of an event insertion time, in nanoseconds
+	perEvent int64 // atomic
+
+	mu    sync.Mutex
+	level LoadLevel
+}
+
+// perEventAlpha is a weight of a new measurement in the moving average
+const perEventAlpha = 0.1
+
+func (s *loadState) enqueued(n int) {
+	atomic.AddInt64(&s.queued, int64(n))
+}
+
+func (s *loadState) dequeued(n int) {
+	atomic.AddInt64(&s.queued, -int64(n))
+}
+
+// inserted updates the moving average with a time spent on insertion of n events
+func (s *loadState) inserted(n int, elapsed time.Duration) {
+	if n <= 0 {
+		return
+	}
+	sample := float64(elapsed) / float64(n)
+	for {
+		prev := atomic.LoadInt64(&s.perEvent)
+		next := int64(sample)
+		if prev != 0 {
+			next = int64(float64(prev)*(1-perEventAlpha) + sample*perEventAlpha)
+		}
+		if atomic.CompareAndSwapInt64(&s.perEvent, prev, next) {
+			return
+		}
+	}
+}
+
+func (f *Processor) highLoadRatio() float64 {
+	if f.cfg.HighLoadRatio <= 0 {
+		return 0.5
+	}
+	return f.cfg.HighLoadRatio
+}
+
+func (f *Processor) overloadRatio() float64 {
+	if f.cfg.OverloadRatio <= 0 {
+		return 0.75
+	}
+	return f.cfg.OverloadRatio
+}
+
+// Load returns the current load of the processor
+func (f *Processor) Load() Load {
+	ratio := 0.0
+	fill := func(used, max uint64) {
+		if max == 0 {
+			return
+		}
+		if r := float64(used) / float64(max); r > ratio {
+			ratio = r
+		}
+	}
+	// every priority has its own queue of MaxTasks
+	tasksCap := uint64(f.cfg.MaxTasks) * uint64(numPriorities)
+	fill(uint64(f.checker.TasksCount()), tasksCap)
+	fill(uint64(f.orderedInserter.TasksCount()), tasksCap)
+	// the limit of a terminated semaphore is zero, so it's skipped
+	processing, available := f.eventsSemaphore.Processing(), f.eventsSemaphore.Available()
+	fill(uint64(processing.Num), uint64(processing.Num+available.Num))
+	fill(processing.Size, processing.Size+available.Size)
+	if ratio > 1 {
+		ratio = 1
+	}
+
+	queued := atomic.LoadInt64(&f.load.queued)
+	if queued < 0 {
+		queued = 0
+	}
+	load := Load{
+		Ratio:  ratio,
+		Queued: int(queued),
+		Delay:  time.Duration(queued * atomic.LoadInt64(&f.load.perEvent)),
+	}
+	if ratio > f.overloadRatio() {
+		load.Level = LoadOverloaded
+	} else if ratio > f.highLoadRatio() {
+		load.Level = LoadHigh
+	}
+	return load
+}
+
+// SuspendAt returns a function which returns true while the load is at the level or higher.
+// It may be used as Suspend callback of itemsfetcher.Callback or basepeerleecher.EpochDownloaderCallbacks.
+func (f *Processor) SuspendAt(level LoadLevel) func() bool {
+	return func() bool {
+		return f.Load().Level >= level
+	}
+}
+
+// checkLoad calls Callback.LoadChanged if the load level has changed since the last call
+func (f *Processor) checkLoad() {
+	if f.callback.LoadChanged == nil {
+		return
+	}
+	f.load.mu.Lock()
+	defer f.load.mu.Unlock()
+	load := f.Load()
+	if load.Level == f.load.level {
+		return
+	}
+	prev := f.load.level
+	f.load.level = load.Level
+	f.callback.LoadChanged(prev, load)
+}
+
+// loadLoop notices the load decrease, which happens without enqueuing of new events
+func (f *Processor) loadLoop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.cfg.LoadCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.checkLoad()
+		case <-f.quit:
+			return
+		}
+	}
+}
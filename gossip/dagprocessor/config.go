@@ -5,25 +5,63 @@ import (
 
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
 	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
 )
 
 type Config struct {
 	EventsBufferLimit dag.Metric
 
+	// MaxLamportDiff is a Lamport distance above the highest Lamport, after which
+	// events are considered too far in future and released with eventcheck.ErrSpilledEvent.
+	// Zero means EventsBufferLimit.Num.
+	MaxLamportDiff idx.Lamport
+
 	EventsSemaphoreTimeout time.Duration
 
 	MaxTasks int
+
+	// CheckerThreads is a number of goroutines which run CheckParentless concurrently for events of a batch.
+	// Batches are checked one by one, and events are still inserted in the order of enqueuing. Zero means 1.
+	CheckerThreads int
+
+	// LowPriorityLamportDiff is a Lamport distance below the highest Lamport, after which
+	// events from peers are processed with LowPriority. Zero disables it, which is the default.
+	LowPriorityLamportDiff idx.Lamport
+
+	// MissingParentsTimeout is a time after which not arrived missing parents are requested again.
+	// Zero disables re-requesting.
+	MissingParentsTimeout time.Duration
+
+	// HighLoadRatio and OverloadRatio are fill ratios of the task queues or the events semaphore,
+	// above which the load is LoadHigh and LoadOverloaded. Zero means 1/2 and 3/4 respectively.
+	HighLoadRatio float64
+	OverloadRatio float64
+
+	// LoadCheckInterval is a period of the load level checks for Callback.LoadChanged.
+	// The level is also checked on every enqueuing. Zero disables the periodic checks.
+	LoadCheckInterval time.Duration
+
+	// QuarantineSweepInterval is a period of the checks of the quarantined events, whose missing parents
+	// are connected not through the events buffer. They are also checked on Start. Zero disables the periodic checks.
+	QuarantineSweepInterval time.Duration
 }
 
 func DefaultConfig(scale cachescale.Func) Config {
 	return Config{
 		EventsBufferLimit: dag.Metric{
-			// Shouldn't be too big because complexity is O(n) for each insertion in the EventsBuffer
-			Num:  3000,
+			// Complexity of an insertion into the EventsBuffer doesn't depend on its size
+			Num:  30000,
 			Size: scale.U64(10 * opt.MiB),
 		},
-		EventsSemaphoreTimeout: 10 * time.Second,
-		MaxTasks:               128,
+		MaxLamportDiff:          3000,
+		EventsSemaphoreTimeout:  10 * time.Second,
+		MaxTasks:                128,
+		CheckerThreads:          1,
+		MissingParentsTimeout:   10 * time.Second,
+		HighLoadRatio:           0.5,
+		OverloadRatio:           0.75,
+		LoadCheckInterval:       100 * time.Millisecond,
+		QuarantineSweepInterval: 10 * time.Second,
 	}
 }
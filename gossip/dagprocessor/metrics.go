@@ -0,0 +1,33 @@
+package dagprocessor
+
+import (
+	"time"
+)
+
+// Metrics receives measurements of the events processing pipeline.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// EventsEnqueued is called when events are accepted by Enqueue
+	EventsEnqueued(num int)
+	// EventProcessed is called when the Process callback is called for an event,
+	// with the time elapsed since the event was enqueued
+	EventProcessed(sinceEnqueued time.Duration)
+	// EventReleased is called when an event is released. err is nil if the event is connected
+	EventReleased(err error)
+	// QueuesDepth is called with number of pending tasks of the checker and the ordered inserter
+	QueuesDepth(checker, inserter int)
+	// SemaphoreWaited is called with the time spent on acquiring the events semaphore
+	SemaphoreWaited(wait time.Duration, acquired bool)
+	// EventSpilled is called when an event is released because it's too far in future or the events buffer is full
+	EventSpilled()
+}
+
+// NoopMetrics is a Metrics which ignores all the measurements
+type NoopMetrics struct{}
+
+func (NoopMetrics) EventsEnqueued(int)                  {}
+func (NoopMetrics) EventProcessed(time.Duration)        {}
+func (NoopMetrics) EventReleased(error)                 {}
+func (NoopMetrics) QueuesDepth(int, int)                {}
+func (NoopMetrics) SemaphoreWaited(time.Duration, bool) {}
+func (NoopMetrics) EventSpilled()                       {}
@@ -0,0 +1,143 @@
+package dagprocessor
+
+import (
+	"expvar"
+	"time"
+)
+
+// expvarHistogramBounds are upper bounds of the histogram buckets
+var expvarHistogramBounds = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// ExpvarHistogram is a histogram of durations, based on expvar.Map.
+// Buckets are cumulative, like in Prometheus: "le_<bound>" counts all the observations which are <= bound.
+type ExpvarHistogram struct {
+	m       *expvar.Map
+	count   *expvar.Int
+	sum     *expvar.Float
+	buckets []*expvar.Int
+}
+
+func newExpvarHistogram() *ExpvarHistogram {
+	h := &ExpvarHistogram{
+		m:       new(expvar.Map).Init(),
+		count:   new(expvar.Int),
+		sum:     new(expvar.Float),
+		buckets: make([]*expvar.Int, len(expvarHistogramBounds)),
+	}
+	h.m.Set("count", h.count)
+	h.m.Set("sum_seconds", h.sum)
+	for i, bound := range expvarHistogramBounds {
+		h.buckets[i] = new(expvar.Int)
+		h.m.Set("le_"+bound.String(), h.buckets[i])
+	}
+	return h
+}
+
+// Observe adds a measurement into the histogram
+func (h *ExpvarHistogram) Observe(d time.Duration) {
+	h.count.Add(1)
+	h.sum.Add(d.Seconds())
+	for i, bound := range expvarHistogramBounds {
+		if d <= bound {
+			h.buckets[i].Add(1)
+		}
+	}
+}
+
+// Count returns number of the measurements
+func (h *ExpvarHistogram) Count() int64 {
+	return h.count.Value()
+}
+
+// ExpvarMetrics is a Metrics which stores the measurements into expvar variables.
+// Use Var to publish them, e.g. expvar.Publish("dagprocessor", m.Var()).
+type ExpvarMetrics struct {
+	m *expvar.Map
+
+	Enqueued      *expvar.Int
+	Released      *expvar.Map // error text -> counter, "ok" for connected events
+	Spilled       *expvar.Int
+	CheckerQueue  *expvar.Int
+	InserterQueue *expvar.Int
+	// SemaphoreTimeouts is a number of failed attempts to acquire the events semaphore
+	SemaphoreTimeouts *expvar.Int
+	SemaphoreWait     *ExpvarHistogram
+	// ProcessLatency is a time from enqueuing an event to processing it
+	ProcessLatency *ExpvarHistogram
+
+	// ErrorLabel returns a key of Released counter for an error. Optional, err.Error() is used by default.
+	// Override it if errors contain variable data, to keep number of the counters bounded.
+	ErrorLabel func(err error) string
+}
+
+var _ Metrics = (*ExpvarMetrics)(nil)
+
+// NewExpvarMetrics creates ExpvarMetrics instance. The variables aren't published.
+func NewExpvarMetrics() *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		m:                 new(expvar.Map).Init(),
+		Enqueued:          new(expvar.Int),
+		Released:          new(expvar.Map).Init(),
+		Spilled:           new(expvar.Int),
+		CheckerQueue:      new(expvar.Int),
+		InserterQueue:     new(expvar.Int),
+		SemaphoreTimeouts: new(expvar.Int),
+		SemaphoreWait:     newExpvarHistogram(),
+		ProcessLatency:    newExpvarHistogram(),
+	}
+	m.m.Set("enqueued", m.Enqueued)
+	m.m.Set("released", m.Released)
+	m.m.Set("spilled", m.Spilled)
+	m.m.Set("checker_queue", m.CheckerQueue)
+	m.m.Set("inserter_queue", m.InserterQueue)
+	m.m.Set("semaphore_timeouts", m.SemaphoreTimeouts)
+	m.m.Set("semaphore_wait", m.SemaphoreWait.m)
+	m.m.Set("process_latency", m.ProcessLatency.m)
+	return m
+}
+
+// Var returns all the variables as a single expvar.Var
+func (m *ExpvarMetrics) Var() expvar.Var {
+	return m.m
+}
+
+func (m *ExpvarMetrics) EventsEnqueued(num int) {
+	m.Enqueued.Add(int64(num))
+}
+
+func (m *ExpvarMetrics) EventProcessed(sinceEnqueued time.Duration) {
+	m.ProcessLatency.Observe(sinceEnqueued)
+}
+
+func (m *ExpvarMetrics) EventReleased(err error) {
+	switch {
+	case err == nil:
+		m.Released.Add("ok", 1)
+	case m.ErrorLabel != nil:
+		m.Released.Add(m.ErrorLabel(err), 1)
+	default:
+		m.Released.Add(err.Error(), 1)
+	}
+}
+
+func (m *ExpvarMetrics) QueuesDepth(checker, inserter int) {
+	m.CheckerQueue.Set(int64(checker))
+	m.InserterQueue.Set(int64(inserter))
+}
+
+func (m *ExpvarMetrics) SemaphoreWaited(wait time.Duration, acquired bool) {
+	m.SemaphoreWait.Observe(wait)
+	if !acquired {
+		m.SemaphoreTimeouts.Add(1)
+	}
+}
+
+func (m *ExpvarMetrics) EventSpilled() {
+	m.Spilled.Add(1)
+}
@@ -0,0 +1,15 @@
+package dagprocessor
+
+// Priority of an events batch. Batches with a higher priority are processed first.
+type Priority int
+
+const (
+	// LowPriority is for events which are far behind the highest Lamport, e.g. from far-behind peers
+	LowPriority Priority = iota
+	// NormalPriority is for regular gossip
+	NormalPriority
+	// HighPriority is for self-events and events from own stream sync
+	HighPriority
+
+	numPriorities
+)
@@ -1,8 +1,11 @@
 package dagprocessor
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/unicornultrafoundation/go-hashgraph/eventcheck"
 	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagordering"
@@ -26,16 +29,32 @@ type Processor struct {
 
 	callback Callback
 
-	checker         *workers.Workers
-	orderedInserter *workers.Workers
+	checker         *workers.PriorityWorkers
+	orderedInserter *workers.PriorityWorkers
 
 	buffer *dagordering.EventsBuffer
 
 	eventsSemaphore *datasemaphore.DataSemaphore
+
+	terminateOnce sync.Once
+	stopOnce      sync.Once
+
+	enqueuedMu sync.Mutex
+	enqueued   map[hash.Event]*enqueuedEvent
+
+	load loadState
+}
+
+// enqueuedEvent is an enqueuing time of a not released event, for metrics
+type enqueuedEvent struct {
+	at   time.Time
+	refs int // number of not released copies of the event
 }
 
 type EventCallback struct {
-	Process         func(e dag.Event) error
+	Process func(e dag.Event) error
+	// Released is called once for every enqueued event.
+	// An event released with eventcheck.ErrQuarantinedEvent is released once more after it's replayed from the quarantine.
 	Released        func(e dag.Event, peer string, err error)
 	Get             func(hash.Event) dag.Event
 	Exists          func(hash.Event) bool
@@ -46,6 +65,18 @@ type EventCallback struct {
 type Callback struct {
 	Event          EventCallback
 	HighestLamport func() idx.Lamport
+	// RequestMissingParents is called for the missing parents, which didn't arrive within Config.MissingParentsTimeout.
+	// Optional. It may be wired to itemsfetcher.Fetcher.NotifyAnnounces, which dedupes the requests.
+	RequestMissingParents func(peer string, ids hash.Events)
+	// Metrics is optional
+	Metrics Metrics
+	// Quarantine keeps the events spilled from the events buffer, so they aren't downloaded again. Optional.
+	// It's cleared by Stop and Clear, so only the events quarantined before a crash are replayed after a restart.
+	Quarantine *dagordering.Quarantine
+	// LoadChanged is called when the load level crosses Config.HighLoadRatio or Config.OverloadRatio.
+	// It may be used to throttle announces, suspend stream sessions or ask peers to slow down. Optional.
+	// It's called synchronously, so it mustn't block or enqueue events.
+	LoadChanged func(prev LoadLevel, cur Load)
 }
 
 // New creates an event processor
@@ -55,23 +86,45 @@ func New(eventsSemaphore *datasemaphore.DataSemaphore, cfg Config, callback Call
 		quit:            make(chan struct{}),
 		eventsSemaphore: eventsSemaphore,
 	}
+	if callback.Metrics == nil {
+		callback.Metrics = NoopMetrics{}
+	} else {
+		f.enqueued = make(map[hash.Event]*enqueuedEvent)
+	}
 	released := callback.Event.Released
-	callback.Event.Released = func(e dag.Event, peer string, err error) {
-		f.eventsSemaphore.Release(dag.Metric{Num: 1, Size: uint64(e.Size())})
+	// replayed events are released already, so only their result is reported
+	replayed := func(e dag.Event, peer string, err error) {
+		f.callback.Metrics.EventReleased(err)
 		if released != nil {
 			released(e, peer, err)
 		}
 	}
+	callback.Event.Released = func(e dag.Event, peer string, err error) {
+		f.eventsSemaphore.Release(dag.Metric{Num: 1, Size: uint64(e.Size())})
+		f.forgetEnqueued(e.ID())
+		if err == eventcheck.ErrSpilledEvent {
+			f.callback.Metrics.EventSpilled()
+		}
+		replayed(e, peer, err)
+	}
+	processEvent := callback.Event.Process
+	callback.Event.Process = func(e dag.Event) error {
+		if enqueuedAt, ok := f.getEnqueued(e.ID()); ok {
+			f.callback.Metrics.EventProcessed(time.Since(enqueuedAt))
+		}
+		return processEvent(e)
+	}
 	f.callback = callback
-	f.buffer = dagordering.New(cfg.EventsBufferLimit, dagordering.Callback{
+	f.buffer = dagordering.NewWithQuarantine(cfg.EventsBufferLimit, callback.Quarantine, dagordering.Callback{
 		Process:  callback.Event.Process,
 		Released: callback.Event.Released,
+		Replayed: replayed,
 		Get:      callback.Event.Get,
 		Exists:   callback.Event.Exists,
 		Check:    callback.Event.CheckParents,
 	})
-	f.orderedInserter = workers.New(&f.wg, f.quit, cfg.MaxTasks)
-	f.checker = workers.New(&f.wg, f.quit, cfg.MaxTasks)
+	f.orderedInserter = workers.NewPriority(&f.wg, f.quit, cfg.MaxTasks, int(numPriorities))
+	f.checker = workers.NewPriority(&f.wg, f.quit, cfg.MaxTasks, int(numPriorities))
 	return f
 }
 
@@ -79,18 +132,106 @@ func New(eventsSemaphore *datasemaphore.DataSemaphore, cfg Config, callback Call
 func (f *Processor) Start() {
 	f.orderedInserter.Start(1)
 	f.checker.Start(1)
+	if f.callback.RequestMissingParents != nil && f.cfg.MissingParentsTimeout > 0 {
+		f.wg.Add(1)
+		go f.rerequestLoop()
+	}
+	if f.callback.LoadChanged != nil && f.cfg.LoadCheckInterval > 0 {
+		f.wg.Add(1)
+		go f.loadLoop()
+	}
+	if f.callback.Quarantine != nil {
+		// parents of the events quarantined before a restart may be connected already
+		f.buffer.ReplayQuarantined()
+		if f.cfg.QuarantineSweepInterval > 0 {
+			f.wg.Add(1)
+			go f.sweepLoop()
+		}
+	}
+}
+
+func (f *Processor) checkerThreads() int {
+	if f.cfg.CheckerThreads < 1 {
+		return 1
+	}
+	return f.cfg.CheckerThreads
+}
+
+// rerequestLoop requests again the missing parents, which didn't arrive in time
+func (f *Processor) rerequestLoop() {
+	defer f.wg.Done()
+	period := f.cfg.MissingParentsTimeout / 2
+	if period <= 0 {
+		period = f.cfg.MissingParentsTimeout
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for peer, ids := range f.buffer.ExpiredRequests(f.cfg.MissingParentsTimeout) {
+				if peer == "" {
+					// self-events, there's no one to request from
+					continue
+				}
+				f.callback.RequestMissingParents(peer, ids)
+			}
+		case <-f.quit:
+			return
+		}
+	}
+}
+
+// sweepLoop replays the quarantined events, whose parents are connected not through the events buffer
+func (f *Processor) sweepLoop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.cfg.QuarantineSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.buffer.ReplayQuarantined()
+		case <-f.quit:
+			return
+		}
+	}
 }
 
 // Stop interrupts the processor, canceling all the pending operations.
 // Stop waits until all the internal goroutines have finished.
 func (f *Processor) Stop() {
-	close(f.quit)
-	f.eventsSemaphore.Terminate()
-	f.wg.Wait()
-	f.buffer.Clear()
+	f.stopOnce.Do(func() {
+		f.terminate()
+		f.wg.Wait()
+		f.Clear()
+	})
+}
+
+// terminate interrupts the events processor, without waiting for its goroutines
+func (f *Processor) terminate() {
+	f.terminateOnce.Do(func() {
+		close(f.quit)
+		f.eventsSemaphore.Terminate()
+	})
+}
+
+// StartContext boots up the events processor, which gets terminated once ctx is done.
+// Stop is still required to wait for the goroutines and to clear the buffered events.
+func (f *Processor) StartContext(ctx context.Context) {
+	f.Start()
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		select {
+		case <-ctx.Done():
+			f.terminate()
+		case <-f.quit:
+		}
+	}()
 }
 
-// Overloaded returns true if too much events are being processed or requested
+// Overloaded returns true if too much events are being processed or requested.
+// Only the task queues are considered, see Load for a detailed state.
 func (f *Processor) Overloaded() bool {
 	return f.checker.TasksCount() > f.cfg.MaxTasks*3/4 ||
 		f.orderedInserter.TasksCount() > f.cfg.MaxTasks*3/4
@@ -102,38 +243,95 @@ type checkRes struct {
 	pos idx.Event
 }
 
+// Enqueue schedules events for processing with a priority, which is chosen by Priority
 func (f *Processor) Enqueue(peer string, events dag.Events, ordered bool, notifyAnnounces func(hash.Events), done func()) error {
-	if !f.eventsSemaphore.Acquire(events.Metric(), f.cfg.EventsSemaphoreTimeout) {
+	return f.EnqueueWithPriority(peer, events, ordered, f.Priority(peer, events), notifyAnnounces, done)
+}
+
+// Priority returns a priority of events, which is used by Enqueue:
+// HighPriority for self-events (with an empty peer), LowPriority for events
+// which are more than Config.LowPriorityLamportDiff behind the highest Lamport, NormalPriority otherwise.
+func (f *Processor) Priority(peer string, events dag.Events) Priority {
+	if peer == "" {
+		return HighPriority
+	}
+	if f.cfg.LowPriorityLamportDiff == 0 || len(events) == 0 {
+		return NormalPriority
+	}
+	maxLamport := idx.Lamport(0)
+	for _, e := range events {
+		if maxLamport < e.Lamport() {
+			maxLamport = e.Lamport()
+		}
+	}
+	if maxLamport+f.cfg.LowPriorityLamportDiff < f.callback.HighestLamport() {
+		return LowPriority
+	}
+	return NormalPriority
+}
+
+// EnqueueWithPriority schedules events for processing.
+// Events with a higher priority are checked and inserted before pending events with a lower priority.
+func (f *Processor) EnqueueWithPriority(peer string, events dag.Events, ordered bool, priority Priority, notifyAnnounces func(hash.Events), done func()) error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.cfg.EventsSemaphoreTimeout)
+	defer cancel()
+	return f.enqueue(ctx, context.Background(), peer, events, ordered, priority, notifyAnnounces, done)
+}
+
+// EnqueueContext is like EnqueueWithPriority, but the enqueuing is canceled once ctx is done.
+// If ctx is done before the events are started to be inserted, then the events are released with ctx.Err().
+// Config.EventsSemaphoreTimeout isn't applied, ctx is used instead.
+func (f *Processor) EnqueueContext(ctx context.Context, peer string, events dag.Events, ordered bool, priority Priority, notifyAnnounces func(hash.Events), done func()) error {
+	return f.enqueue(ctx, ctx, peer, events, ordered, priority, notifyAnnounces, done)
+}
+
+// enqueue schedules events for processing. acquireCtx limits the events semaphore waiting,
+// ctx limits the whole enqueuing.
+func (f *Processor) enqueue(acquireCtx, ctx context.Context, peer string, events dag.Events, ordered bool, priority Priority, notifyAnnounces func(hash.Events), done func()) error {
+	start := time.Now()
+	acquired := f.eventsSemaphore.AcquireContext(acquireCtx, events.Metric())
+	f.callback.Metrics.SemaphoreWaited(time.Since(start), acquired)
+	if !acquired {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return ErrBusy
 	}
+	enqueuedAt := time.Now()
+	f.callback.Metrics.EventsEnqueued(len(events))
+	f.load.enqueued(len(events))
 
 	checkedC := make(chan *checkRes, len(events))
-	err := f.checker.Enqueue(func() {
-		for i, e := range events {
-			pos := idx.Event(i)
-			event := e
-			f.callback.Event.CheckParentless(event, func(err error) {
-				checkedC <- &checkRes{
-					e:   event,
-					err: err,
-					pos: pos,
-				}
-			})
+	// canceled is set if the events are released without insertion, so they don't need to be checked
+	var canceled uint32
+	// every batch takes a single checker slot, regardless of the number of checker threads
+	err := f.checker.EnqueueContext(ctx, int(priority), func() {
+		if atomic.LoadUint32(&canceled) != 0 {
+			return
 		}
+		f.checkParentless(events, checkedC)
 	})
 	if err != nil {
+		f.load.dequeued(len(events))
+		f.releaseCanceled(ctx, peer, events)
 		return err
 	}
 	eventsLen := len(events)
-	return f.orderedInserter.Enqueue(func() {
+	err = f.orderedInserter.EnqueueContext(ctx, int(priority), func() {
 		if done != nil {
 			defer done()
 		}
+		defer f.load.dequeued(eventsLen)
+		if ctx.Err() != nil {
+			f.releaseCanceled(ctx, peer, events)
+			return
+		}
 
 		var orderedResults []*checkRes
 		if ordered {
 			orderedResults = make([]*checkRes, eventsLen)
 		}
+		insertionStart := time.Now()
 		var processed int
 		var toRequest hash.Events
 		for processed < eventsLen {
@@ -143,12 +341,12 @@ func (f *Processor) Enqueue(peer string, events dag.Events, ordered bool, notify
 					orderedResults[res.pos] = res
 
 					for i := processed; processed < len(orderedResults) && orderedResults[i] != nil; i++ {
-						toRequest = append(toRequest, f.process(peer, orderedResults[i].e, orderedResults[i].err)...)
+						toRequest = append(toRequest, f.process(peer, orderedResults[i].e, orderedResults[i].err, enqueuedAt)...)
 						orderedResults[i] = nil // free the memory
 						processed++
 					}
 				} else {
-					toRequest = append(toRequest, f.process(peer, res.e, res.err)...)
+					toRequest = append(toRequest, f.process(peer, res.e, res.err, enqueuedAt)...)
 					processed++
 				}
 
@@ -156,15 +354,72 @@ func (f *Processor) Enqueue(peer string, events dag.Events, ordered bool, notify
 				return
 			}
 		}
+		f.load.inserted(eventsLen, time.Since(insertionStart))
 
 		// request unknown event parents
 		if notifyAnnounces != nil && len(toRequest) != 0 {
 			notifyAnnounces(toRequest)
 		}
 	})
+	if err != nil {
+		atomic.StoreUint32(&canceled, 1)
+		f.load.dequeued(eventsLen)
+		f.releaseCanceled(ctx, peer, events)
+	}
+	f.callback.Metrics.QueuesDepth(f.checker.TasksCount(), f.orderedInserter.TasksCount())
+	f.checkLoad()
+	return err
+}
+
+// checkParentless splits the events into chunks, which are checked concurrently by Config.CheckerThreads goroutines
+func (f *Processor) checkParentless(events dag.Events, checkedC chan<- *checkRes) {
+	check := func(chunk dag.Events, offset int) {
+		for i, e := range chunk {
+			pos := idx.Event(offset + i)
+			event := e
+			f.callback.Event.CheckParentless(event, func(err error) {
+				checkedC <- &checkRes{
+					e:   event,
+					err: err,
+					pos: pos,
+				}
+			})
+		}
+	}
+	threads := f.checkerThreads()
+	if threads == 1 || len(events) <= 1 {
+		check(events, 0)
+		return
+	}
+	chunkSize := (len(events) + threads - 1) / threads
+	wg := sync.WaitGroup{}
+	for start := 0; start < len(events); start += chunkSize {
+		end := start + chunkSize
+		if end > len(events) {
+			end = len(events)
+		}
+		wg.Add(1)
+		go func(chunk dag.Events, offset int) {
+			defer wg.Done()
+			check(chunk, offset)
+		}(events[start:end], start)
+	}
+	wg.Wait()
 }
 
-func (f *Processor) process(peer string, event dag.Event, resErr error) (toRequest hash.Events) {
+// releaseCanceled releases the events, if they are not going to be processed because ctx is done
+func (f *Processor) releaseCanceled(ctx context.Context, peer string, events dag.Events) {
+	if ctx.Err() == nil {
+		return
+	}
+	for _, e := range events {
+		f.callback.Event.Released(e, peer, ctx.Err())
+	}
+}
+
+func (f *Processor) process(peer string, event dag.Event, resErr error, enqueuedAt time.Time) (toRequest hash.Events) {
+	// every processed event gets released exactly once
+	f.setEnqueued(event.ID(), enqueuedAt)
 	// release event if failed validation
 	if resErr != nil {
 		f.callback.Event.Released(event, peer, resErr)
@@ -172,7 +427,7 @@ func (f *Processor) process(peer string, event dag.Event, resErr error) (toReque
 	}
 	// release event if it's too far in future
 	highestLamport := f.callback.HighestLamport()
-	maxLamportDiff := 1 + idx.Lamport(f.cfg.EventsBufferLimit.Num)
+	maxLamportDiff := 1 + f.maxLamportDiff()
 	if event.Lamport() > highestLamport+maxLamportDiff {
 		f.callback.Event.Released(event, peer, eventcheck.ErrSpilledEvent)
 		return hash.Events{}
@@ -180,17 +435,83 @@ func (f *Processor) process(peer string, event dag.Event, resErr error) (toReque
 	// push event to the ordering buffer
 	complete := f.buffer.PushEvent(event, peer)
 	if !complete && event.Lamport() <= highestLamport+maxLamportDiff/10 {
-		return event.Parents()
+		return f.buffer.RequestMissingParents(event.ID())
 	}
 	return hash.Events{}
 }
 
+// maxLamportDiff returns Config.MaxLamportDiff, or the events buffer limit if it's zero
+func (f *Processor) maxLamportDiff() idx.Lamport {
+	if f.cfg.MaxLamportDiff == 0 {
+		return idx.Lamport(f.cfg.EventsBufferLimit.Num)
+	}
+	return f.cfg.MaxLamportDiff
+}
+
+func (f *Processor) setEnqueued(id hash.Event, enqueuedAt time.Time) {
+	if f.enqueued == nil {
+		return
+	}
+	f.enqueuedMu.Lock()
+	defer f.enqueuedMu.Unlock()
+	if e, ok := f.enqueued[id]; ok {
+		// duplicate, keep the earliest time
+		e.refs++
+		return
+	}
+	f.enqueued[id] = &enqueuedEvent{
+		at:   enqueuedAt,
+		refs: 1,
+	}
+}
+
+func (f *Processor) getEnqueued(id hash.Event) (time.Time, bool) {
+	if f.enqueued == nil {
+		return time.Time{}, false
+	}
+	f.enqueuedMu.Lock()
+	defer f.enqueuedMu.Unlock()
+	e, ok := f.enqueued[id]
+	if !ok {
+		return time.Time{}, false
+	}
+	return e.at, true
+}
+
+func (f *Processor) forgetEnqueued(id hash.Event) {
+	if f.enqueued == nil {
+		return
+	}
+	f.enqueuedMu.Lock()
+	defer f.enqueuedMu.Unlock()
+	if e, ok := f.enqueued[id]; ok {
+		e.refs--
+		if e.refs <= 0 {
+			delete(f.enqueued, id)
+		}
+	}
+}
+
+// MissingParents returns the parents which are missing for the buffered events
+func (f *Processor) MissingParents() hash.Events {
+	return f.buffer.MissingParents()
+}
+
+// ExpiredRequests returns the requested missing parents, which didn't arrive within the timeout, grouped by peers.
+// It's an alternative to Callback.RequestMissingParents for callers which drive the re-requests themselves.
+func (f *Processor) ExpiredRequests(timeout time.Duration) map[string]hash.Events {
+	return f.buffer.ExpiredRequests(timeout)
+}
+
 func (f *Processor) IsBuffered(id hash.Event) bool {
 	return f.buffer.IsBuffered(id)
 }
 
 func (f *Processor) Clear() {
 	f.buffer.Clear()
+	if f.callback.Quarantine != nil {
+		f.callback.Quarantine.Clear()
+	}
 }
 
 func (f *Processor) TotalBuffered() dag.Metric {
@@ -1,17 +1,25 @@
 package dagprocessor
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"expvar"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/parentscheck"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagordering"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/peerscore"
 	"github.com/unicornultrafoundation/go-hashgraph/hash"
 	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
 	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
 	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
 	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
 	"github.com/unicornultrafoundation/go-hashgraph/utils/datasemaphore"
 )
@@ -202,6 +210,7 @@ func testProcessorReleasing(t *testing.T, maxEvents int, try int64) {
 	config.EventsBufferLimit = limit
 
 	released := uint32(0)
+	metrics := NewExpvarMetrics()
 
 	highestLamport := idx.Lamport(0)
 	processed := make(map[hash.Event]dag.Event)
@@ -275,6 +284,7 @@ func testProcessorReleasing(t *testing.T, maxEvents int, try int64) {
 		HighestLamport: func() idx.Lamport {
 			return highestLamport
 		},
+		Metrics: metrics,
 	})
 	// duplicate some events
 	ordered = append(ordered, ordered[:rand.Intn(len(ordered))]...) // nolint:gosec
@@ -307,4 +317,844 @@ func testProcessorReleasing(t *testing.T, maxEvents int, try int64) {
 	if uint32(len(ordered)) != released {
 		t.Fatal("not all the events were released", len(ordered), released)
 	}
+
+	// metrics are consistent
+	if metrics.Enqueued.Value() != int64(len(ordered)) {
+		t.Fatal("wrong number of enqueued events", len(ordered), metrics.Enqueued.Value())
+	}
+	releasedMetric := int64(0)
+	metrics.Released.Do(func(kv expvar.KeyValue) {
+		releasedMetric += kv.Value.(*expvar.Int).Value()
+	})
+	if releasedMetric != int64(released) {
+		t.Fatal("wrong number of released events", released, releasedMetric)
+	}
+	if spilled := metrics.Released.Get(eventcheck.ErrSpilledEvent.Error()); spilled != nil && spilled.(*expvar.Int).Value() != metrics.Spilled.Value() {
+		t.Fatal("wrong number of spilled events", spilled, metrics.Spilled.Value())
+	}
+	if metrics.SemaphoreWait.Count() != int64(len(chunks)) {
+		t.Fatal("wrong number of semaphore acquisitions", len(chunks), metrics.SemaphoreWait.Count())
+	}
+	if len(processed) != 0 && metrics.ProcessLatency.Count() == 0 {
+		t.Fatal("processing latency isn't measured")
+	}
+}
+
+func TestProcessorPriority(t *testing.T) {
+	nodes := tdag.GenNodes(6)
+
+	var genesis dag.Events
+	for _, node := range nodes {
+		e := &tdag.TestEvent{}
+		e.SetCreator(node)
+		e.SetSeq(1)
+		e.SetLamport(1)
+		e.SetEpoch(1)
+		e.SetFrame(1)
+		e.SetID([24]byte{byte(len(genesis) + 1)})
+		genesis = append(genesis, e)
+	}
+
+	semaphore := datasemaphore.New(dag.Metric{Num: 100, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	config := DefaultConfig(cachescale.Identity)
+	config.LowPriorityLamportDiff = 10
+
+	highestLamport := idx.Lamport(0)
+	var processed dag.Events
+	mu := sync.Mutex{}
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				processed = append(processed, e)
+				return nil
+			},
+			Exists: func(e hash.Event) bool {
+				return false
+			},
+			Get: func(id hash.Event) dag.Event {
+				return nil
+			},
+			CheckParents: func(e dag.Event, parents dag.Events) error {
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return highestLamport
+		},
+	})
+
+	// auto priority
+	if p := processor.Priority("", genesis); p != HighPriority {
+		t.Fatalf("self-events got %d priority", p)
+	}
+	if p := processor.Priority("peer", genesis); p != NormalPriority {
+		t.Fatalf("recent events got %d priority", p)
+	}
+	highestLamport = 100
+	if p := processor.Priority("peer", genesis); p != LowPriority {
+		t.Fatalf("old events got %d priority", p)
+	}
+	highestLamport = 0
+
+	// enqueue before start, so batches with a higher priority go ahead
+	wg := sync.WaitGroup{}
+	priorities := []Priority{LowPriority, NormalPriority, HighPriority}
+	for i, priority := range priorities {
+		wg.Add(1)
+		err := processor.EnqueueWithPriority("peer", genesis[i*2:i*2+2], true, priority, nil, wg.Done)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	processor.Start()
+	wg.Wait()
+	processor.Stop()
+
+	if len(processed) != len(genesis) {
+		t.Fatalf("processed %d events, expected %d", len(processed), len(genesis))
+	}
+	for i := range processed {
+		expected := genesis[len(genesis)-(i/2+1)*2+i%2]
+		if processed[i].ID() != expected.ID() {
+			t.Fatalf("%d-th processed event is %s, expected %s", i, processed[i].String(), expected.String())
+		}
+	}
+}
+
+func TestProcessorContext(t *testing.T) {
+	nodes := tdag.GenNodes(2)
+
+	var events dag.Events
+	for i, node := range nodes {
+		e := &tdag.TestEvent{}
+		e.SetCreator(node)
+		e.SetSeq(1)
+		e.SetLamport(1)
+		e.SetEpoch(1)
+		e.SetID([24]byte{byte(i + 1)})
+		events = append(events, e)
+	}
+
+	semaphore := datasemaphore.New(dag.Metric{Num: 2, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	config := DefaultConfig(cachescale.Identity)
+	config.EventsSemaphoreTimeout = 50 * time.Millisecond
+
+	var releasedErrs []error
+	mu := sync.Mutex{}
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				t.Fatal("canceled event is processed")
+				return nil
+			},
+			Released: func(e dag.Event, peer string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				releasedErrs = append(releasedErrs, err)
+			},
+			Exists: func(e hash.Event) bool {
+				return false
+			},
+			Get: func(id hash.Event) dag.Event {
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return 0
+		},
+	})
+
+	// the semaphore waiting is interrupted by the timeout and by ctx
+	if !semaphore.TryAcquire(dag.Metric{Num: 2}) {
+		t.Fatal("failed to acquire the semaphore")
+	}
+	start := time.Now()
+	if err := processor.Enqueue("peer", events, false, nil, nil); err != ErrBusy {
+		t.Fatal("expected ErrBusy, got", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := processor.EnqueueContext(ctx, "peer", events, false, NormalPriority, nil, nil); err != context.DeadlineExceeded {
+		t.Fatal("expected DeadlineExceeded, got", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("semaphore waiting isn't interrupted")
+	}
+	semaphore.Release(dag.Metric{Num: 2})
+
+	// queued events are released once ctx is canceled
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	if err := processor.EnqueueContext(ctx, "peer", events, false, NormalPriority, nil, func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	runCtx, stop := context.WithCancel(context.Background())
+	processor.StartContext(runCtx)
+	<-done
+	mu.Lock()
+	if len(releasedErrs) != len(events) {
+		t.Fatal("not all the canceled events are released", len(releasedErrs))
+	}
+	for _, err := range releasedErrs {
+		if err != context.Canceled {
+			t.Fatal("expected context.Canceled, got", err)
+		}
+	}
+	mu.Unlock()
+	if semaphore.Processing().Num != 0 {
+		t.Fatal("semaphore isn't released")
+	}
+
+	// the processor is stopped with ctx, and Stop is idempotent
+	stop()
+	processor.Stop()
+	if err := processor.Enqueue("peer", events, false, nil, nil); err == nil {
+		t.Fatal("stopped processor accepted events")
+	}
+}
+
+func TestProcessorCanceledNoPenalty(t *testing.T) {
+	nodes := tdag.GenNodes(12)
+
+	var events dag.Events
+	for i, node := range nodes {
+		e := &tdag.TestEvent{}
+		e.SetCreator(node)
+		e.SetSeq(1)
+		e.SetLamport(1)
+		e.SetEpoch(1)
+		e.SetID([24]byte{byte(i + 1)})
+		events = append(events, e)
+	}
+
+	semaphore := datasemaphore.New(dag.Metric{Num: 100, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	scores := peerscore.New(peerscore.DefaultConfig(), peerscore.DefaultClassifier)
+	processor := New(semaphore, DefaultConfig(cachescale.Identity), Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				t.Fatal("canceled event is processed")
+				return nil
+			},
+			Released: scores.Released,
+			Exists: func(e hash.Event) bool {
+				return false
+			},
+			Get: func(id hash.Event) dag.Event {
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return 0
+		},
+	})
+
+	// the events are in-flight until the processor is started
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	if err := processor.EnqueueContext(ctx, "peer", events, false, NormalPriority, nil, func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	processor.Start()
+	<-done
+	processor.Stop()
+
+	if semaphore.Processing().Num != 0 {
+		t.Fatal("canceled events aren't released")
+	}
+	if score := scores.Score("peer"); score != 0 || scores.Banned("peer") {
+		t.Fatal("peer is penalized for canceled events", score)
+	}
+}
+
+func TestProcessorQuarantineReplayFailure(t *testing.T) {
+	parent := &tdag.TestEvent{}
+	parent.SetSeq(1)
+	parent.SetLamport(1)
+	parent.SetEpoch(1)
+	parent.SetID([24]byte{1})
+	child := &tdag.TestEvent{}
+	child.SetSeq(2)
+	child.SetLamport(2)
+	child.SetEpoch(1)
+	child.SetParents(hash.Events{parent.ID()})
+	child.SetID([24]byte{2})
+
+	quarantine := dagordering.NewQuarantine(memorydb.New(), dag.Metric{Num: 10, Size: 100000}, dagordering.QuarantineCallback{
+		Marshal: func(e dag.Event) ([]byte, error) {
+			return e.ID().Bytes(), nil
+		},
+		Unmarshal: func(b []byte) (dag.Event, error) {
+			return child, nil
+		},
+	}, func(err error) {
+		t.Fatal(err)
+	})
+	semaphore := datasemaphore.New(dag.Metric{Num: 100, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	scores := peerscore.New(peerscore.DefaultConfig(), peerscore.DefaultClassifier)
+	config := DefaultConfig(cachescale.Identity)
+	config.EventsBufferLimit = dag.Metric{Num: 0, Size: 100000}
+	var mu sync.Mutex
+	processed := make(map[hash.Event]dag.Event)
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				processed[e.ID()] = e
+				return nil
+			},
+			Released: scores.Released,
+			Exists: func(id hash.Event) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return processed[id] != nil
+			},
+			Get: func(id hash.Event) dag.Event {
+				mu.Lock()
+				defer mu.Unlock()
+				return processed[id]
+			},
+			CheckParents: func(e dag.Event, parents dag.Events) error {
+				if e.ID() == child.ID() {
+					return parentscheck.ErrWrongLamport
+				}
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return 0
+		},
+		Quarantine: quarantine,
+	})
+	processor.Start()
+	defer processor.Stop()
+
+	enqueue := func(peer string, e dag.Event) {
+		done := make(chan struct{})
+		if err := processor.Enqueue(peer, dag.Events{e}, false, nil, func() { close(done) }); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+	}
+	// the child is quarantined, which isn't penalized
+	enqueue("peer", child)
+	if !quarantine.Contains(child.ID()) {
+		t.Fatal("event isn't quarantined")
+	}
+	if score := scores.Score("peer"); score != 0 {
+		t.Fatal("peer is penalized for a quarantined event", score)
+	}
+	// the child fails after it's replayed, the original peer is penalized
+	enqueue("another", parent)
+	if score := scores.Score("peer"); score == 0 {
+		t.Fatal("peer isn't penalized for an invalid replayed event")
+	}
+	if score := scores.Score("another"); score != 0 {
+		t.Fatal("wrong peer is penalized", score)
+	}
+	if semaphore.Processing().Num != 0 {
+		t.Fatal("events aren't released")
+	}
+}
+
+func TestProcessorQuarantineSweep(t *testing.T) {
+	parent := &tdag.TestEvent{}
+	parent.SetSeq(1)
+	parent.SetLamport(1)
+	parent.SetEpoch(1)
+	parent.SetID([24]byte{1})
+	children := make(dag.Events, 2)
+	for i := range children {
+		e := &tdag.TestEvent{}
+		e.SetSeq(2)
+		e.SetLamport(2)
+		e.SetEpoch(1)
+		e.SetParents(hash.Events{hash.Event{byte(i + 1)}})
+		e.SetID([24]byte{byte(i + 2)})
+		children[i] = e
+	}
+	children[0].(*tdag.TestEvent).SetParents(hash.Events{parent.ID()})
+
+	quarantine := dagordering.NewQuarantine(memorydb.New(), dag.Metric{Num: 10, Size: 100000}, dagordering.QuarantineCallback{
+		Marshal: func(e dag.Event) ([]byte, error) {
+			return e.ID().Bytes(), nil
+		},
+		Unmarshal: func(b []byte) (dag.Event, error) {
+			for _, e := range children {
+				if bytes.Equal(e.ID().Bytes(), b) {
+					return e, nil
+				}
+			}
+			return nil, errors.New("unknown event")
+		},
+	}, func(err error) {
+		t.Fatal(err)
+	})
+	semaphore := datasemaphore.New(dag.Metric{Num: 100, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	config := DefaultConfig(cachescale.Identity)
+	config.EventsBufferLimit = dag.Metric{Num: 0, Size: 100000}
+	config.QuarantineSweepInterval = 10 * time.Millisecond
+	var mu sync.Mutex
+	processed := make(map[hash.Event]dag.Event)
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				processed[e.ID()] = e
+				return nil
+			},
+			Released: func(e dag.Event, peer string, err error) {},
+			Exists: func(id hash.Event) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return processed[id] != nil
+			},
+			Get: func(id hash.Event) dag.Event {
+				mu.Lock()
+				defer mu.Unlock()
+				return processed[id]
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return 0
+		},
+		Quarantine: quarantine,
+	})
+	processor.Start()
+	defer processor.Stop()
+
+	for _, e := range children {
+		done := make(chan struct{})
+		if err := processor.Enqueue("peer", dag.Events{e}, false, nil, func() { close(done) }); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+	}
+	if quarantine.Total().Num != 2 {
+		t.Fatal("events aren't quarantined")
+	}
+
+	// the parent is connected not through the processor
+	mu.Lock()
+	processed[parent.ID()] = parent
+	mu.Unlock()
+	isProcessed := func(id hash.Event) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return processed[id] != nil
+	}
+	for start := time.Now(); !isProcessed(children[0].ID()); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("quarantined event isn't replayed")
+		}
+	}
+	if quarantine.Total().Num != 1 {
+		t.Fatal("event without parents is replayed")
+	}
+
+	// the quarantine is cleared on stop
+	processor.Stop()
+	if quarantine.Total() != (dag.Metric{}) {
+		t.Fatal("quarantine isn't cleared")
+	}
+	if semaphore.Processing().Num != 0 {
+		t.Fatal("events aren't released")
+	}
+}
+
+func TestProcessorParallelChecks(t *testing.T) {
+	nodes := tdag.GenNodes(5)
+
+	var ordered dag.Events
+	_ = tdag.ForEachRandEvent(nodes, 40, 3, rand.New(rand.NewSource(0)), tdag.ForEachEvent{ // nolint:gosec
+		Process: func(e dag.Event, name string) {
+			ordered = append(ordered, e)
+		},
+		Build: func(e dag.MutableEvent, name string) error {
+			e.SetEpoch(1)
+			return nil
+		},
+	})
+
+	limit := ordered.Metric()
+	semaphore := datasemaphore.New(limit, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	config := DefaultConfig(cachescale.Identity)
+	config.EventsBufferLimit = limit
+	config.CheckerThreads = 4
+
+	var checking, maxChecking int32
+	var processedOrder dag.Events
+	processed := make(map[hash.Event]dag.Event)
+	mu := sync.RWMutex{}
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				processed[e.ID()] = e
+				processedOrder = append(processedOrder, e)
+				return nil
+			},
+			Released: func(e dag.Event, peer string, err error) {
+				if err != nil {
+					t.Errorf("%s unexpectedly dropped with '%s'", e.String(), err)
+				}
+			},
+			Exists: func(e hash.Event) bool {
+				mu.RLock()
+				defer mu.RUnlock()
+				return processed[e] != nil
+			},
+			Get: func(id hash.Event) dag.Event {
+				mu.RLock()
+				defer mu.RUnlock()
+				return processed[id]
+			},
+			CheckParents: func(e dag.Event, parents dag.Events) error {
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				n := atomic.AddInt32(&checking, 1)
+				for {
+					max := atomic.LoadInt32(&maxChecking)
+					if n <= max || atomic.CompareAndSwapInt32(&maxChecking, max, n) {
+						break
+					}
+				}
+				// random check durations shuffle the results
+				time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond) // nolint:gosec
+				atomic.AddInt32(&checking, -1)
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return idx.Lamport(len(ordered))
+		},
+	})
+	processor.Start()
+
+	wg := sync.WaitGroup{}
+	for start := 0; start < len(ordered); start += 50 {
+		end := start + 50
+		if end > len(ordered) {
+			end = len(ordered)
+		}
+		wg.Add(1)
+		err := processor.Enqueue("", ordered[start:end], true, nil, wg.Done)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	processor.Stop()
+
+	// checks are concurrent, but the events are inserted in the enqueued order
+	if atomic.LoadInt32(&maxChecking) < 2 {
+		t.Fatal("checks aren't concurrent")
+	}
+	if len(processedOrder) != len(ordered) {
+		t.Fatal("not all the events were processed")
+	}
+	for i, e := range ordered {
+		if processedOrder[i] != e {
+			t.Fatalf("event %s is inserted out of order", e.String())
+		}
+	}
+}
+
+func TestProcessorCheckerSlots(t *testing.T) {
+	var events dag.Events
+	for i := 0; i < 8; i++ {
+		e := &tdag.TestEvent{}
+		e.SetSeq(1)
+		e.SetLamport(1)
+		e.SetEpoch(1)
+		e.SetID([24]byte{byte(i + 1)})
+		events = append(events, e)
+	}
+
+	semaphore := datasemaphore.New(dag.Metric{Num: 100, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	config := DefaultConfig(cachescale.Identity)
+	config.CheckerThreads = 8
+	config.MaxTasks = 4
+
+	unblock := make(chan struct{})
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				return nil
+			},
+			Released: func(e dag.Event, peer string, err error) {},
+			Exists: func(e hash.Event) bool {
+				return false
+			},
+			Get: func(id hash.Event) dag.Event {
+				return nil
+			},
+			CheckParents: func(e dag.Event, parents dag.Events) error {
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				<-unblock
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return 0
+		},
+	})
+	processor.Start()
+	defer processor.Stop()
+
+	// every batch takes a single slot, regardless of the number of checker threads
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		if err := processor.Enqueue("peer", events, false, nil, wg.Done); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := processor.checker.TasksCount(); n > 3 {
+		t.Fatal("batches take too many checker slots", n)
+	}
+	if processor.Overloaded() {
+		t.Fatal("processor is overloaded")
+	}
+	close(unblock)
+	wg.Wait()
+}
+
+func TestProcessorLoad(t *testing.T) {
+	nodes := tdag.GenNodes(4)
+
+	var events dag.Events
+	for i, node := range nodes {
+		e := &tdag.TestEvent{}
+		e.SetCreator(node)
+		e.SetSeq(1)
+		e.SetLamport(1)
+		e.SetEpoch(1)
+		e.SetID([24]byte{byte(i + 1)})
+		events = append(events, e)
+	}
+
+	semaphore := datasemaphore.New(dag.Metric{Num: 4, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	config := DefaultConfig(cachescale.Identity)
+	config.LoadCheckInterval = 10 * time.Millisecond
+
+	var changes []LoadLevel
+	mu := sync.Mutex{}
+	normal := make(chan struct{})
+	processed := make(map[hash.Event]bool)
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				processed[e.ID()] = true
+				return nil
+			},
+			Released: func(e dag.Event, peer string, err error) {
+				if err != nil {
+					t.Errorf("%s unexpectedly dropped with '%s'", e.String(), err)
+				}
+			},
+			Exists: func(e hash.Event) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return processed[e]
+			},
+			Get: func(id hash.Event) dag.Event {
+				return nil
+			},
+			CheckParents: func(e dag.Event, parents dag.Events) error {
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return 0
+		},
+		LoadChanged: func(prev LoadLevel, cur Load) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, cur.Level)
+			if cur.Level == LoadNormal {
+				close(normal)
+			}
+		},
+	})
+	suspendHigh := processor.SuspendAt(LoadHigh)
+	suspendOverloaded := processor.SuspendAt(LoadOverloaded)
+
+	if load := processor.Load(); load.Level != LoadNormal || load.Queued != 0 || load.Ratio != 0 {
+		t.Fatal("idle processor is loaded", load)
+	}
+
+	// events aren't inserted until the processor is started, so they fill the events semaphore
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	if err := processor.Enqueue("peer", events[:3], false, nil, wg.Done); err != nil {
+		t.Fatal(err)
+	}
+	if load := processor.Load(); load.Level != LoadHigh || load.Queued != 3 || load.Ratio != 0.75 {
+		t.Fatal("unexpected load", load)
+	}
+	if !suspendHigh() || suspendOverloaded() {
+		t.Fatal("unexpected suspension")
+	}
+	if err := processor.Enqueue("peer", events[3:], false, nil, wg.Done); err != nil {
+		t.Fatal(err)
+	}
+	if load := processor.Load(); load.Level != LoadOverloaded || load.Queued != 4 || load.Ratio != 1 {
+		t.Fatal("unexpected load", load)
+	}
+	if !suspendOverloaded() {
+		t.Fatal("overloaded processor isn't suspended")
+	}
+	// Overloaded considers only the task queues
+	if processor.Overloaded() {
+		t.Fatal("processor with short queues is overloaded")
+	}
+
+	// the load decrease is noticed by the periodic checks
+	processor.Start()
+	wg.Wait()
+	select {
+	case <-normal:
+	case <-time.After(5 * time.Second):
+		t.Fatal("load decrease isn't reported")
+	}
+	processor.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// a periodic check may catch an intermediate level while the events are inserted
+	if len(changes) < 3 || changes[0] != LoadHigh || changes[1] != LoadOverloaded || changes[len(changes)-1] != LoadNormal {
+		t.Fatal("unexpected load changes", changes)
+	}
+	if load := processor.Load(); load.Queued != 0 || load.Delay != 0 {
+		t.Fatal("drained processor has a delay", load)
+	}
+	if len(processed) != len(events) {
+		t.Fatal("not all the events were processed")
+	}
+}
+
+func TestProcessorMaxLamportDiff(t *testing.T) {
+	config := DefaultConfig(cachescale.Identity)
+	// the accepted Lamport range doesn't depend on the buffer size
+	config.EventsBufferLimit = dag.Metric{Num: 100000, Size: 100000}
+	config.MaxLamportDiff = 14
+	testProcessorMaxLamportDiff(t, config)
+}
+
+func TestProcessorZeroMaxLamportDiff(t *testing.T) {
+	config := DefaultConfig(cachescale.Identity)
+	// the accepted Lamport range is limited by the buffer size
+	config.EventsBufferLimit = dag.Metric{Num: 14, Size: 100000}
+	config.MaxLamportDiff = 0
+	testProcessorMaxLamportDiff(t, config)
+}
+
+func testProcessorMaxLamportDiff(t *testing.T, config Config) {
+	nodes := tdag.GenNodes(2)
+
+	var events dag.Events
+	for i, node := range nodes {
+		e := &tdag.TestEvent{}
+		e.SetCreator(node)
+		e.SetSeq(1)
+		e.SetLamport(idx.Lamport(10 * (i + 1)))
+		e.SetEpoch(1)
+		e.SetID([24]byte{byte(i + 1)})
+		events = append(events, e)
+	}
+
+	semaphore := datasemaphore.New(dag.Metric{Num: 10, Size: 100000}, func(received dag.Metric, processing dag.Metric, releasing dag.Metric) {
+		t.Fatal("events semaphore inconsistency")
+	})
+	released := make(map[hash.Event]error)
+	mu := sync.Mutex{}
+	processor := New(semaphore, config, Callback{
+		Event: EventCallback{
+			Process: func(e dag.Event) error {
+				return nil
+			},
+			Released: func(e dag.Event, peer string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				released[e.ID()] = err
+			},
+			Exists: func(e hash.Event) bool {
+				return false
+			},
+			Get: func(id hash.Event) dag.Event {
+				return nil
+			},
+			CheckParents: func(e dag.Event, parents dag.Events) error {
+				return nil
+			},
+			CheckParentless: func(e dag.Event, checked func(err error)) {
+				checked(nil)
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return 0
+		},
+	})
+	processor.Start()
+	done := make(chan struct{})
+	if err := processor.Enqueue("peer", events, false, nil, func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	processor.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err, ok := released[events[0].ID()]; !ok || err != nil {
+		t.Fatal("event within MaxLamportDiff isn't processed", err)
+	}
+	if err := released[events[1].ID()]; err != eventcheck.ErrSpilledEvent {
+		t.Fatal("expected ErrSpilledEvent, got", err)
+	}
 }
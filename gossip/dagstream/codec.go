@@ -0,0 +1,48 @@
+package dagstream
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+)
+
+// ProtocolMaxMsgSize is a maximum size of a message payload
+const ProtocolMaxMsgSize = 10 * 1024 * 1024
+
+var (
+	ErrMsgTooLarge = errors.New("message is too large")
+	ErrUnknownCode = errors.New("unknown message code")
+)
+
+// Msg is an encoded message of the protocol
+type Msg struct {
+	Code    uint64
+	Payload []byte
+}
+
+// Encode encodes a message with RLP
+func Encode(code uint64, val interface{}) (Msg, error) {
+	b, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		return Msg{}, err
+	}
+	if len(b) > ProtocolMaxMsgSize {
+		return Msg{}, ErrMsgTooLarge
+	}
+	return Msg{
+		Code:    code,
+		Payload: b,
+	}, nil
+}
+
+// Decode decodes the message payload into val
+func (msg Msg) Decode(val interface{}) error {
+	if len(msg.Payload) > ProtocolMaxMsgSize {
+		return ErrMsgTooLarge
+	}
+	if err := rlp.DecodeBytes(msg.Payload, val); err != nil {
+		return fmt.Errorf("msg %d: %w", msg.Code, err)
+	}
+	return nil
+}
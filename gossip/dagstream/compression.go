@@ -0,0 +1,124 @@
+package dagstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/DataDog/zstd"
+	"github.com/golang/snappy"
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+)
+
+// Payload compressions
+const (
+	CompressionNone   = basestream.CompressionNone
+	CompressionSnappy = basestream.CompressionSnappy
+	CompressionZstd   = basestream.CompressionZstd
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+	ErrDecompressedTooLarge   = errors.New("decompressed payload is too large")
+)
+
+// SupportedCompressions are the compressions which may be passed to basestreamseeder.Config.Compressions
+var SupportedCompressions = []basestream.Compression{CompressionSnappy, CompressionZstd}
+
+// compressedPayloadRLP is a form of Payload, which gets compressed
+type compressedPayloadRLP struct {
+	IDs    hash.Events
+	Events []rlp.RawValue
+}
+
+// CompressedPayload is a compressed Payload
+type CompressedPayload struct {
+	Compression basestream.Compression
+	Data        []byte
+	// Num is a number of events in the payload
+	Num int
+	// Size is a size of the uncompressed payload
+	Size uint64
+}
+
+var _ basestream.Payload = (*CompressedPayload)(nil)
+var _ basestream.CompressiblePayload = (*Payload)(nil)
+
+func (p *CompressedPayload) Len() int {
+	return p.Num
+}
+
+// TotalSize returns a size of the compressed data
+func (p *CompressedPayload) TotalSize() uint64 {
+	return uint64(len(p.Data))
+}
+
+// RawSize returns a size of the uncompressed payload
+func (p *CompressedPayload) RawSize() uint64 {
+	return p.Size
+}
+
+func (p *CompressedPayload) TotalMemSize() int {
+	return len(p.Data) + 32
+}
+
+// Compress returns the payload compressed with the algorithm
+func (p *Payload) Compress(c basestream.Compression) (basestream.Payload, error) {
+	raw, err := rlp.EncodeToBytes(compressedPayloadRLP{
+		IDs:    p.IDs,
+		Events: p.Events,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := compress(c, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressedPayload{
+		Compression: c,
+		Data:        data,
+		Num:         p.Len(),
+		Size:        p.Size,
+	}, nil
+}
+
+func compress(c basestream.Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	case CompressionZstd:
+		return zstd.Compress(nil, data)
+	}
+	return nil, ErrUnsupportedCompression
+}
+
+// decompress decompresses the data, which mustn't exceed maxSize after decompression
+func decompress(c basestream.Compression, data []byte, maxSize int) ([]byte, error) {
+	switch c {
+	case CompressionSnappy:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxSize {
+			return nil, ErrDecompressedTooLarge
+		}
+		return snappy.Decode(nil, data)
+	case CompressionZstd:
+		r := zstd.NewReader(bytes.NewReader(data))
+		defer r.Close()
+		res, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(res) > maxSize {
+			return nil, ErrDecompressedTooLarge
+		}
+		return res, nil
+	}
+	return nil, ErrUnsupportedCompression
+}
@@ -0,0 +1,774 @@
+package dagstream
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream/basestreamseeder"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
+)
+
+func TestLocator(t *testing.T) {
+	a := EpochLamportLocator(1, 2)
+	require.Equal(t, idx.Epoch(1), a.Epoch())
+	require.Equal(t, idx.Lamport(2), a.Lamport())
+	require.Equal(t, -1, EpochLocator(1).Compare(a))
+	require.Equal(t, -1, a.Compare(EpochLocator(2)))
+	require.Equal(t, 0, a.Compare(a))
+
+	b := Locator{}
+	b[31] = 0xff
+	b[30] = 0xff
+	next := b.Inc().(Locator)
+	require.Equal(t, 1, next.Compare(b))
+	require.Equal(t, byte(1), next[29])
+	require.Equal(t, byte(0), next[30])
+	require.Equal(t, byte(0), next[31])
+
+	max := Locator{}
+	for i := range max {
+		max[i] = 0xff
+	}
+	require.Equal(t, max, max.Inc())
+}
+
+func TestCodec(t *testing.T) {
+	ids := hash.FakeEvents(3)
+	sort.Slice(ids, func(i, j int) bool {
+		return EventLocator(ids[i]).Compare(EventLocator(ids[j])) < 0
+	})
+	raws := []rlp.RawValue{{0x81, 0x80}, {0x01}, {0xc0}}
+
+	for _, c := range []struct {
+		code uint64
+		in   interface{}
+		out  interface{}
+	}{
+		{AnnounceHashesMsg, &AnnounceHashes{IDs: ids}, &AnnounceHashes{}},
+		{RequestEventsMsg, &RequestEvents{IDs: ids}, &RequestEvents{}},
+		{EventsMsg, &Events{Events: raws}, &Events{}},
+		{StreamRequestMsg, &StreamRequest{
+			Session: Session{
+				ID:    7,
+				Start: EpochLamportLocator(1, 2),
+				Stop:  EpochLocator(3),
+			},
+			Type:           RequestTypeEvents,
+			MaxPayloadNum:  10,
+			MaxPayloadSize: 1000,
+			MaxChunks:      2,
+		}, &StreamRequest{}},
+		{StreamResponseMsg, &StreamResponse{SessionID: 7, Done: true, IDs: ids, Events: raws}, &StreamResponse{}},
+		{ReconcileRequestMsg, &Reconcile{SessionID: 7, Ranges: []ReconcileRange{
+			{Start: EpochLocator(1), Stop: EpochLocator(2), Mode: ModeFingerprint, Fingerprint: hash.Of([]byte{1}), IDs: hash.Events{}},
+			{Start: EpochLocator(2), Stop: EpochLocator(3), Mode: ModeIDs, IDs: ids},
+		}}, &Reconcile{}},
+	} {
+		msg, err := Encode(c.code, c.in)
+		require.NoError(t, err)
+		require.Equal(t, c.code, msg.Code)
+		require.NoError(t, msg.Decode(c.out))
+		require.Equal(t, c.in, c.out)
+	}
+
+	_, err := Encode(EventsMsg, &Events{Events: []rlp.RawValue{make([]byte, ProtocolMaxMsgSize)}})
+	require.ErrorIs(t, err, ErrMsgTooLarge)
+	require.Error(t, Msg{Code: EventsMsg, Payload: []byte{0xff}}.Decode(&Events{}))
+
+	// basestream conversions
+	req := StreamRequest{Session: Session{ID: 1, Start: EpochLocator(1), Stop: EpochLocator(2)}, MaxChunks: 3}
+	require.Equal(t, req, NewStreamRequest(req.Request()))
+	resp := StreamResponse{SessionID: 1, IDs: ids, Events: raws}
+	require.Equal(t, resp, NewStreamResponse(resp.Response()))
+	require.Equal(t, 3, resp.Response().Payload.Len())
+
+	// validation
+	require.NoError(t, StreamResponse{IDs: ids}.Validate())
+	require.ErrorIs(t, StreamResponse{IDs: ids, Events: raws[:1]}.Validate(), ErrMalformedResponse)
+	require.ErrorIs(t, StreamResponse{IDs: hash.Events{ids[0], ids[0]}}.Validate(), ErrMalformedResponse)
+}
+
+func TestSeederLeecher(t *testing.T) {
+	for _, rType := range []uint8{uint8(RequestTypeIDs), uint8(RequestTypeEvents)} {
+		for _, c := range []basestream.Compression{CompressionNone, CompressionSnappy, CompressionZstd, 0xff} {
+			testSeederLeecher(t, rType, c)
+		}
+	}
+}
+
+func testSeederLeecher(t *testing.T, rType uint8, compression basestream.Compression) {
+	t.Helper()
+	nodes := tdag.GenNodes(5)
+	var events dag.Events
+	for epoch := idx.Epoch(1); epoch <= 3; epoch++ {
+		_ = tdag.ForEachRandEvent(nodes, 20, 3, nil, tdag.ForEachEvent{
+			Process: func(e dag.Event, name string) {
+				events = append(events, e)
+			},
+			Build: func(e dag.MutableEvent, name string) error {
+				e.SetEpoch(epoch)
+				return nil
+			},
+		})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return EventLocator(events[i].ID()).Compare(EventLocator(events[j].ID())) < 0
+	})
+
+	start, stop := EpochLamportLocator(2, 3), EpochLocator(3)
+	var expected hash.Events
+	for _, e := range events {
+		l := EventLocator(e.ID())
+		if l.Compare(start) >= 0 && l.Compare(stop) < 0 {
+			expected = append(expected, e.ID())
+		}
+	}
+
+	net := NewMemNetwork()
+	defer net.Close()
+	net.OnError = func(peer, from string, err error) {
+		t.Error(peer, from, err)
+	}
+	misbehaviour := func(peer string, err error) {
+		t.Error(peer, err)
+	}
+
+	// seeder
+	seeder := basestreamseeder.New(basestreamseeder.Config{
+		SenderThreads:           2,
+		MaxSenderTasks:          32,
+		MaxPendingResponsesSize: 1024 * 1024,
+		MaxResponsePayloadNum:   100,
+		MaxResponsePayloadSize:  1024 * 1024,
+		MaxResponseChunks:       4,
+		Compressions:            SupportedCompressions,
+	}, basestreamseeder.Callbacks{
+		ForEachItem: ForEachItem(func(start Locator, withRaw bool, onEvent func(id hash.Event, raw rlp.RawValue) bool) {
+			i := sort.Search(len(events), func(i int) bool {
+				return EventLocator(events[i].ID()).Compare(start) >= 0
+			})
+			for ; i < len(events); i++ {
+				var raw rlp.RawValue
+				if withRaw {
+					raw = events[i].(*tdag.TestEvent).Bytes()
+				}
+				if !onEvent(events[i].ID(), raw) {
+					return
+				}
+			}
+		}),
+	})
+	seeder.Start()
+	defer seeder.Stop()
+	expectedCompression := compression
+	if compression > CompressionZstd {
+		// unsupported compression falls back to uncompressed responses
+		expectedCompression = CompressionNone
+	}
+	net.Register("seeder", Router{
+		StreamRequestMsg: SeederHandler(seeder, func(to string, msg Msg) error {
+			var r StreamResponse
+			require.NoError(t, msg.Decode(&r))
+			require.Equal(t, expectedCompression, r.Compression)
+			return net.Send("seeder", to, msg)
+		}, misbehaviour),
+	}.Handle)
+
+	// leecher
+	cfg := DefaultLeecherConfig()
+	cfg.RecheckInterval = time.Millisecond
+	cfg.Type = RequestTypeIDs
+	if rType == uint8(RequestTypeEvents) {
+		cfg.Type = RequestTypeEvents
+	}
+	cfg.MaxPayloadNum = 7
+	cfg.MaxChunks = 2
+	cfg.Compression = compression
+
+	var mu sync.Mutex
+	var received hash.Events
+	leecher := NewLeecher(cfg, start, stop, net.Sender("leecher"), LeecherCallbacks{
+		OnPayload: func(peer string, ids hash.Events, raws []rlp.RawValue) error {
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, "seeder", peer)
+			if cfg.Type == RequestTypeEvents {
+				require.Equal(t, len(ids), len(raws))
+				for i, raw := range raws {
+					var e tdag.TestEventMarshaling
+					require.NoError(t, rlp.DecodeBytes(raw, &e))
+					require.Equal(t, ids[i], e.ID)
+				}
+			} else {
+				require.Empty(t, raws)
+			}
+			received = append(received, ids...)
+			return nil
+		},
+		Misbehaviour: misbehaviour,
+	})
+	net.Register("leecher", Router{
+		StreamResponseMsg: leecher.Handle,
+	}.Handle)
+	leecher.Start()
+	defer leecher.Stop()
+	require.NoError(t, leecher.RegisterPeer("seeder"))
+
+	require.Eventually(t, leecher.Done, 5*time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, expected, received)
+}
+
+func TestSplitLocators(t *testing.T) {
+	// by epochs
+	require.Equal(t, []Locator{EpochLocator(3), EpochLocator(5)},
+		SplitLocators(EpochLamportLocator(1, 5), EpochLocator(7), 3))
+	// by Lamport
+	require.Equal(t, []Locator{EpochLamportLocator(2, 5), EpochLamportLocator(2, 10), EpochLamportLocator(2, 15)},
+		SplitLocators(EpochLocator(2), EpochLamportLocator(2, 20), 4))
+	// not enough space to split
+	require.Equal(t, []Locator{EpochLamportLocator(2, 1)},
+		SplitLocators(EpochLocator(2), EpochLamportLocator(2, 2), 4))
+	require.Empty(t, SplitLocators(EpochLocator(2), EpochLocator(2), 4))
+	require.Empty(t, SplitLocators(EpochLocator(2), EpochLocator(3), 1))
+}
+
+func TestParallelLeecher(t *testing.T) {
+	nodes := tdag.GenNodes(5)
+	var events dag.Events
+	for epoch := idx.Epoch(1); epoch <= 4; epoch++ {
+		_ = tdag.ForEachRandEvent(nodes, 20, 3, nil, tdag.ForEachEvent{
+			Process: func(e dag.Event, name string) {
+				events = append(events, e)
+			},
+			Build: func(e dag.MutableEvent, name string) error {
+				e.SetEpoch(epoch)
+				return nil
+			},
+		})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return EventLocator(events[i].ID()).Compare(EventLocator(events[j].ID())) < 0
+	})
+	start, stop := EpochLamportLocator(1, 3), EpochLocator(4)
+	var expected hash.Events
+	for _, e := range events {
+		l := EventLocator(e.ID())
+		if l.Compare(start) >= 0 && l.Compare(stop) < 0 {
+			expected = append(expected, e.ID())
+		}
+	}
+
+	net := NewMemNetwork()
+	defer net.Close()
+	net.OnError = func(peer, from string, err error) {
+		t.Error(peer, from, err)
+	}
+
+	// honest seeders and a liar, which sends wrong events
+	for _, name := range []string{"seeder1", "seeder2", "liar"} {
+		liar := name == "liar"
+		seeder := basestreamseeder.New(basestreamseeder.Config{
+			SenderThreads:           2,
+			MaxSenderTasks:          32,
+			MaxPendingResponsesSize: 1024 * 1024,
+			MaxResponsePayloadNum:   100,
+			MaxResponsePayloadSize:  1024 * 1024,
+			MaxResponseChunks:       4,
+		}, basestreamseeder.Callbacks{
+			ForEachItem: ForEachItem(func(start Locator, withRaw bool, onEvent func(id hash.Event, raw rlp.RawValue) bool) {
+				i := sort.Search(len(events), func(i int) bool {
+					return EventLocator(events[i].ID()).Compare(start) >= 0
+				})
+				for ; i < len(events); i++ {
+					e := events[i]
+					if liar {
+						e = events[(i+1)%len(events)]
+					}
+					if !onEvent(events[i].ID(), e.(*tdag.TestEvent).Bytes()) {
+						return
+					}
+				}
+			}),
+		})
+		seeder.Start()
+		defer seeder.Stop()
+		net.Register(name, Router{
+			StreamRequestMsg: SeederHandler(seeder, net.Sender(name), func(peer string, err error) {
+				t.Error(peer, err)
+			}),
+		}.Handle)
+	}
+	// slow peer never responds
+	net.Register("slow", func(peer string, msg Msg) error {
+		return nil
+	})
+
+	cfg := DefaultParallelLeecherConfig()
+	cfg.RecheckInterval = time.Millisecond
+	cfg.SessionTimeout = 50 * time.Millisecond
+	cfg.MaxPayloadNum = 7
+	cfg.MaxChunks = 2
+	cfg.MaxBufferedChunks = 3
+	cfg.Ranges = 3
+
+	var mu sync.Mutex
+	var received hash.Events
+	senders := map[string]bool{}
+	misbehaved := map[string]bool{}
+	leecher := NewParallelLeecher(cfg, start, stop, net.Sender("leecher"), LeecherCallbacks{
+		OnPayload: func(peer string, ids hash.Events, raws []rlp.RawValue) error {
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, len(ids), len(raws))
+			for i, raw := range raws {
+				var e tdag.TestEventMarshaling
+				require.NoError(t, rlp.DecodeBytes(raw, &e))
+				if ids[i] != e.ID {
+					return ErrMalformedResponse
+				}
+			}
+			senders[peer] = true
+			received = append(received, ids...)
+			return nil
+		},
+		Misbehaviour: func(peer string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			misbehaved[peer] = true
+		},
+	})
+	net.Register("leecher", Router{
+		StreamResponseMsg: leecher.Handle,
+	}.Handle)
+	leecher.Start()
+	defer leecher.Stop()
+	for _, peer := range []string{"slow", "liar", "seeder1", "seeder2"} {
+		require.NoError(t, leecher.RegisterPeer(peer))
+	}
+
+	require.Eventually(t, leecher.Done, 10*time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, expected, received)
+	require.False(t, senders["liar"])
+	require.False(t, senders["slow"])
+	require.False(t, misbehaved["seeder1"])
+	require.False(t, misbehaved["seeder2"])
+}
+
+func TestLeecherLocalError(t *testing.T) {
+	nodes := tdag.GenNodes(5)
+	var events dag.Events
+	_ = tdag.ForEachRandEvent(nodes, 30, 3, nil, tdag.ForEachEvent{
+		Process: func(e dag.Event, name string) {
+			events = append(events, e)
+		},
+		Build: func(e dag.MutableEvent, name string) error {
+			e.SetEpoch(1)
+			return nil
+		},
+	})
+	sort.Slice(events, func(i, j int) bool {
+		return EventLocator(events[i].ID()).Compare(EventLocator(events[j].ID())) < 0
+	})
+	var expected hash.Events
+	for _, e := range events {
+		expected = append(expected, e.ID())
+	}
+	start, stop := EpochLocator(1), EpochLocator(2)
+
+	type leecher interface {
+		Start()
+		Stop()
+		Done() bool
+		RegisterPeer(peer string) error
+		Handle(peer string, msg Msg) error
+	}
+	for _, parallel := range []bool{false, true} {
+		net := NewMemNetwork()
+		seeder := basestreamseeder.New(basestreamseeder.Config{
+			SenderThreads:           1,
+			MaxSenderTasks:          32,
+			MaxPendingResponsesSize: 1024 * 1024,
+			MaxResponsePayloadNum:   100,
+			MaxResponsePayloadSize:  1024 * 1024,
+			MaxResponseChunks:       4,
+		}, basestreamseeder.Callbacks{
+			ForEachItem: ForEachItem(func(start Locator, withRaw bool, onEvent func(id hash.Event, raw rlp.RawValue) bool) {
+				i := sort.Search(len(events), func(i int) bool {
+					return EventLocator(events[i].ID()).Compare(start) >= 0
+				})
+				for ; i < len(events); i++ {
+					if !onEvent(events[i].ID(), events[i].(*tdag.TestEvent).Bytes()) {
+						return
+					}
+				}
+			}),
+		})
+		seeder.Start()
+		net.Register("seeder", Router{
+			StreamRequestMsg: SeederHandler(seeder, net.Sender("seeder"), func(peer string, err error) {
+				t.Error(peer, err)
+			}),
+		}.Handle)
+
+		var mu sync.Mutex
+		var received hash.Events
+		calls := 0
+		callbacks := LeecherCallbacks{
+			OnPayload: func(peer string, ids hash.Events, raws []rlp.RawValue) error {
+				mu.Lock()
+				defer mu.Unlock()
+				// every other delivery fails locally, e.g. because the events processor is busy
+				calls++
+				if calls%2 == 1 {
+					return &basestream.LocalError{Err: errors.New("busy")}
+				}
+				received = append(received, ids...)
+				return nil
+			},
+			Misbehaviour: func(peer string, err error) {
+				t.Error("honest peer is reported", peer, err)
+			},
+		}
+		var l leecher
+		if parallel {
+			cfg := DefaultParallelLeecherConfig()
+			cfg.RecheckInterval = time.Millisecond
+			cfg.MaxPayloadNum = 7
+			cfg.MaxChunks = 2
+			cfg.Ranges = 3
+			l = NewParallelLeecher(cfg, start, stop, net.Sender("leecher"), callbacks)
+		} else {
+			cfg := DefaultLeecherConfig()
+			cfg.RecheckInterval = time.Millisecond
+			cfg.MaxPayloadNum = 7
+			cfg.MaxChunks = 2
+			l = NewLeecher(cfg, start, stop, net.Sender("leecher"), callbacks)
+		}
+		net.Register("leecher", Router{
+			StreamResponseMsg: l.Handle,
+		}.Handle)
+		l.Start()
+		require.NoError(t, l.RegisterPeer("seeder"))
+
+		require.Eventually(t, l.Done, 5*time.Second, time.Millisecond)
+		l.Stop()
+		seeder.Stop()
+		net.Close()
+		mu.Lock()
+		require.Equal(t, expected, received, parallel)
+		mu.Unlock()
+	}
+}
+
+func TestLeecherResume(t *testing.T) {
+	nodes := tdag.GenNodes(5)
+	var events dag.Events
+	_ = tdag.ForEachRandEvent(nodes, 30, 3, nil, tdag.ForEachEvent{
+		Process: func(e dag.Event, name string) {
+			events = append(events, e)
+		},
+		Build: func(e dag.MutableEvent, name string) error {
+			e.SetEpoch(1)
+			return nil
+		},
+	})
+	sort.Slice(events, func(i, j int) bool {
+		return EventLocator(events[i].ID()).Compare(EventLocator(events[j].ID())) < 0
+	})
+	start, stop := EpochLocator(1), EpochLocator(2)
+
+	net := NewMemNetwork()
+	defer net.Close()
+	seeder := basestreamseeder.New(basestreamseeder.Config{
+		SenderThreads:           1,
+		MaxSenderTasks:          32,
+		MaxPendingResponsesSize: 1024 * 1024,
+		MaxResponsePayloadNum:   100,
+		MaxResponsePayloadSize:  1024 * 1024,
+		MaxResponseChunks:       4,
+	}, basestreamseeder.Callbacks{
+		ForEachItem: ForEachItem(func(start Locator, withRaw bool, onEvent func(id hash.Event, raw rlp.RawValue) bool) {
+			i := sort.Search(len(events), func(i int) bool {
+				return EventLocator(events[i].ID()).Compare(start) >= 0
+			})
+			for ; i < len(events); i++ {
+				if !onEvent(events[i].ID(), nil) {
+					return
+				}
+			}
+		}),
+	})
+	seeder.Start()
+	defer seeder.Stop()
+	net.Register("seeder", Router{
+		StreamRequestMsg: SeederHandler(seeder, net.Sender("seeder"), func(peer string, err error) {
+			t.Error(peer, err)
+		}),
+	}.Handle)
+
+	cfg := DefaultLeecherConfig()
+	cfg.RecheckInterval = time.Millisecond
+	cfg.Type = RequestTypeIDs
+	cfg.MaxPayloadNum = 7
+	cfg.MaxChunks = 2
+
+	progress := NewProgressStore(memorydb.New(), func(err error) {
+		panic(err)
+	})
+	key := []byte("sync")
+
+	var mu sync.Mutex
+	var received hash.Events
+	download := func(limit int) *Leecher {
+		leecher := NewLeecher(cfg, progress.Resume(key, start), stop, net.Sender("leecher"), LeecherCallbacks{
+			OnPayload: func(peer string, ids hash.Events, raws []rlp.RawValue) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if len(received) >= limit {
+					// imitate a crash, the payload isn't processed
+					return errors.New("crash")
+				}
+				received = append(received, ids...)
+				return nil
+			},
+			SaveProgress: progress.Saver(key),
+		})
+		net.Register("leecher", Router{
+			StreamResponseMsg: leecher.Handle,
+		}.Handle)
+		leecher.Start()
+		require.NoError(t, leecher.RegisterPeer("seeder"))
+		return leecher
+	}
+
+	// download a part of the range and stop
+	leecher := download(len(events) / 2)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) >= len(events)/2
+	}, 5*time.Second, time.Millisecond)
+	leecher.Stop()
+	net.Unregister("leecher")
+	next, ok := progress.Get(key)
+	require.True(t, ok)
+	require.Equal(t, EventLocator(received[len(received)-1]).Inc(), next)
+
+	// resume after a restart, nothing is downloaded twice
+	leecher = download(len(events))
+	defer leecher.Stop()
+	require.Eventually(t, leecher.Done, 5*time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, events.IDs(), received)
+	next, _ = progress.Get(key)
+	require.Equal(t, stop, next)
+}
+
+func sortedForEach(ids hash.Events) ForEachEventFn {
+	sort.Slice(ids, func(i, j int) bool {
+		return EventLocator(ids[i]).Compare(EventLocator(ids[j])) < 0
+	})
+	return func(start Locator, withRaw bool, onEvent func(id hash.Event, raw rlp.RawValue) bool) {
+		i := sort.Search(len(ids), func(i int) bool {
+			return EventLocator(ids[i]).Compare(start) >= 0
+		})
+		for ; i < len(ids); i++ {
+			if !onEvent(ids[i], nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	for _, c := range []struct {
+		name               string
+		common, local, own int
+	}{
+		{"equal", 3000, 0, 0},
+		{"empty", 0, 0, 500},
+		{"emptyPeer", 0, 500, 0},
+		{"fewDiffs", 3000, 20, 30},
+		{"manyDiffs", 2000, 1000, 1500},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			testReconcile(t, c.common, c.local, c.own)
+		})
+	}
+}
+
+func testReconcile(t *testing.T, common, localOnly, peerOnly int) {
+	t.Helper()
+	fake := func(n int) hash.Events {
+		ids := make(hash.Events, n)
+		for i := range ids {
+			ids[i] = hash.FakeEvent()
+			copy(ids[i][0:4], idx.Epoch(1).Bytes())
+		}
+		return ids
+	}
+	commonIDs, localIDs, peerIDs := fake(common), fake(localOnly), fake(peerOnly)
+	local := append(append(hash.Events{}, commonIDs...), localIDs...)
+	peer := append(append(hash.Events{}, commonIDs...), peerIDs...)
+
+	cfg := DefaultReconcileConfig()
+	net := NewMemNetwork()
+	defer net.Close()
+	net.OnError = func(peer, from string, err error) {
+		t.Error(peer, from, err)
+	}
+	misbehaviour := func(peer string, err error) {
+		t.Error(peer, err)
+	}
+	var sent int
+	send := func(from string) SendFn {
+		return func(to string, msg Msg) error {
+			sent += len(msg.Payload)
+			return net.Send(from, to, msg)
+		}
+	}
+
+	net.Register("peer", Router{
+		ReconcileRequestMsg: ReconcileHandler(cfg, sortedForEach(peer), send("peer"), misbehaviour),
+	}.Handle)
+
+	var mu sync.Mutex
+	missing := hash.EventsSet{}
+	done := make(chan struct{})
+	reconciler := NewReconciler(cfg, send("local"), ReconcilerCallbacks{
+		ForEach: sortedForEach(local),
+		OnMissing: func(peer string, ids hash.Events) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, id := range ids {
+				require.False(t, missing.Contains(id))
+				missing.Add(id)
+			}
+		},
+		OnDone: func(peer string) {
+			close(done)
+		},
+		Misbehaviour: misbehaviour,
+	})
+	net.Register("local", Router{
+		ReconcileResponseMsg: reconciler.Handle,
+	}.Handle)
+
+	require.NoError(t, reconciler.Reconcile("peer", EpochLocator(1), EpochLocator(2)))
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout")
+	}
+	require.False(t, reconciler.Ongoing("peer"))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, peerIDs.Set(), missing)
+	if localOnly+peerOnly < common/10 {
+		// less than the IDs of all the events are transferred
+		require.Less(t, sent, common*len(hash.Event{}))
+	}
+}
+
+func TestReconcileMisbehaviour(t *testing.T) {
+	cfg := DefaultReconcileConfig()
+	net := NewMemNetwork()
+	defer net.Close()
+
+	// peer responds with a range outside of the session
+	net.Register("peer", func(peer string, msg Msg) error {
+		var r Reconcile
+		require.NoError(t, msg.Decode(&r))
+		resp, err := Encode(ReconcileResponseMsg, Reconcile{
+			SessionID: r.SessionID,
+			Ranges: []ReconcileRange{{
+				Start: EpochLocator(2),
+				Stop:  EpochLocator(3),
+				Mode:  ModeMissing,
+				IDs:   hash.Events{},
+			}},
+		})
+		require.NoError(t, err)
+		return net.Send("peer", peer, resp)
+	})
+
+	misbehaved := make(chan error, 1)
+	reconciler := NewReconciler(cfg, net.Sender("local"), ReconcilerCallbacks{
+		ForEach: sortedForEach(hash.FakeEvents(10)),
+		OnMissing: func(peer string, ids hash.Events) {
+			t.Error("unexpected missing", ids)
+		},
+		Misbehaviour: func(peer string, err error) {
+			misbehaved <- err
+		},
+	})
+	net.Register("local", Router{
+		ReconcileResponseMsg: reconciler.Handle,
+	}.Handle)
+
+	require.NoError(t, reconciler.Reconcile("peer", EpochLocator(1), EpochLocator(2)))
+	select {
+	case err := <-misbehaved:
+		require.ErrorIs(t, err, ErrMalformedReconcile)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout")
+	}
+	require.False(t, reconciler.Ongoing("peer"))
+}
+
+func TestCompression(t *testing.T) {
+	ids := hash.FakeEvents(50)
+	sort.Slice(ids, func(i, j int) bool {
+		return EventLocator(ids[i]).Compare(EventLocator(ids[j])) < 0
+	})
+	p := &Payload{}
+	for i, id := range ids {
+		raw, err := rlp.EncodeToBytes(make([]byte, 100+i))
+		require.NoError(t, err)
+		p.AddEvent(id, raw)
+	}
+
+	for _, c := range SupportedCompressions {
+		compressed, err := p.Compress(c)
+		require.NoError(t, err)
+		require.Equal(t, p.Len(), compressed.Len())
+		require.Less(t, compressed.TotalSize(), p.TotalSize())
+		require.Equal(t, p.TotalSize(), compressed.(*CompressedPayload).RawSize())
+
+		msg, err := Encode(StreamResponseMsg, NewStreamResponse(basestream.Response{SessionID: 1, Payload: compressed}))
+		require.NoError(t, err)
+		var r StreamResponse
+		require.NoError(t, msg.Decode(&r))
+		require.NoError(t, r.Decompress())
+		require.Equal(t, StreamResponse{SessionID: 1, IDs: ids, Events: p.Events}, r)
+
+		// decompression is limited
+		_, err = decompress(c, compressed.(*CompressedPayload).Data, 100)
+		require.ErrorIs(t, err, ErrDecompressedTooLarge)
+	}
+
+	_, err := p.Compress(0xff)
+	require.ErrorIs(t, err, ErrUnsupportedCompression)
+	r := StreamResponse{Compression: 0xff, Compressed: []byte{1}}
+	require.ErrorIs(t, r.Decompress(), ErrUnsupportedCompression)
+	r = StreamResponse{Compressed: []byte{1}}
+	require.ErrorIs(t, r.Decompress(), ErrMalformedResponse)
+	r = StreamResponse{Compression: CompressionSnappy, IDs: ids, Compressed: []byte{1}}
+	require.ErrorIs(t, r.Decompress(), ErrMalformedResponse)
+}
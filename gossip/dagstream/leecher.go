@@ -0,0 +1,245 @@
+package dagstream
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream/basestreamleecher"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+)
+
+type LeecherConfig struct {
+	RecheckInterval time.Duration
+	// SessionTimeout is a time without responses, after which a session is terminated
+	SessionTimeout time.Duration
+
+	Type           basestream.RequestType
+	MaxPayloadNum  uint32
+	MaxPayloadSize uint64
+	MaxChunks      uint32
+	// Compression is a requested payload compression, seeders which don't support it respond uncompressed
+	Compression basestream.Compression
+}
+
+func DefaultLeecherConfig() LeecherConfig {
+	return LeecherConfig{
+		RecheckInterval: time.Second,
+		SessionTimeout:  30 * time.Second,
+		Type:            RequestTypeEvents,
+		MaxPayloadNum:   500,
+		MaxPayloadSize:  512 * 1024,
+		MaxChunks:       12,
+	}
+}
+
+type LeecherCallbacks struct {
+	// OnPayload is called for every received chunk, in the stream order.
+	// events are empty for RequestTypeIDs.
+	// If a basestream.LocalError is returned, then the session is terminated and the chunk is requested again.
+	// If another error is returned, then the session is terminated and the peer is reported as misbehaving.
+	// OnPayload is called under the leecher's lock, so it must not call the leecher's methods.
+	OnPayload func(peer string, ids hash.Events, events []rlp.RawValue) error
+	// Misbehaviour is optional
+	Misbehaviour func(peer string, err error)
+	// SaveProgress is called with the first not downloaded locator after each successful OnPayload,
+	// so the download may be resumed from it after a restart. Optional.
+	SaveProgress func(next Locator)
+}
+
+type leecherSession struct {
+	id        uint32
+	peer      string
+	start     Locator
+	chunks    uint32
+	lastChunk time.Time
+	ongoing   bool
+}
+
+// Leecher downloads a range of events from peers with StreamRequestMsg requests
+type Leecher struct {
+	*basestreamleecher.BaseLeecher
+
+	cfg      LeecherConfig
+	callback LeecherCallbacks
+	send     SendFn
+
+	next Locator
+	stop Locator
+	done bool
+
+	session         leecherSession
+	sessionsCounter uint32
+}
+
+// NewLeecher creates a leecher of events in range [start, stop)
+func NewLeecher(cfg LeecherConfig, start, stop Locator, send SendFn, callback LeecherCallbacks) *Leecher {
+	d := &Leecher{
+		cfg:      cfg,
+		callback: callback,
+		send:     send,
+		next:     start,
+		stop:     stop,
+		// sessions of a restarted leecher must not collide with the sessions known by seeders
+		sessionsCounter: rand.Uint32(), // nolint:gosec
+	}
+	d.BaseLeecher = basestreamleecher.New(cfg.RecheckInterval, basestreamleecher.Callbacks{
+		SelectSessionPeerCandidates: d.selectSessionPeerCandidates,
+		ShouldTerminateSession:      d.shouldTerminateSession,
+		StartSession:                d.startSession,
+		TerminateSession:            d.terminateSession,
+		OngoingSession: func() bool {
+			return d.session.ongoing
+		},
+		OngoingSessionPeer: func() string {
+			return d.session.peer
+		},
+	})
+	return d
+}
+
+// Done returns true if the whole range is downloaded
+func (d *Leecher) Done() bool {
+	d.Mu.RLock()
+	defer d.Mu.RUnlock()
+	return d.done
+}
+
+// Next returns the locator of the next not downloaded event
+func (d *Leecher) Next() Locator {
+	d.Mu.RLock()
+	defer d.Mu.RUnlock()
+	return d.next
+}
+
+func (d *Leecher) selectSessionPeerCandidates() []string {
+	if d.done {
+		return nil
+	}
+	candidates := make([]string, 0, len(d.Peers))
+	for p := range d.Peers {
+		candidates = append(candidates, p)
+	}
+	return candidates
+}
+
+func (d *Leecher) shouldTerminateSession() bool {
+	return time.Since(d.session.lastChunk) > d.cfg.SessionTimeout
+}
+
+func (d *Leecher) startSession(candidates []string) {
+	peer := candidates[rand.Intn(len(candidates))] // nolint:gosec
+	d.sessionsCounter++
+	d.session = leecherSession{
+		id:        d.sessionsCounter,
+		peer:      peer,
+		start:     d.next,
+		lastChunk: time.Now(),
+		ongoing:   true,
+	}
+	if err := d.request(); err != nil {
+		d.session.ongoing = false
+	}
+}
+
+func (d *Leecher) terminateSession() {
+	d.session.ongoing = false
+}
+
+func (d *Leecher) request() error {
+	msg, err := Encode(StreamRequestMsg, StreamRequest{
+		Session: Session{
+			ID:    d.session.id,
+			Start: d.session.start,
+			Stop:  d.stop,
+		},
+		Type:           d.cfg.Type,
+		MaxPayloadNum:  d.cfg.MaxPayloadNum,
+		MaxPayloadSize: d.cfg.MaxPayloadSize,
+		MaxChunks:      d.cfg.MaxChunks,
+		Compression:    d.cfg.Compression,
+	})
+	if err != nil {
+		return err
+	}
+	d.session.chunks = 0
+	return d.send(d.session.peer, msg)
+}
+
+func (d *Leecher) misbehaviour(peer string, err error) {
+	d.session.ongoing = false
+	if d.callback.Misbehaviour != nil {
+		d.callback.Misbehaviour(peer, err)
+	}
+}
+
+// Handle is a Handler of StreamResponseMsg
+func (d *Leecher) Handle(peer string, msg Msg) error {
+	d.Mu.Lock()
+	defer d.Mu.Unlock()
+
+	if d.Terminated || !d.session.ongoing || d.session.peer != peer {
+		return nil
+	}
+	var r StreamResponse
+	if err := msg.Decode(&r); err != nil {
+		d.misbehaviour(peer, err)
+		return nil
+	}
+	if r.SessionID != d.session.id {
+		// response of an outdated session
+		return nil
+	}
+	if err := r.Decompress(); err != nil {
+		d.misbehaviour(peer, err)
+		return nil
+	}
+	if err := r.Validate(); err != nil {
+		d.misbehaviour(peer, err)
+		return nil
+	}
+	if len(r.IDs) != 0 {
+		if EventLocator(r.IDs[0]).Compare(d.next) < 0 || EventLocator(r.IDs[len(r.IDs)-1]).Compare(d.stop) >= 0 {
+			d.misbehaviour(peer, ErrMalformedResponse)
+			return nil
+		}
+		if d.cfg.Type == RequestTypeEvents && len(r.Events) != len(r.IDs) {
+			d.misbehaviour(peer, ErrMalformedResponse)
+			return nil
+		}
+		if err := d.callback.OnPayload(peer, r.IDs, r.Events); err != nil {
+			if basestream.IsLocalError(err) {
+				// the peer isn't guilty, the chunk will be requested again in a new session
+				d.session.ongoing = false
+				return nil
+			}
+			d.misbehaviour(peer, err)
+			return nil
+		}
+		d.next = EventLocator(r.IDs[len(r.IDs)-1]).Inc().(Locator)
+		if d.callback.SaveProgress != nil {
+			d.callback.SaveProgress(d.next)
+		}
+	}
+	d.session.lastChunk = time.Now()
+	d.session.chunks++
+
+	if r.Done {
+		d.done = true
+		d.session.ongoing = false
+		if d.callback.SaveProgress != nil {
+			d.callback.SaveProgress(d.stop)
+		}
+		return nil
+	}
+	if d.session.chunks >= d.cfg.MaxChunks {
+		// request next chunks of the same session
+		if err := d.request(); err != nil {
+			d.session.ongoing = false
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package dagstream
+
+import (
+	"bytes"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+)
+
+// Locator is a position in a stream of events, ordered by event IDs.
+// Event IDs start with epoch and Lamport, so the events are streamed in (epoch, lamport) order.
+type Locator hash.Event
+
+var _ basestream.Locator = Locator{}
+
+// EventLocator returns a locator of the event
+func EventLocator(id hash.Event) Locator {
+	return Locator(id)
+}
+
+// EpochLamportLocator returns a locator of the first possible event with the given epoch and Lamport
+func EpochLamportLocator(epoch idx.Epoch, lamport idx.Lamport) Locator {
+	var l Locator
+	copy(l[0:4], epoch.Bytes())
+	copy(l[4:8], lamport.Bytes())
+	return l
+}
+
+// EpochLocator returns a locator of the first possible event of the epoch
+func EpochLocator(epoch idx.Epoch) Locator {
+	return EpochLamportLocator(epoch, 0)
+}
+
+func (l Locator) Compare(b basestream.Locator) int {
+	bl := b.(Locator)
+	return bytes.Compare(l[:], bl[:])
+}
+
+// Inc returns the next locator. The maximum locator isn't incremented.
+func (l Locator) Inc() basestream.Locator {
+	next := l
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+	// overflow
+	return l
+}
+
+// Epoch returns the locator's epoch
+func (l Locator) Epoch() idx.Epoch {
+	return hash.Event(l).Epoch()
+}
+
+// Lamport returns the locator's Lamport
+func (l Locator) Lamport() idx.Lamport {
+	return hash.Event(l).Lamport()
+}
+
+// SplitLocators returns locators which split [start, stop) into up to n ranges.
+// If the range spans several epochs, then it's split by epochs, otherwise it's split by Lamport.
+func SplitLocators(start, stop Locator, n int) []Locator {
+	if n < 2 || start.Compare(stop) >= 0 {
+		return nil
+	}
+	res := make([]Locator, 0, n-1)
+	add := func(l Locator) {
+		if l.Compare(start) <= 0 || l.Compare(stop) >= 0 {
+			return
+		}
+		if len(res) != 0 && l.Compare(res[len(res)-1]) <= 0 {
+			return
+		}
+		res = append(res, l)
+	}
+	if start.Epoch() != stop.Epoch() {
+		from, span := uint64(start.Epoch()), uint64(stop.Epoch()-start.Epoch())
+		for i := 1; i < n; i++ {
+			add(EpochLocator(idx.Epoch(from + span*uint64(i)/uint64(n))))
+		}
+		return res
+	}
+	from, span := uint64(start.Lamport()), uint64(stop.Lamport()-start.Lamport())
+	for i := 1; i < n; i++ {
+		add(EpochLamportLocator(start.Epoch(), idx.Lamport(from+span*uint64(i)/uint64(n))))
+	}
+	return res
+}
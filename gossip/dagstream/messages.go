@@ -0,0 +1,231 @@
+package dagstream
+
+import (
+	"errors"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+)
+
+// Message codes of the protocol
+const (
+	// AnnounceHashesMsg announces IDs of new events, AnnounceHashes
+	AnnounceHashesMsg uint64 = iota
+	// RequestEventsMsg requests events by IDs, RequestEvents
+	RequestEventsMsg
+	// EventsMsg contains events, Events
+	EventsMsg
+	// StreamRequestMsg requests a chunk of the events stream, StreamRequest
+	StreamRequestMsg
+	// StreamResponseMsg contains a chunk of the events stream, StreamResponse
+	StreamResponseMsg
+	// ReconcileRequestMsg contains ranges of the initiator's event IDs to reconcile, Reconcile
+	ReconcileRequestMsg
+	// ReconcileResponseMsg contains ranges of the responder's event IDs to reconcile, Reconcile
+	ReconcileResponseMsg
+)
+
+var (
+	ErrMalformedResponse  = errors.New("malformed stream response")
+	ErrMalformedReconcile = errors.New("malformed reconcile message")
+)
+
+type AnnounceHashes struct {
+	IDs hash.Events
+}
+
+type RequestEvents struct {
+	IDs hash.Events
+}
+
+type Events struct {
+	// Events are RLP-encoded events
+	Events []rlp.RawValue
+}
+
+type Session struct {
+	ID    uint32
+	Start Locator
+	Stop  Locator
+}
+
+// StreamRequest is a wire form of basestream.Request
+type StreamRequest struct {
+	Session        Session
+	Type           basestream.RequestType
+	MaxPayloadNum  uint32
+	MaxPayloadSize uint64
+	MaxChunks      uint32
+	Compression    basestream.Compression `rlp:"optional"`
+}
+
+// StreamResponse is a wire form of basestream.Response
+type StreamResponse struct {
+	SessionID uint32
+	Done      bool
+	IDs       hash.Events
+	Events    []rlp.RawValue
+	// Compressed is a compressed payload, IDs and Events are empty if it's set
+	Compression basestream.Compression `rlp:"optional"`
+	Compressed  []byte                 `rlp:"optional"`
+}
+
+// NewStreamRequest converts a basestream.Request with Locator selectors into a wire form
+func NewStreamRequest(r basestream.Request) StreamRequest {
+	return StreamRequest{
+		Session: Session{
+			ID:    r.Session.ID,
+			Start: r.Session.Start.(Locator),
+			Stop:  r.Session.Stop.(Locator),
+		},
+		Type:           r.Type,
+		MaxPayloadNum:  r.MaxPayloadNum,
+		MaxPayloadSize: r.MaxPayloadSize,
+		MaxChunks:      r.MaxChunks,
+		Compression:    r.Compression,
+	}
+}
+
+// Request converts the request into basestream.Request
+func (r StreamRequest) Request() basestream.Request {
+	return basestream.Request{
+		Session: basestream.Session{
+			ID:    r.Session.ID,
+			Start: r.Session.Start,
+			Stop:  r.Session.Stop,
+		},
+		Type:           r.Type,
+		MaxPayloadNum:  r.MaxPayloadNum,
+		MaxPayloadSize: r.MaxPayloadSize,
+		MaxChunks:      r.MaxChunks,
+		Compression:    r.Compression,
+	}
+}
+
+// NewStreamResponse converts a basestream.Response with *Payload or *CompressedPayload into a wire form
+func NewStreamResponse(r basestream.Response) StreamResponse {
+	if p, ok := r.Payload.(*CompressedPayload); ok {
+		return StreamResponse{
+			SessionID:   r.SessionID,
+			Done:        r.Done,
+			Compression: p.Compression,
+			Compressed:  p.Data,
+		}
+	}
+	p := r.Payload.(*Payload)
+	return StreamResponse{
+		SessionID: r.SessionID,
+		Done:      r.Done,
+		IDs:       p.IDs,
+		Events:    p.Events,
+	}
+}
+
+// Decompress decompresses the payload into IDs and Events
+func (r *StreamResponse) Decompress() error {
+	if r.Compression == CompressionNone {
+		if len(r.Compressed) != 0 {
+			return ErrMalformedResponse
+		}
+		return nil
+	}
+	if len(r.IDs) != 0 || len(r.Events) != 0 {
+		return ErrMalformedResponse
+	}
+	raw, err := decompress(r.Compression, r.Compressed, ProtocolMaxMsgSize)
+	if err != nil {
+		return err
+	}
+	var p compressedPayloadRLP
+	if err := rlp.DecodeBytes(raw, &p); err != nil {
+		return err
+	}
+	r.IDs, r.Events = p.IDs, p.Events
+	r.Compression, r.Compressed = CompressionNone, nil
+	return nil
+}
+
+// Response converts the response into basestream.Response
+func (r StreamResponse) Response() basestream.Response {
+	p := &Payload{}
+	for _, id := range r.IDs {
+		p.Size += uint64(len(id))
+	}
+	p.IDs = r.IDs
+	for _, e := range r.Events {
+		p.Size += uint64(len(e))
+	}
+	p.Events = r.Events
+	return basestream.Response{
+		SessionID: r.SessionID,
+		Done:      r.Done,
+		Payload:   p,
+	}
+}
+
+// Validate checks that the response is consistent
+func (r StreamResponse) Validate() error {
+	if len(r.Events) != 0 && len(r.Events) != len(r.IDs) {
+		return ErrMalformedResponse
+	}
+	for i := 1; i < len(r.IDs); i++ {
+		if EventLocator(r.IDs[i-1]).Compare(EventLocator(r.IDs[i])) >= 0 {
+			return ErrMalformedResponse
+		}
+	}
+	return nil
+}
+
+// ReconcileMode is a type of a reconciled range
+type ReconcileMode uint8
+
+const (
+	// ModeFingerprint range contains a fingerprint of the sender's events in the range
+	ModeFingerprint ReconcileMode = iota
+	// ModeIDs range contains all the sender's event IDs in the range
+	ModeIDs
+	// ModeMissing range contains IDs of the sender's events in the range, which the receiver doesn't have
+	ModeMissing
+)
+
+// ReconcileRange is a range [Start, Stop) of event IDs
+type ReconcileRange struct {
+	Start       Locator
+	Stop        Locator
+	Mode        ReconcileMode
+	Fingerprint hash.Hash
+	IDs         hash.Events
+}
+
+// Reconcile is a round of the set reconciliation
+type Reconcile struct {
+	SessionID uint32
+	Ranges    []ReconcileRange
+}
+
+// Validate checks that the message is consistent and isn't larger than the limits
+func (r Reconcile) Validate(maxRanges, maxIDs int) error {
+	if len(r.Ranges) > maxRanges {
+		return ErrMalformedReconcile
+	}
+	for _, rr := range r.Ranges {
+		if rr.Start.Compare(rr.Stop) >= 0 || rr.Mode > ModeMissing || len(rr.IDs) > maxIDs {
+			return ErrMalformedReconcile
+		}
+		if rr.Mode == ModeFingerprint && len(rr.IDs) != 0 {
+			return ErrMalformedReconcile
+		}
+		for i, id := range rr.IDs {
+			l := EventLocator(id)
+			if l.Compare(rr.Start) < 0 || l.Compare(rr.Stop) >= 0 {
+				return ErrMalformedReconcile
+			}
+			if i > 0 && EventLocator(rr.IDs[i-1]).Compare(l) >= 0 {
+				return ErrMalformedReconcile
+			}
+		}
+	}
+	return nil
+}
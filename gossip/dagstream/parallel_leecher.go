@@ -0,0 +1,118 @@
+package dagstream
+
+import (
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream/basestreamleecher"
+)
+
+type ParallelLeecherConfig struct {
+	LeecherConfig
+	// Ranges is a number of ranges which are downloaded concurrently from different peers
+	Ranges int
+	// MaxBufferedChunks is a max number of received chunks of a range, which wait for the previous ranges
+	MaxBufferedChunks int
+}
+
+func DefaultParallelLeecherConfig() ParallelLeecherConfig {
+	return ParallelLeecherConfig{
+		LeecherConfig:     DefaultLeecherConfig(),
+		Ranges:            16,
+		MaxBufferedChunks: 24,
+	}
+}
+
+// ParallelLeecher downloads a range of events from several peers concurrently.
+// Unlike Leecher, the range is split into smaller ranges, which are reassigned to other peers if a peer is slow or misbehaves.
+// OnPayload is still called in the stream order.
+type ParallelLeecher struct {
+	*basestreamleecher.ParallelLeecher
+
+	cfg      ParallelLeecherConfig
+	callback LeecherCallbacks
+	send     SendFn
+}
+
+// NewParallelLeecher creates a parallel leecher of events in range [start, stop)
+func NewParallelLeecher(cfg ParallelLeecherConfig, start, stop Locator, send SendFn, callback LeecherCallbacks) *ParallelLeecher {
+	d := &ParallelLeecher{
+		cfg:      cfg,
+		callback: callback,
+		send:     send,
+	}
+	d.ParallelLeecher = basestreamleecher.NewParallel(basestreamleecher.ParallelConfig{
+		RecheckInterval:   cfg.RecheckInterval,
+		Ranges:            cfg.Ranges,
+		RangeTimeout:      cfg.SessionTimeout,
+		MaxChunks:         cfg.MaxChunks,
+		MaxBufferedChunks: cfg.MaxBufferedChunks,
+	}, start, stop, basestreamleecher.ParallelCallbacks{
+		Split: func(start, stop basestream.Locator, n int) []basestream.Locator {
+			locators := SplitLocators(start.(Locator), stop.(Locator), n)
+			res := make([]basestream.Locator, len(locators))
+			for i, l := range locators {
+				res[i] = l
+			}
+			return res
+		},
+		Request: d.request,
+		Bounds: func(payload basestream.Payload) (first, last basestream.Locator) {
+			ids := payload.(*Payload).IDs
+			return EventLocator(ids[0]), EventLocator(ids[len(ids)-1])
+		},
+		Deliver: func(peer string, payload basestream.Payload) error {
+			p := payload.(*Payload)
+			if err := callback.OnPayload(peer, p.IDs, p.Events); err != nil {
+				return err
+			}
+			if callback.SaveProgress != nil {
+				callback.SaveProgress(EventLocator(p.IDs[len(p.IDs)-1]).Inc().(Locator))
+			}
+			return nil
+		},
+		Misbehaviour: callback.Misbehaviour,
+	})
+	return d
+}
+
+func (d *ParallelLeecher) request(peer string, session basestream.Session, maxChunks uint32) error {
+	msg, err := Encode(StreamRequestMsg, NewStreamRequest(basestream.Request{
+		Session:        session,
+		Type:           d.cfg.Type,
+		MaxPayloadNum:  d.cfg.MaxPayloadNum,
+		MaxPayloadSize: d.cfg.MaxPayloadSize,
+		MaxChunks:      maxChunks,
+		Compression:    d.cfg.Compression,
+	}))
+	if err != nil {
+		return err
+	}
+	return d.send(peer, msg)
+}
+
+func (d *ParallelLeecher) misbehaviour(peer string, err error) {
+	if d.callback.Misbehaviour != nil {
+		d.callback.Misbehaviour(peer, err)
+	}
+}
+
+// Handle is a Handler of StreamResponseMsg
+func (d *ParallelLeecher) Handle(peer string, msg Msg) error {
+	var r StreamResponse
+	if err := msg.Decode(&r); err != nil {
+		d.misbehaviour(peer, err)
+		return nil
+	}
+	if err := r.Decompress(); err != nil {
+		d.misbehaviour(peer, err)
+		return nil
+	}
+	if err := r.Validate(); err != nil {
+		d.misbehaviour(peer, err)
+		return nil
+	}
+	if d.cfg.Type == RequestTypeEvents && len(r.Events) != len(r.IDs) {
+		d.misbehaviour(peer, ErrMalformedResponse)
+		return nil
+	}
+	return d.NotifyChunkReceived(peer, r.Response())
+}
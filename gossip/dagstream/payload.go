@@ -0,0 +1,48 @@
+package dagstream
+
+import (
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+)
+
+// Request types of the stream
+const (
+	// RequestTypeIDs requests only IDs of the events
+	RequestTypeIDs basestream.RequestType = 0
+	// RequestTypeEvents requests the RLP-encoded events
+	RequestTypeEvents basestream.RequestType = 1
+)
+
+// Payload is a chunk of the streamed events
+type Payload struct {
+	IDs hash.Events
+	// Events are RLP-encoded events, empty for RequestTypeIDs
+	Events []rlp.RawValue
+	Size   uint64
+}
+
+var _ basestream.Payload = (*Payload)(nil)
+
+// AddEvent appends an event to the payload. raw is nil if only ID is streamed.
+func (p *Payload) AddEvent(id hash.Event, raw rlp.RawValue) {
+	p.IDs = append(p.IDs, id)
+	p.Size += uint64(len(id))
+	if raw != nil {
+		p.Events = append(p.Events, raw)
+		p.Size += uint64(len(raw))
+	}
+}
+
+func (p *Payload) Len() int {
+	return len(p.IDs)
+}
+
+func (p *Payload) TotalSize() uint64 {
+	return p.Size
+}
+
+func (p *Payload) TotalMemSize() int {
+	return int(p.Size) + len(p.IDs)*32 + len(p.Events)*24
+}
@@ -0,0 +1,62 @@
+package dagstream
+
+import (
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb"
+)
+
+// ProgressStore persists download progress of leechers, so a download may be resumed after a restart
+type ProgressStore struct {
+	db   u2udb.Store
+	crit func(error)
+}
+
+// NewProgressStore creates a progress store over key-value db
+func NewProgressStore(db u2udb.Store, crit func(error)) *ProgressStore {
+	return &ProgressStore{
+		db:   db,
+		crit: crit,
+	}
+}
+
+// Get returns the first not processed locator of the download
+func (s *ProgressStore) Get(key []byte) (Locator, bool) {
+	b, err := s.db.Get(key)
+	if err != nil {
+		s.crit(err)
+	}
+	if len(b) != len(Locator{}) {
+		return Locator{}, false
+	}
+	return Locator(hash.BytesToEvent(b)), true
+}
+
+// Set saves the first not processed locator of the download
+func (s *ProgressStore) Set(key []byte, next Locator) {
+	if err := s.db.Put(key, next[:]); err != nil {
+		s.crit(err)
+	}
+}
+
+// Delete erases the download progress
+func (s *ProgressStore) Delete(key []byte) {
+	if err := s.db.Delete(key); err != nil {
+		s.crit(err)
+	}
+}
+
+// Resume returns the locator to resume the download from
+func (s *ProgressStore) Resume(key []byte, start Locator) Locator {
+	next, ok := s.Get(key)
+	if !ok || next.Compare(start) < 0 {
+		return start
+	}
+	return next
+}
+
+// Saver returns a LeecherCallbacks.SaveProgress callback, which saves the progress under the key
+func (s *ProgressStore) Saver(key []byte) func(next Locator) {
+	return func(next Locator) {
+		s.Set(key, next)
+	}
+}
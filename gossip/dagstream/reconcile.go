@@ -0,0 +1,445 @@
+package dagstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+)
+
+var (
+	ErrTooManyRounds = errors.New("too many reconcile rounds")
+)
+
+/*
+ * Set reconciliation is a range-based protocol, which finds differences of two sets of event IDs.
+ * The initiator sends a fingerprint of its events in a range. If the responder's fingerprint of the range differs,
+ * then the range is either split into sub-ranges with their own fingerprints, or its event IDs are sent if the range is small.
+ * Ranges with equal fingerprints are dropped, so only the differences and O(log n) fingerprints per difference are transferred.
+ */
+
+type ReconcileConfig struct {
+	RecheckInterval time.Duration
+	// SessionTimeout is a time without responses, after which a session is terminated
+	SessionTimeout time.Duration
+
+	// Branches is a number of sub-ranges a range with different fingerprints is split into
+	Branches int
+	// MaxIDs is a max number of events in a range which is sent as a list of IDs rather than split further
+	MaxIDs int
+	// MaxRanges is a max number of ranges in a request
+	MaxRanges int
+	// MaxRounds is a max number of requests in a session
+	MaxRounds int
+}
+
+func DefaultReconcileConfig() ReconcileConfig {
+	return ReconcileConfig{
+		RecheckInterval: time.Second,
+		SessionTimeout:  30 * time.Second,
+		Branches:        16,
+		MaxIDs:          64,
+		MaxRanges:       32,
+		MaxRounds:       256,
+	}
+}
+
+// maxResponseRanges is a max number of ranges in a response
+func (c ReconcileConfig) maxResponseRanges() int {
+	return c.MaxRanges * c.Branches
+}
+
+// fingerprintAcc accumulates a fingerprint of a set of event IDs, which doesn't depend on the order of IDs
+type fingerprintAcc struct {
+	sum   [4]uint64
+	count uint64
+}
+
+// add adds the ID to the sum modulo 2^256
+func (a *fingerprintAcc) add(id hash.Event) {
+	var carry uint64
+	for i := 3; i >= 0; i-- {
+		a.sum[i], carry = bits.Add64(a.sum[i], binary.BigEndian.Uint64(id[i*8:(i+1)*8]), carry)
+	}
+	a.count++
+}
+
+func (a *fingerprintAcc) fingerprint() hash.Hash {
+	var b [40]byte
+	for i, v := range a.sum {
+		binary.BigEndian.PutUint64(b[i*8:], v)
+	}
+	binary.BigEndian.PutUint64(b[32:], a.count)
+	return hash.Of(b[:])
+}
+
+// forEachInRange iterates event IDs in [start, stop)
+func forEachInRange(forEach ForEachEventFn, start, stop Locator, onEvent func(id hash.Event) bool) {
+	forEach(start, false, func(id hash.Event, _ rlp.RawValue) bool {
+		if EventLocator(id).Compare(stop) >= 0 {
+			return false
+		}
+		return onEvent(id)
+	})
+}
+
+func rangeFingerprint(forEach ForEachEventFn, start, stop Locator) (hash.Hash, int) {
+	acc := fingerprintAcc{}
+	forEachInRange(forEach, start, stop, func(id hash.Event) bool {
+		acc.add(id)
+		return true
+	})
+	return acc.fingerprint(), int(acc.count)
+}
+
+func rangeIDs(forEach ForEachEventFn, start, stop Locator) hash.Events {
+	ids := hash.Events{}
+	forEachInRange(forEach, start, stop, func(id hash.Event) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return ids
+}
+
+// splitRange splits [start, stop) into up to Branches sub-ranges with equal numbers of events
+func (c ReconcileConfig) splitRange(forEach ForEachEventFn, start, stop Locator, count int) []ReconcileRange {
+	per := (count + c.Branches - 1) / c.Branches
+	res := make([]ReconcileRange, 0, c.Branches)
+	acc := fingerprintAcc{}
+	from := start
+	forEachInRange(forEach, start, stop, func(id hash.Event) bool {
+		if int(acc.count) >= per {
+			l := EventLocator(id)
+			res = append(res, ReconcileRange{
+				Start:       from,
+				Stop:        l,
+				Mode:        ModeFingerprint,
+				Fingerprint: acc.fingerprint(),
+			})
+			from, acc = l, fingerprintAcc{}
+		}
+		acc.add(id)
+		return true
+	})
+	return append(res, ReconcileRange{
+		Start:       from,
+		Stop:        stop,
+		Mode:        ModeFingerprint,
+		Fingerprint: acc.fingerprint(),
+	})
+}
+
+// reconcileRange processes a range received from the peer and returns the ranges to reply with.
+// missing are IDs of the peer's events, which the local node doesn't have.
+func (c ReconcileConfig) reconcileRange(forEach ForEachEventFn, r ReconcileRange, initiator bool) (reply []ReconcileRange, missing hash.Events, err error) {
+	switch r.Mode {
+	case ModeFingerprint:
+		fp, count := rangeFingerprint(forEach, r.Start, r.Stop)
+		if fp == r.Fingerprint {
+			return nil, nil, nil
+		}
+		if count <= c.MaxIDs {
+			return []ReconcileRange{{
+				Start: r.Start,
+				Stop:  r.Stop,
+				Mode:  ModeIDs,
+				IDs:   rangeIDs(forEach, r.Start, r.Stop),
+			}}, nil, nil
+		}
+		return c.splitRange(forEach, r.Start, r.Stop, count), nil, nil
+
+	case ModeIDs:
+		peerIDs := r.IDs.Set()
+		extra := hash.Events{}
+		count := 0
+		forEachInRange(forEach, r.Start, r.Stop, func(id hash.Event) bool {
+			count++
+			if peerIDs.Contains(id) {
+				peerIDs.Erase(id)
+			} else if len(extra) <= c.MaxIDs {
+				extra = append(extra, id)
+			}
+			return true
+		})
+		for _, id := range r.IDs {
+			if peerIDs.Contains(id) {
+				missing = append(missing, id)
+			}
+		}
+		if initiator || len(extra) == 0 {
+			return nil, missing, nil
+		}
+		if len(extra) <= c.MaxIDs {
+			return []ReconcileRange{{
+				Start: r.Start,
+				Stop:  r.Stop,
+				Mode:  ModeMissing,
+				IDs:   extra,
+			}}, missing, nil
+		}
+		// too many events are missing on the peer's side, reconcile smaller ranges
+		return c.splitRange(forEach, r.Start, r.Stop, count), missing, nil
+
+	case ModeMissing:
+		if !initiator {
+			return nil, nil, ErrMalformedReconcile
+		}
+		return nil, r.IDs, nil
+	}
+	return nil, nil, ErrMalformedReconcile
+}
+
+// ReconcileHandler returns a Handler of ReconcileRequestMsg, which answers the requests with the local events.
+// misbehaviour is called for malformed requests.
+func ReconcileHandler(cfg ReconcileConfig, forEach ForEachEventFn, send SendFn, misbehaviour func(peer string, err error)) Handler {
+	return func(peer string, msg Msg) error {
+		var r Reconcile
+		if err := msg.Decode(&r); err != nil {
+			misbehaviour(peer, err)
+			return nil
+		}
+		if err := r.Validate(cfg.MaxRanges, cfg.MaxIDs); err != nil {
+			misbehaviour(peer, err)
+			return nil
+		}
+		resp := Reconcile{
+			SessionID: r.SessionID,
+			Ranges:    []ReconcileRange{},
+		}
+		for _, rr := range r.Ranges {
+			reply, _, err := cfg.reconcileRange(forEach, rr, false)
+			if err != nil {
+				misbehaviour(peer, err)
+				return nil
+			}
+			resp.Ranges = append(resp.Ranges, reply...)
+		}
+		msg, err := Encode(ReconcileResponseMsg, resp)
+		if err != nil {
+			return err
+		}
+		return send(peer, msg)
+	}
+}
+
+type ReconcilerCallbacks struct {
+	// ForEach iterates the local events
+	ForEach ForEachEventFn
+	// OnMissing is called with IDs of the peer's events, which the local node doesn't have.
+	// IDs reported by the peer aren't checked against the local events, so they should be filtered by the callee.
+	OnMissing func(peer string, ids hash.Events)
+	// OnDone is called when a session is finished successfully. Optional.
+	OnDone func(peer string)
+	// Misbehaviour is optional
+	Misbehaviour func(peer string, err error)
+}
+
+type reconcileSession struct {
+	id    uint32
+	start Locator
+	stop  Locator
+	// pending are the ranges which aren't sent yet
+	pending      []ReconcileRange
+	rounds       int
+	lastResponse time.Time
+}
+
+// Reconciler finds events which peers have, but the local node doesn't, by reconciling sets of event IDs.
+// Only one session per peer is ongoing, and only one request of a session is in flight.
+type Reconciler struct {
+	cfg      ReconcileConfig
+	callback ReconcilerCallbacks
+	send     SendFn
+
+	sessions        map[string]*reconcileSession
+	sessionsCounter uint32
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+}
+
+// NewReconciler creates a Reconciler
+func NewReconciler(cfg ReconcileConfig, send SendFn, callback ReconcilerCallbacks) *Reconciler {
+	return &Reconciler{
+		cfg:      cfg,
+		callback: callback,
+		send:     send,
+		sessions: make(map[string]*reconcileSession),
+		quit:     make(chan struct{}),
+		// sessions of a restarted reconciler must not collide with the old sessions
+		sessionsCounter: rand.Uint32(), // nolint:gosec
+	}
+}
+
+// Start boots up the routine, which terminates timed out sessions
+func (r *Reconciler) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.cfg.RecheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+				r.Routine()
+			}
+		}
+	}()
+}
+
+// Stop interrupts the reconciler and waits until the routine has finished
+func (r *Reconciler) Stop() {
+	close(r.quit)
+	r.wg.Wait()
+}
+
+// Routine terminates sessions without responses for SessionTimeout
+func (r *Reconciler) Routine() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for peer, s := range r.sessions {
+		if time.Since(s.lastResponse) > r.cfg.SessionTimeout {
+			delete(r.sessions, peer)
+		}
+	}
+}
+
+// Reconcile starts a reconciliation of events in [start, stop) with the peer.
+// An ongoing session with the peer is replaced.
+func (r *Reconciler) Reconcile(peer string, start, stop Locator) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if start.Compare(stop) >= 0 {
+		return nil
+	}
+	fp, _ := rangeFingerprint(r.callback.ForEach, start, stop)
+	r.sessionsCounter++
+	s := &reconcileSession{
+		id:    r.sessionsCounter,
+		start: start,
+		stop:  stop,
+		pending: []ReconcileRange{{
+			Start:       start,
+			Stop:        stop,
+			Mode:        ModeFingerprint,
+			Fingerprint: fp,
+		}},
+	}
+	r.sessions[peer] = s
+	err := r.sendNext(peer, s)
+	if err != nil {
+		delete(r.sessions, peer)
+	}
+	return err
+}
+
+// Ongoing returns true if a session with the peer isn't finished
+func (r *Reconciler) Ongoing(peer string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.sessions[peer]
+	return ok
+}
+
+// UnregisterPeer terminates a session with the peer
+func (r *Reconciler) UnregisterPeer(peer string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, peer)
+}
+
+func (r *Reconciler) sendNext(peer string, s *reconcileSession) error {
+	n := len(s.pending)
+	if n > r.cfg.MaxRanges {
+		n = r.cfg.MaxRanges
+	}
+	msg, err := Encode(ReconcileRequestMsg, Reconcile{
+		SessionID: s.id,
+		Ranges:    s.pending[:n],
+	})
+	if err != nil {
+		return err
+	}
+	s.pending = s.pending[n:]
+	s.rounds++
+	s.lastResponse = time.Now()
+	return r.send(peer, msg)
+}
+
+func (r *Reconciler) misbehaviour(peer string, err error) {
+	delete(r.sessions, peer)
+	if r.callback.Misbehaviour != nil {
+		r.callback.Misbehaviour(peer, err)
+	}
+}
+
+// Handle is a Handler of ReconcileResponseMsg
+func (r *Reconciler) Handle(peer string, msg Msg) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.sessions[peer]
+	if s == nil {
+		return nil
+	}
+	var resp Reconcile
+	if err := msg.Decode(&resp); err != nil {
+		r.misbehaviour(peer, err)
+		return nil
+	}
+	if resp.SessionID != s.id {
+		// response of an outdated session
+		return nil
+	}
+	if err := resp.Validate(r.cfg.maxResponseRanges(), r.cfg.MaxIDs); err != nil {
+		r.misbehaviour(peer, err)
+		return nil
+	}
+	var missing hash.Events
+	for _, rr := range resp.Ranges {
+		if rr.Start.Compare(s.start) < 0 || rr.Stop.Compare(s.stop) > 0 {
+			r.misbehaviour(peer, ErrMalformedReconcile)
+			return nil
+		}
+		reply, m, err := r.cfg.reconcileRange(r.callback.ForEach, rr, true)
+		if err != nil {
+			r.misbehaviour(peer, err)
+			return nil
+		}
+		missing = append(missing, m...)
+		s.pending = append(s.pending, reply...)
+	}
+	if len(missing) != 0 {
+		r.callback.OnMissing(peer, missing)
+	}
+
+	if len(s.pending) == 0 {
+		delete(r.sessions, peer)
+		if r.callback.OnDone != nil {
+			r.callback.OnDone(peer)
+		}
+		return nil
+	}
+	if s.rounds >= r.cfg.MaxRounds {
+		r.misbehaviour(peer, ErrTooManyRounds)
+		return nil
+	}
+	err := r.sendNext(peer, s)
+	if err != nil {
+		delete(r.sessions, peer)
+	}
+	return err
+}
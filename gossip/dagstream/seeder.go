@@ -0,0 +1,71 @@
+package dagstream
+
+import (
+	"errors"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream/basestreamseeder"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+)
+
+var (
+	ErrUnknownRequestType = errors.New("unknown stream request type")
+)
+
+// ForEachEventFn iterates events, starting from the locator, in ascending order of IDs until onEvent returns false.
+// raw is an RLP-encoded event, it may be nil if withRaw is false.
+type ForEachEventFn func(start Locator, withRaw bool, onEvent func(id hash.Event, raw rlp.RawValue) bool)
+
+// ForEachItem adapts ForEachEventFn for basestreamseeder.Callbacks.ForEachItem
+func ForEachItem(forEach ForEachEventFn) func(start basestream.Locator, rType basestream.RequestType, onKey func(key basestream.Locator) bool, onAppended func(items basestream.Payload) bool) basestream.Payload {
+	return func(start basestream.Locator, rType basestream.RequestType, onKey func(key basestream.Locator) bool, onAppended func(items basestream.Payload) bool) basestream.Payload {
+		withRaw := rType == RequestTypeEvents
+		res := &Payload{}
+		forEach(start.(Locator), withRaw, func(id hash.Event, raw rlp.RawValue) bool {
+			if !onKey(EventLocator(id)) {
+				return false
+			}
+			if !withRaw {
+				raw = nil
+			}
+			res.AddEvent(id, raw)
+			return onAppended(res)
+		})
+		return res
+	}
+}
+
+// SeederHandler returns a Handler of StreamRequestMsg, which serves the requests with the seeder.
+// misbehaviour is called for malformed requests.
+func SeederHandler(seeder *basestreamseeder.BaseSeeder, send SendFn, misbehaviour func(peer string, err error)) Handler {
+	return func(peer string, msg Msg) error {
+		var r StreamRequest
+		if err := msg.Decode(&r); err != nil {
+			misbehaviour(peer, err)
+			return nil
+		}
+		if r.Type != RequestTypeIDs && r.Type != RequestTypeEvents {
+			misbehaviour(peer, ErrUnknownRequestType)
+			return nil
+		}
+		err, peerErr := seeder.NotifyRequestReceived(basestreamseeder.Peer{
+			ID: peer,
+			SendChunk: func(response basestream.Response) error {
+				msg, err := Encode(StreamResponseMsg, NewStreamResponse(response))
+				if err != nil {
+					return err
+				}
+				return send(peer, msg)
+			},
+			Misbehaviour: func(err error) {
+				misbehaviour(peer, err)
+			},
+		}, r.Request())
+		if peerErr != nil {
+			misbehaviour(peer, peerErr)
+		}
+		return err
+	}
+}
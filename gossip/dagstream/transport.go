@@ -0,0 +1,130 @@
+package dagstream
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrPeerNotFound = errors.New("peer not found")
+)
+
+// Handler handles a message received from the peer
+type Handler func(peer string, msg Msg) error
+
+// SendFn sends a message to the peer
+type SendFn func(to string, msg Msg) error
+
+// Router dispatches messages to handlers by message codes
+type Router map[uint64]Handler
+
+// Handle is a Handler which calls the handler of the message code
+func (r Router) Handle(peer string, msg Msg) error {
+	h, ok := r[msg.Code]
+	if !ok {
+		return ErrUnknownCode
+	}
+	return h(peer, msg)
+}
+
+type memEnvelope struct {
+	from string
+	msg  Msg
+}
+
+type memPeer struct {
+	handler Handler
+	queue   chan memEnvelope
+	quit    chan struct{}
+}
+
+// MemNetwork is an in-memory transport. Messages to a peer are handled sequentially, in the sending order.
+type MemNetwork struct {
+	// OnError is called if a handler returns an error. Optional.
+	OnError func(peer, from string, err error)
+
+	mu    sync.RWMutex
+	peers map[string]*memPeer
+	wg    sync.WaitGroup
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{
+		peers: make(map[string]*memPeer),
+	}
+}
+
+// Register adds a peer into the network
+func (n *MemNetwork) Register(peer string, handler Handler) {
+	p := &memPeer{
+		handler: handler,
+		queue:   make(chan memEnvelope, 1024),
+		quit:    make(chan struct{}),
+	}
+	n.mu.Lock()
+	if prev, ok := n.peers[peer]; ok {
+		close(prev.quit)
+	}
+	n.peers[peer] = p
+	n.mu.Unlock()
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for {
+			select {
+			case env := <-p.queue:
+				if err := p.handler(env.from, env.msg); err != nil && n.OnError != nil {
+					n.OnError(peer, env.from, err)
+				}
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Unregister removes a peer from the network, not handled messages are dropped
+func (n *MemNetwork) Unregister(peer string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if p, ok := n.peers[peer]; ok {
+		close(p.quit)
+		delete(n.peers, peer)
+	}
+}
+
+// Send delivers a message to the peer. The payload is copied, so the sender may reuse it.
+func (n *MemNetwork) Send(from, to string, msg Msg) error {
+	n.mu.RLock()
+	p, ok := n.peers[to]
+	n.mu.RUnlock()
+	if !ok {
+		return ErrPeerNotFound
+	}
+	msg.Payload = append([]byte(nil), msg.Payload...)
+	select {
+	case p.queue <- memEnvelope{from, msg}:
+		return nil
+	case <-p.quit:
+		return ErrPeerNotFound
+	}
+}
+
+// Sender returns a SendFn on behalf of the peer
+func (n *MemNetwork) Sender(from string) SendFn {
+	return func(to string, msg Msg) error {
+		return n.Send(from, to, msg)
+	}
+}
+
+// Close unregisters all the peers and waits until all the handlers have finished
+func (n *MemNetwork) Close() {
+	n.mu.Lock()
+	for peer, p := range n.peers {
+		close(p.quit)
+		delete(n.peers, peer)
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+}
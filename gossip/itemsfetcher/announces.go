@@ -0,0 +1,105 @@
+package itemsfetcher
+
+import (
+	"container/list"
+	"sync"
+)
+
+// announcesCache is a thread-safe weighted LRU of item announces, keyed by the item ID.
+// It's like wlru.Cache, but it doesn't box the keys and the values.
+type announcesCache[ID comparable] struct {
+	maxSize   int
+	weight    uint
+	maxWeight uint
+	evictList *list.List
+	items     map[ID]*list.Element
+	onEvict   func(id ID)
+
+	mu sync.RWMutex
+}
+
+type announcesEntry[ID comparable] struct {
+	id        ID
+	announces []announceData[ID]
+	weight    uint
+}
+
+func newAnnouncesCache[ID comparable](maxWeight uint, maxSize int, onEvict func(id ID)) *announcesCache[ID] {
+	return &announcesCache[ID]{
+		maxSize:   maxSize,
+		maxWeight: maxWeight,
+		evictList: list.New(),
+		items:     make(map[ID]*list.Element),
+		onEvict:   onEvict,
+	}
+}
+
+// Add sets the announces of the item, evicting the oldest items if the limits are exceeded.
+func (c *announcesCache[ID]) Add(id ID, announces []announceData[ID], weight uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ent, ok := c.items[id]; ok {
+		c.evictList.MoveToFront(ent)
+		existing := ent.Value.(*announcesEntry[ID])
+		c.weight -= existing.weight
+		existing.announces = announces
+		existing.weight = weight
+	} else {
+		c.items[id] = c.evictList.PushFront(&announcesEntry[ID]{id, announces, weight})
+	}
+	c.weight += weight
+	for c.weight > c.maxWeight || c.evictList.Len() > c.maxSize {
+		c.removeElement(c.evictList.Back())
+	}
+}
+
+// Get returns the announces of the item and marks it as recently used
+func (c *announcesCache[ID]) Get(id ID) ([]announceData[ID], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	c.evictList.MoveToFront(ent)
+	return ent.Value.(*announcesEntry[ID]).announces, true
+}
+
+// Remove removes the item, returning if it was contained
+func (c *announcesCache[ID]) Remove(id ID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.items[id]
+	if ok {
+		c.removeElement(ent)
+	}
+	return ok
+}
+
+// Keys returns the items IDs, from oldest to newest
+func (c *announcesCache[ID]) Keys() []ID {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]ID, 0, len(c.items))
+	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+		keys = append(keys, ent.Value.(*announcesEntry[ID]).id)
+	}
+	return keys
+}
+
+// Len returns the number of items
+func (c *announcesCache[ID]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.evictList.Len()
+}
+
+func (c *announcesCache[ID]) removeElement(ent *list.Element) {
+	c.evictList.Remove(ent)
+	e := ent.Value.(*announcesEntry[ID])
+	delete(c.items, e.id)
+	c.weight -= e.weight
+	if c.onEvict != nil {
+		c.onEvict(e.id)
+	}
+}
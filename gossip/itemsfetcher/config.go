@@ -16,6 +16,10 @@ type Config struct {
 
 	MaxParallelRequests int // Maximum number of parallel requests
 
+	MaxPeerInFlight int           // Maximum number of items requested from a single peer at once, 0 means unlimited
+	MinPeerBackoff  time.Duration // Time before a peer is requested again after a not delivered request, 0 disables backoff
+	MaxPeerBackoff  time.Duration // Maximum backoff, which is doubled after each consecutive not delivered request
+
 	// MaxQueuedHashesBatches is the maximum number of announce batches to queue up before
 	// dropping incoming hashes.
 	MaxQueuedBatches int
@@ -30,5 +34,8 @@ func DefaultConfig(scale cachescale.Func) Config {
 		MaxBatch:            scale.I(512),
 		MaxQueuedBatches:    scale.I(32),
 		MaxParallelRequests: 256,
+		MaxPeerInFlight:     scale.I(2048),
+		MinPeerBackoff:      1000 * time.Millisecond,
+		MaxPeerBackoff:      30 * time.Second,
 	}
 }
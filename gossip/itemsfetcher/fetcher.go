@@ -1,12 +1,12 @@
 package itemsfetcher
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"sync"
 	"time"
 
-	"github.com/unicornultrafoundation/go-hashgraph/utils/wlru"
 	"github.com/unicornultrafoundation/go-hashgraph/utils/workers"
 )
 
@@ -20,72 +20,99 @@ var (
 	errTerminated = errors.New("terminated")
 )
 
+// TypedItemsRequesterFn is a callback type for sending a item retrieval request.
+type TypedItemsRequesterFn[ID comparable] func([]ID) error
+
 // ItemsRequesterFn is a callback type for sending a item retrieval request.
-type ItemsRequesterFn func([]interface{}) error
+type ItemsRequesterFn = TypedItemsRequesterFn[interface{}]
 
-type announceData struct {
+type announceData[ID comparable] struct {
 	time       time.Time // Timestamp of the announcement
 	peer       string    // Identifier of the peer originating the notification
-	fetchItems ItemsRequesterFn
+	fetchItems TypedItemsRequesterFn[ID]
 }
 
-type announcesBatch struct {
-	announceData
-	ids []interface{} // Hashes of the items being announced
+type announcesBatch[ID comparable] struct {
+	announceData[ID]
+	ids []ID // Hashes of the items being announced
 }
 
-type fetchingItem struct {
-	announce     announceData
+type fetchingItem[ID comparable] struct {
+	announce     announceData[ID]
 	fetchingTime time.Time
+	// requested is true if the item is requested from announce.peer and isn't timed out yet
+	requested bool
 }
 
-// Fetcher is responsible for accumulating item announcements from various peers
+// TypedFetcher is responsible for accumulating item announcements from various peers
 // and scheduling them for retrieval.
-type Fetcher struct {
+type TypedFetcher[ID comparable] struct {
 	cfg Config
 
 	// Various item channels
-	notifications chan announcesBatch
-	receivedItems chan []interface{}
+	notifications chan announcesBatch[ID]
+	receivedItems chan []ID
 	quit          chan struct{}
 
 	// Callbacks
-	callback Callback
+	callback TypedCallback[ID]
 
 	// Announce states
-	announces *wlru.Cache // Announced items, scheduled for fetching
+	announces *announcesCache[ID] // Announced items, scheduled for fetching
+
+	fetching map[ID]fetchingItem[ID] // Announced items, currently fetching
+	peers    *peersStats             // In-flight requests and delivery history of peers
 
-	fetching map[interface{}]fetchingItem // Announced items, currently fetching
-	wg       sync.WaitGroup
+	wg            sync.WaitGroup
+	terminateOnce sync.Once
+	stopOnce      sync.Once
 
 	parallelTasks *workers.Workers
 }
 
-type Callback struct {
+type TypedCallback[ID comparable] struct {
 	// FilterInterested returns only item which may be requested.
-	OnlyInterested func(ids []interface{}) []interface{}
+	OnlyInterested func(ids []ID) []ID
 	Suspend        func() bool
+	// Banned returns true if announces of the peer should be ignored. Optional.
+	Banned func(peer string) bool
 }
 
+// Fetcher is a TypedFetcher of untyped items.
+// interface{} satisfies the comparable constraint only since Go 1.20, so the module requires it.
+type Fetcher = TypedFetcher[interface{}]
+
+// Callback is a TypedCallback of untyped items.
+type Callback = TypedCallback[interface{}]
+
 // New creates a item fetcher to retrieve items based on hash announcements.
 func New(cfg Config, callback Callback) *Fetcher {
-	f := &Fetcher{
+	return NewTyped[interface{}](cfg, callback)
+}
+
+// NewTyped creates a item fetcher to retrieve items of type ID based on hash announcements.
+func NewTyped[ID comparable](cfg Config, callback TypedCallback[ID]) *TypedFetcher[ID] {
+	f := &TypedFetcher[ID]{
 		cfg:           cfg,
-		notifications: make(chan announcesBatch, cfg.MaxQueuedBatches),
-		receivedItems: make(chan []interface{}, cfg.MaxQueuedBatches),
+		notifications: make(chan announcesBatch[ID], cfg.MaxQueuedBatches),
+		receivedItems: make(chan []ID, cfg.MaxQueuedBatches),
 		quit:          make(chan struct{}),
-		fetching:      make(map[interface{}]fetchingItem),
+		fetching:      make(map[ID]fetchingItem[ID]),
+		peers:         newPeersStats(cfg),
 		callback:      callback,
 	}
-	f.announces, _ = wlru.NewWithEvict(uint(cfg.HashLimit), cfg.HashLimit, func(key interface{}, _ interface{}) {
-		delete(f.fetching, key)
+	f.announces = newAnnouncesCache(uint(cfg.HashLimit), cfg.HashLimit, func(id ID) {
+		if item, ok := f.fetching[id]; ok && item.requested {
+			f.peers.onReleased(item.announce.peer)
+		}
+		delete(f.fetching, id)
 	})
 	f.parallelTasks = workers.New(&f.wg, f.quit, f.cfg.MaxParallelRequests*2)
 	return f
 }
 
 // Start boots up the items fetcher.
-func (f *Fetcher) Start() {
+func (f *TypedFetcher[ID]) Start() {
 	f.parallelTasks.Start(f.cfg.MaxParallelRequests)
 	f.wg.Add(1)
 	go func() {
@@ -96,14 +123,38 @@ func (f *Fetcher) Start() {
 
 // Stop interrupts the fetcher, canceling all the pending operations.
 // Stop waits until all the internal goroutines have finished.
-func (f *Fetcher) Stop() {
-	close(f.quit)
-	f.parallelTasks.Drain()
-	f.wg.Wait()
+func (f *TypedFetcher[ID]) Stop() {
+	f.stopOnce.Do(func() {
+		f.terminate()
+		f.wg.Wait()
+	})
+}
+
+// terminate interrupts the items fetcher, without waiting for its goroutines
+func (f *TypedFetcher[ID]) terminate() {
+	f.terminateOnce.Do(func() {
+		close(f.quit)
+		f.parallelTasks.Drain()
+	})
+}
+
+// StartContext boots up the items fetcher, which gets terminated once ctx is done.
+// Stop is still required to wait for the goroutines.
+func (f *TypedFetcher[ID]) StartContext(ctx context.Context) {
+	f.Start()
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		select {
+		case <-ctx.Done():
+			f.terminate()
+		case <-f.quit:
+		}
+	}()
 }
 
 // Overloaded returns true if too much items are being requested
-func (f *Fetcher) Overloaded() bool {
+func (f *TypedFetcher[ID]) Overloaded() bool {
 	return len(f.receivedItems) > f.cfg.MaxQueuedBatches*3/4 ||
 		len(f.notifications) > f.cfg.MaxQueuedBatches*3/4 ||
 		f.announces.Len() > f.cfg.HashLimit/2
@@ -111,15 +162,24 @@ func (f *Fetcher) Overloaded() bool {
 
 // NotifyAnnounces announces the fetcher of the potential availability of a new item in
 // the network.
-func (f *Fetcher) NotifyAnnounces(peer string, ids []interface{}, time time.Time, fetchItems ItemsRequesterFn) error {
+func (f *TypedFetcher[ID]) NotifyAnnounces(peer string, ids []ID, time time.Time, fetchItems TypedItemsRequesterFn[ID]) error {
+	return f.NotifyAnnouncesContext(context.Background(), peer, ids, time, fetchItems)
+}
+
+// NotifyAnnouncesContext is like NotifyAnnounces, but it stops waiting for a free queue slot once ctx is done.
+// The batches queued before ctx is done aren't canceled.
+func (f *TypedFetcher[ID]) NotifyAnnouncesContext(ctx context.Context, peer string, ids []ID, time time.Time, fetchItems TypedItemsRequesterFn[ID]) error {
+	if f.banned(peer) {
+		return nil
+	}
 	// divide big batch into smaller ones
 	for start := 0; start < len(ids); start += f.cfg.MaxBatch {
 		end := len(ids)
 		if end > start+f.cfg.MaxBatch {
 			end = start + f.cfg.MaxBatch
 		}
-		op := announcesBatch{
-			announceData: announceData{
+		op := announcesBatch[ID]{
+			announceData: announceData[ID]{
 				time:       time,
 				peer:       peer,
 				fetchItems: fetchItems,
@@ -129,6 +189,8 @@ func (f *Fetcher) NotifyAnnounces(peer string, ids []interface{}, time time.Time
 		select {
 		case <-f.quit:
 			return errTerminated
+		case <-ctx.Done():
+			return ctx.Err()
 		case f.notifications <- op:
 			continue
 		}
@@ -136,7 +198,12 @@ func (f *Fetcher) NotifyAnnounces(peer string, ids []interface{}, time time.Time
 	return nil
 }
 
-func (f *Fetcher) NotifyReceived(ids []interface{}) error {
+func (f *TypedFetcher[ID]) NotifyReceived(ids []ID) error {
+	return f.NotifyReceivedContext(context.Background(), ids)
+}
+
+// NotifyReceivedContext is like NotifyReceived, but it stops waiting for a free queue slot once ctx is done.
+func (f *TypedFetcher[ID]) NotifyReceivedContext(ctx context.Context, ids []ID) error {
 	// divide big batch into smaller ones
 	for start := 0; start < len(ids); start += f.cfg.MaxBatch {
 		end := len(ids)
@@ -146,6 +213,8 @@ func (f *Fetcher) NotifyReceived(ids []interface{}) error {
 		select {
 		case <-f.quit:
 			return errTerminated
+		case <-ctx.Done():
+			return ctx.Err()
 		case f.receivedItems <- ids[start:end]:
 			continue
 		}
@@ -153,15 +222,15 @@ func (f *Fetcher) NotifyReceived(ids []interface{}) error {
 	return nil
 }
 
-func (f *Fetcher) getAnnounces(id interface{}) []announceData {
+func (f *TypedFetcher[ID]) getAnnounces(id ID) []announceData[ID] {
 	announces, ok := f.announces.Get(id)
 	if !ok {
-		return []announceData{}
+		return []announceData[ID]{}
 	}
-	return announces.([]announceData)
+	return announces
 }
 
-func (f *Fetcher) processNotification(notification announcesBatch, fetchTimer *time.Timer) {
+func (f *TypedFetcher[ID]) processNotification(notification announcesBatch[ID], fetchTimer *time.Timer) {
 	first := len(f.fetching) == 0
 
 	// filter only not known
@@ -172,7 +241,7 @@ func (f *Fetcher) processNotification(notification announcesBatch, fetchTimer *t
 
 	noFetching := f.callback.Suspend()
 
-	toFetch := make([]interface{}, 0, len(notification.ids))
+	toFetch := make([]ID, 0, len(notification.ids))
 	now := time.Now()
 	for _, id := range notification.ids {
 		// add new announcement. other peers may already have announced it, so it's an array
@@ -182,11 +251,21 @@ func (f *Fetcher) processNotification(notification announcesBatch, fetchTimer *t
 		// if it wasn't announced before, then schedule for fetching this time
 		if !noFetching {
 			if _, ok := f.fetching[id]; !ok {
-				f.fetching[id] = fetchingItem{
-					announce:     notification.announceData,
-					fetchingTime: now,
+				if f.peers.available(notification.peer, now) {
+					f.fetching[id] = fetchingItem[ID]{
+						announce:     notification.announceData,
+						fetchingTime: now,
+						requested:    true,
+					}
+					f.peers.onRequested(notification.peer, now)
+					toFetch = append(toFetch, id)
+				} else {
+					// peer is busy or backed off, fetch the item on the next timer tick, possibly from another peer
+					f.fetching[id] = fetchingItem[ID]{
+						announce:     notification.announceData,
+						fetchingTime: now.Add(-f.cfg.ArriveTimeout),
+					}
 				}
-				toFetch = append(toFetch, id)
 			}
 		}
 	}
@@ -205,7 +284,7 @@ func (f *Fetcher) processNotification(notification announcesBatch, fetchTimer *t
 }
 
 // Loop is the main fetcher loop, checking and processing various notifications
-func (f *Fetcher) loop() {
+func (f *TypedFetcher[ID]) loop() {
 	// Iterate the item fetching until a quit is requested
 	fetchTimer := time.NewTimer(0)
 	defer fetchTimer.Stop()
@@ -221,18 +300,24 @@ func (f *Fetcher) loop() {
 			f.processNotification(notification, fetchTimer)
 
 		case ids := <-f.receivedItems:
+			now := time.Now()
 			for _, id := range ids {
+				// the item might arrive from another peer, but it's a good enough approximation of the delivery history
+				if item, ok := f.fetching[id]; ok && item.requested {
+					f.peers.onDelivered(item.announce.peer, now)
+				}
 				f.forgetHash(id)
 			}
 
 		case <-fetchTimer.C:
 			now := time.Now()
 			// At least one item's timer ran out, check for needing retrieval
-			request := make(map[string][]interface{})
-			requestFns := make(map[string]ItemsRequesterFn)
+			request := make(map[string][]ID)
+			requestFns := make(map[string]TypedItemsRequesterFn[ID])
+			timedOut := make(map[string]bool)
 
 			// Find not arrived items
-			all := append([]interface{}(nil), f.announces.Keys()...)
+			all := f.announces.Keys()
 			notArrived := f.callback.OnlyInterested(all)
 
 			for _, id := range notArrived {
@@ -246,22 +331,38 @@ func (f *Fetcher) loop() {
 				if time.Since(oldest.time) > f.cfg.ForgetTimeout {
 					// Forget too old announces
 					f.forgetHash(id)
-				} else if time.Since(f.fetching[id].fetchingTime) > f.cfg.ArriveTimeout-f.cfg.GatherSlack {
-					// The item still didn't arrive, queue for fetching from a random peer
-					announce := announces[rand.Intn(len(announces))] // nolint:gosec
+				} else if item := f.fetching[id]; time.Since(item.fetchingTime) > f.cfg.ArriveTimeout-f.cfg.GatherSlack {
+					if item.requested {
+						// the peer didn't deliver the item in time, back it off once per tick
+						peer := item.announce.peer
+						if !timedOut[peer] {
+							timedOut[peer] = true
+							f.peers.onTimeout(peer, now)
+						}
+						f.peers.onReleased(peer)
+						item.requested = false
+						f.fetching[id] = item
+					}
+					// The item still didn't arrive, queue for fetching from the best available peer
+					announce, ok := f.pickAnnounce(announces, now)
+					if !ok {
+						continue
+					}
 					request[announce.peer] = append(request[announce.peer], id)
 					requestFns[announce.peer] = announce.fetchItems
-					f.fetching[id] = fetchingItem{
+					f.fetching[id] = fetchingItem[ID]{
 						announce:     announce,
 						fetchingTime: now,
+						requested:    true,
 					}
+					f.peers.onRequested(announce.peer, now)
 				}
 			}
 
 			// Forget arrived items.
 			// It's possible to get here only if item arrived out-of-fetcher, via another channel.
 			// Also may be possible after a change of an epoch.
-			notArrivedMap := make(map[interface{}]bool, len(notArrived))
+			notArrivedMap := make(map[ID]bool, len(notArrived))
 			for _, id := range notArrived {
 				notArrivedMap[id] = true
 			}
@@ -271,6 +372,8 @@ func (f *Fetcher) loop() {
 				}
 			}
 
+			f.peers.prune(now)
+
 			// Send out all item requests
 			for peer, req := range request {
 				// Create a closure of the fetch and schedule in on a new thread
@@ -285,6 +388,30 @@ func (f *Fetcher) loop() {
 	}
 }
 
+func (f *TypedFetcher[ID]) banned(peer string) bool {
+	return f.callback.Banned != nil && f.callback.Banned(peer)
+}
+
+// pickAnnounce picks an announce from a peer with the best delivery history.
+// Banned, backed off and busy peers are skipped. Peers with equal history are picked randomly.
+func (f *TypedFetcher[ID]) pickAnnounce(announces []announceData[ID], now time.Time) (announceData[ID], bool) {
+	best := -1
+	bestScore := 0.0
+	for _, i := range rand.Perm(len(announces)) { // nolint:gosec
+		peer := announces[i].peer
+		if f.banned(peer) || !f.peers.available(peer, now) {
+			continue
+		}
+		if score := f.peers.score(peer); best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return announceData[ID]{}, false
+	}
+	return announces[best], true
+}
+
 func maxDuration(a, b time.Duration) time.Duration {
 	if a > b {
 		return a
@@ -293,7 +420,7 @@ func maxDuration(a, b time.Duration) time.Duration {
 }
 
 // rescheduleFetch resets the specified fetch timer to the next announce timeout.
-func (f *Fetcher) rescheduleFetch(fetch *time.Timer) {
+func (f *TypedFetcher[ID]) rescheduleFetch(fetch *time.Timer) {
 	// Short circuit if no items are announced
 	if f.announces.Len() == 0 {
 		return
@@ -318,6 +445,6 @@ func (f *Fetcher) rescheduleFetch(fetch *time.Timer) {
 
 // forgetHash removes all traces of a item announcement from the fetcher's
 // internal state.
-func (f *Fetcher) forgetHash(id interface{}) {
+func (f *TypedFetcher[ID]) forgetHash(id ID) {
 	f.announces.Remove(id) // f.fetching is deleted inside the evict callback
 }
@@ -0,0 +1,239 @@
+package itemsfetcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
+)
+
+func TestPeersStats(t *testing.T) {
+	cfg := DefaultConfig(cachescale.Identity)
+	cfg.MaxPeerInFlight = 2
+	cfg.MinPeerBackoff = time.Second
+	cfg.MaxPeerBackoff = 3 * time.Second
+	p := newPeersStats(cfg)
+	now := time.Unix(1000, 0)
+
+	// in-flight budget
+	require.True(t, p.available("a", now))
+	p.onRequested("a", now)
+	p.onRequested("a", now)
+	require.False(t, p.available("a", now))
+	p.onReleased("a")
+	require.True(t, p.available("a", now))
+
+	// exponential backoff
+	p.onTimeout("a", now)
+	require.False(t, p.available("a", now.Add(time.Second-1)))
+	require.True(t, p.available("a", now.Add(time.Second)))
+	p.onTimeout("a", now)
+	require.False(t, p.available("a", now.Add(2*time.Second-1)))
+	require.True(t, p.available("a", now.Add(2*time.Second)))
+	p.onTimeout("a", now)
+	p.onTimeout("a", now)
+	require.False(t, p.available("a", now.Add(3*time.Second-1)))
+	require.True(t, p.available("a", now.Add(3*time.Second)))
+
+	// delivery resets the backoff and improves the score
+	p.onRequested("b", now)
+	p.onDelivered("b", now)
+	p.onReleased("b")
+	p.onDelivered("a", now)
+	require.True(t, p.available("a", now))
+	p.onRequested("a", now)
+	require.Greater(t, p.score("b"), p.score("unknown"))
+	require.Greater(t, p.score("unknown"), p.score("a"))
+
+	// idle peers are forgotten
+	p.onReleased("a")
+	p.onReleased("a")
+	p.prune(now.Add(cfg.ForgetTimeout + 1))
+	require.Empty(t, p.peers)
+}
+
+func TestFetcherPeerBudgetAndBackoff(t *testing.T) {
+	cfg := DefaultConfig(cachescale.Identity)
+	cfg.ArriveTimeout = 50 * time.Millisecond
+	cfg.GatherSlack = 5 * time.Millisecond
+	cfg.MaxPeerInFlight = 2
+	cfg.MinPeerBackoff = time.Minute
+	cfg.MaxPeerBackoff = time.Minute
+
+	var mu sync.Mutex
+	received := map[interface{}]bool{}
+	requested := map[string]int{}
+
+	var f *Fetcher
+	f = New(cfg, Callback{
+		OnlyInterested: func(ids []interface{}) []interface{} {
+			mu.Lock()
+			defer mu.Unlock()
+			res := make([]interface{}, 0, len(ids))
+			for _, id := range ids {
+				if !received[id] {
+					res = append(res, id)
+				}
+			}
+			return res
+		},
+		Suspend: func() bool {
+			return false
+		},
+	})
+	f.Start()
+	defer f.Stop()
+
+	fetchFrom := func(peer string, deliver bool) ItemsRequesterFn {
+		return func(ids []interface{}) error {
+			mu.Lock()
+			requested[peer] += len(ids)
+			if deliver {
+				for _, id := range ids {
+					received[id] = true
+				}
+			}
+			mu.Unlock()
+			if deliver {
+				return f.NotifyReceived(ids)
+			}
+			return nil
+		}
+	}
+
+	ids := []interface{}{1, 2, 3, 4, 5}
+	// slow peer gets only a budget of items and gets backed off after a timeout
+	require.NoError(t, f.NotifyAnnounces("slow", ids, time.Now(), fetchFrom("slow", false)))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return requested["slow"] == cfg.MaxPeerInFlight
+	}, time.Second, time.Millisecond)
+	time.Sleep(2 * cfg.ArriveTimeout)
+
+	// the items are fetched from a good peer, the backed off peer isn't requested anymore
+	require.NoError(t, f.NotifyAnnounces("good", ids, time.Now(), fetchFrom("good", true)))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == len(ids)
+	}, 5*time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, cfg.MaxPeerInFlight, requested["slow"])
+	require.Equal(t, len(ids), requested["good"])
+}
+
+func TestTypedFetcher(t *testing.T) {
+	cfg := DefaultConfig(cachescale.Identity)
+	cfg.ArriveTimeout = 50 * time.Millisecond
+	cfg.GatherSlack = 5 * time.Millisecond
+
+	var mu sync.Mutex
+	received := hash.EventsSet{}
+
+	f := NewTyped[hash.Event](cfg, TypedCallback[hash.Event]{
+		OnlyInterested: func(ids []hash.Event) []hash.Event {
+			mu.Lock()
+			defer mu.Unlock()
+			res := make([]hash.Event, 0, len(ids))
+			for _, id := range ids {
+				if !received.Contains(id) {
+					res = append(res, id)
+				}
+			}
+			return res
+		},
+		Suspend: func() bool {
+			return false
+		},
+	})
+	f.Start()
+	defer f.Stop()
+
+	ids := hash.FakeEvents(10)
+	var lost bool
+	fetchItems := func(req []hash.Event) error {
+		delivered := make([]hash.Event, 0, len(req))
+		mu.Lock()
+		for _, id := range req {
+			// the first item gets lost once, so it's requested again
+			if id == ids[0] && !lost {
+				lost = true
+				continue
+			}
+			received.Add(id)
+			delivered = append(delivered, id)
+		}
+		mu.Unlock()
+		return f.NotifyReceived(delivered)
+	}
+	require.NoError(t, f.NotifyAnnounces("peer", ids, time.Now(), fetchItems))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == len(ids)
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestFetcherNotifyContext(t *testing.T) {
+	cfg := DefaultConfig(cachescale.Identity)
+	cfg.MaxQueuedBatches = 1
+	cfg.MaxBatch = 1
+
+	// the fetcher isn't started, so the queues get full after the first batch
+	f := NewTyped[hash.Event](cfg, TypedCallback[hash.Event]{
+		OnlyInterested: func(ids []hash.Event) []hash.Event {
+			return ids
+		},
+		Suspend: func() bool {
+			return false
+		},
+	})
+	ids := hash.FakeEvents(2)
+	fetchItems := func([]hash.Event) error {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, f.NotifyAnnouncesContext(ctx, "peer", ids, time.Now(), fetchItems))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, f.NotifyReceivedContext(ctx, ids))
+}
+
+func TestAnnouncesCache(t *testing.T) {
+	var evicted []int
+	c := newAnnouncesCache[int](3, 2, func(id int) {
+		evicted = append(evicted, id)
+	})
+	ann := []announceData[int]{{peer: "a"}}
+
+	c.Add(1, ann, 1)
+	c.Add(2, ann, 1)
+	_, ok := c.Get(1)
+	require.True(t, ok)
+	// the least recently used item is evicted by the size limit
+	c.Add(3, ann, 1)
+	require.Equal(t, []int{2}, evicted)
+	require.Equal(t, []int{1, 3}, c.Keys())
+
+	// the weight limit is respected on updates
+	c.Add(3, append(ann, ann...), 3)
+	require.Equal(t, []int{2, 1}, evicted)
+	got, ok := c.Get(3)
+	require.True(t, ok)
+	require.Len(t, got, 2)
+
+	require.True(t, c.Remove(3))
+	require.False(t, c.Remove(3))
+	require.Equal(t, 0, c.Len())
+	require.Equal(t, []int{2, 1, 3}, evicted)
+}
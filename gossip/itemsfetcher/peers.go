@@ -0,0 +1,114 @@
+package itemsfetcher
+
+import (
+	"time"
+)
+
+// historyLimit is a number of requested items, after which the delivery history is halved, so older history weighs less
+const historyLimit = 1024
+
+type peerStats struct {
+	inFlight     int
+	failures     int
+	backoffUntil time.Time
+	lastActive   time.Time
+	// delivery history
+	requested float64
+	delivered float64
+}
+
+// peersStats tracks in-flight requests and delivery history of peers
+type peersStats struct {
+	cfg   Config
+	peers map[string]*peerStats
+}
+
+func newPeersStats(cfg Config) *peersStats {
+	return &peersStats{
+		cfg:   cfg,
+		peers: make(map[string]*peerStats),
+	}
+}
+
+func (p *peersStats) get(peer string) *peerStats {
+	s := p.peers[peer]
+	if s == nil {
+		s = &peerStats{}
+		p.peers[peer] = s
+	}
+	return s
+}
+
+// available returns true if the peer isn't backed off and has a request budget
+func (p *peersStats) available(peer string, now time.Time) bool {
+	s := p.peers[peer]
+	if s == nil {
+		return true
+	}
+	if now.Before(s.backoffUntil) {
+		return false
+	}
+	return p.cfg.MaxPeerInFlight == 0 || s.inFlight < p.cfg.MaxPeerInFlight
+}
+
+// score is an estimation of the delivery probability
+func (p *peersStats) score(peer string) float64 {
+	s := p.peers[peer]
+	if s == nil {
+		return 0.5
+	}
+	return (s.delivered + 1) / (s.requested + 2)
+}
+
+func (p *peersStats) onRequested(peer string, now time.Time) {
+	s := p.get(peer)
+	s.inFlight++
+	s.requested++
+	s.lastActive = now
+	if s.requested > historyLimit {
+		s.requested /= 2
+		s.delivered /= 2
+	}
+}
+
+func (p *peersStats) onDelivered(peer string, now time.Time) {
+	s := p.get(peer)
+	s.delivered++
+	s.failures = 0
+	s.backoffUntil = time.Time{}
+	s.lastActive = now
+}
+
+// onTimeout backs off the peer exponentially
+func (p *peersStats) onTimeout(peer string, now time.Time) {
+	s := p.get(peer)
+	s.failures++
+	s.lastActive = now
+	if p.cfg.MinPeerBackoff == 0 {
+		return
+	}
+	backoff := p.cfg.MinPeerBackoff
+	for i := 1; i < s.failures && backoff < p.cfg.MaxPeerBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.cfg.MaxPeerBackoff {
+		backoff = p.cfg.MaxPeerBackoff
+	}
+	s.backoffUntil = now.Add(backoff)
+}
+
+// onReleased is called when a requested item is either delivered, timed out or forgotten
+func (p *peersStats) onReleased(peer string) {
+	if s := p.peers[peer]; s != nil && s.inFlight > 0 {
+		s.inFlight--
+	}
+}
+
+// prune forgets peers without requests, which were inactive for ForgetTimeout
+func (p *peersStats) prune(now time.Time) {
+	for peer, s := range p.peers {
+		if s.inFlight == 0 && now.After(s.backoffUntil) && now.Sub(s.lastActive) > p.cfg.ForgetTimeout {
+			delete(p.peers, peer)
+		}
+	}
+}
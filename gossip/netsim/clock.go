@@ -0,0 +1,74 @@
+package netsim
+
+import (
+	"container/heap"
+	"time"
+)
+
+type task struct {
+	at  time.Duration
+	seq uint64
+	fn  func()
+}
+
+type tasksHeap []*task
+
+func (h tasksHeap) Len() int { return len(h) }
+
+func (h tasksHeap) Less(i, j int) bool {
+	if h[i].at != h[j].at {
+		return h[i].at < h[j].at
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h tasksHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *tasksHeap) Push(x interface{}) { *h = append(*h, x.(*task)) }
+
+func (h *tasksHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// Clock is a virtual clock with a tasks scheduler.
+// Tasks are executed one by one in order of their time, tasks with the same time are executed in order of scheduling.
+type Clock struct {
+	now   time.Duration
+	seq   uint64
+	tasks tasksHeap
+}
+
+// Now returns the virtual time passed since the simulation start
+func (c *Clock) Now() time.Duration {
+	return c.now
+}
+
+// Schedule adds a task to execute after the given delay
+func (c *Clock) Schedule(after time.Duration, fn func()) {
+	if after < 0 {
+		after = 0
+	}
+	c.seq++
+	heap.Push(&c.tasks, &task{
+		at:  c.now + after,
+		seq: c.seq,
+		fn:  fn,
+	})
+}
+
+// RunUntil executes the tasks scheduled before the given time, and moves the clock to the time
+func (c *Clock) RunUntil(until time.Duration) {
+	for len(c.tasks) != 0 && c.tasks[0].at <= until {
+		t := heap.Pop(&c.tasks).(*task)
+		c.now = t.at
+		t.fn()
+	}
+	if c.now < until {
+		c.now = until
+	}
+}
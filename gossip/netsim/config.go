@@ -0,0 +1,96 @@
+package netsim
+
+import (
+	"time"
+
+	"github.com/unicornultrafoundation/go-hashgraph/consensus"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream/basestreamseeder"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagprocessor"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagstream"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
+)
+
+// Behaviour of a simulated node
+type Behaviour int
+
+const (
+	// Honest node follows the protocol
+	Honest Behaviour = iota
+	// Forking node sometimes creates two events with the same self-parent, and sends them to different peers
+	Forking
+	// Withholding node sends its events only to a single random peer, and doesn't respond to events requests
+	Withholding
+)
+
+type Config struct {
+	// Seed of the simulation, simulations with equal configs and seeds are identical
+	Seed int64
+
+	// Weights of validators, a node per each validator
+	Weights []pos.Weight
+	// Behaviours of the nodes, Honest by default
+	Behaviours []Behaviour
+
+	// EmitInterval is an interval of events emitting by every node
+	EmitInterval time.Duration
+	// MaxParents is a maximum number of event's parents, including the self-parent
+	MaxParents int
+	// ForkProbability is a probability that a Forking node creates a fork
+	ForkProbability float64
+
+	// Latency is a minimum messages delivery time
+	Latency time.Duration
+	// Jitter is a maximum random addition to Latency
+	Jitter time.Duration
+	// PacketLoss is a probability of a message loss
+	PacketLoss float64
+	// RequestTimeout is a time after which not arrived missing parents are requested again
+	RequestTimeout time.Duration
+
+	Processor dagprocessor.Config
+	Consensus consensus.Config
+
+	// Seeder serves the events stream. MaxPeerResponsesRate should be zero, as the rate limits are measured in real time.
+	Seeder basestreamseeder.Config
+	// Leecher downloads the events stream when a node is connected.
+	// RecheckInterval and SessionTimeout are measured in the virtual time.
+	Leecher dagstream.LeecherConfig
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Weights:         []pos.Weight{1, 1, 1, 1},
+		EmitInterval:    200 * time.Millisecond,
+		MaxParents:      3,
+		ForkProbability: 0.2,
+		Latency:         50 * time.Millisecond,
+		Jitter:          50 * time.Millisecond,
+		RequestTimeout:  time.Second,
+		Processor:       dagprocessor.DefaultConfig(cachescale.Identity),
+		Consensus:       consensus.LiteConfig(),
+		Seeder: basestreamseeder.Config{
+			SenderThreads:           1,
+			MaxSenderTasks:          128,
+			MaxPendingResponsesSize: 16 * 1024 * 1024,
+			MaxResponsePayloadNum:   500,
+			MaxResponsePayloadSize:  1024 * 1024,
+			MaxResponseChunks:       12,
+		},
+		Leecher: dagstream.LeecherConfig{
+			RecheckInterval: 200 * time.Millisecond,
+			SessionTimeout:  5 * time.Second,
+			Type:            dagstream.RequestTypeEvents,
+			MaxPayloadNum:   100,
+			MaxPayloadSize:  512 * 1024,
+			MaxChunks:       4,
+		},
+	}
+}
+
+func (c Config) behaviour(i int) Behaviour {
+	if i < len(c.Behaviours) {
+		return c.Behaviours[i]
+	}
+	return Honest
+}
@@ -0,0 +1,101 @@
+package netsim
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagstream"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/itemsfetcher"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
+)
+
+// fetcherConfig returns a config of the node's items fetcher.
+// The fetcher's timers run in the real time, so they are disabled. Not arrived events are requested
+// again by the simulator after Config.RequestTimeout.
+func fetcherConfig() itemsfetcher.Config {
+	cfg := itemsfetcher.DefaultConfig(cachescale.Identity)
+	cfg.ForgetTimeout = time.Hour
+	cfg.ArriveTimeout = time.Hour
+	cfg.MaxPeerInFlight = 0
+	cfg.MinPeerBackoff = 0
+	return cfg
+}
+
+func (n *Node) newFetcher() *itemsfetcher.TypedFetcher[hash.Event] {
+	return itemsfetcher.NewTyped(fetcherConfig(), itemsfetcher.TypedCallback[hash.Event]{
+		OnlyInterested: func(ids []hash.Event) []hash.Event {
+			interested := make([]hash.Event, 0, len(ids))
+			for _, id := range ids {
+				if id == n.sentinel || (!n.events.HasEvent(id) && !n.processor.IsBuffered(id)) {
+					interested = append(interested, id)
+				}
+			}
+			return interested
+		},
+		Suspend: func() bool {
+			return false
+		},
+	})
+}
+
+// nextSentinel returns a unique ID, which never belongs to an event
+func (n *Node) nextSentinel() hash.Event {
+	n.sentinels++
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n.sentinels)
+	// epoch of the ID is zero
+	return hash.BytesToEvent(b[:])
+}
+
+// fetch passes the announced events to the fetcher, and requests the events which the fetcher decides to request.
+// The fetcher requests events asynchronously. To keep the simulation deterministic, every announces batch
+// is extended with a unique sentinel ID, which is always interesting, so every batch results in exactly one
+// request, which is awaited.
+func (n *Node) fetch(peer string, ids hash.Events) {
+	to, ok := peerID(peer)
+	if !ok {
+		return
+	}
+	maxBatch := fetcherConfig().MaxBatch - 1
+	for start := 0; start < len(ids); start += maxBatch {
+		end := len(ids)
+		if end > start+maxBatch {
+			end = start + maxBatch
+		}
+		n.sentinel = n.nextSentinel()
+		batch := append(ids[start:end:end], n.sentinel)
+
+		requested := make(chan []hash.Event, 1)
+		err := n.fetcher.NotifyAnnounces(peer, batch, time.Now(), func(ids []hash.Event) error {
+			requested <- ids
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		toRequest := make(hash.Events, 0, len(batch))
+		for _, id := range <-requested {
+			if id != n.sentinel {
+				toRequest = append(toRequest, id)
+			}
+		}
+		_ = n.fetcher.NotifyReceived([]hash.Event{n.sentinel})
+		if len(toRequest) != 0 {
+			n.send(to, dagstream.RequestEventsMsg, &dagstream.RequestEvents{IDs: toRequest})
+		}
+	}
+}
+
+// announce sends IDs of the events to all the peers, except the sender of the events
+func (n *Node) announce(from idx.ValidatorID, ids hash.Events) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, peer := range n.sim.peersOf(n.ID) {
+		if peer != from {
+			n.send(peer, dagstream.AnnounceHashesMsg, &dagstream.AnnounceHashes{IDs: ids})
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package netsim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+)
+
+func runSim(t *testing.T, cfg Config, d time.Duration) *Simulator {
+	t.Helper()
+	s := New(cfg)
+	t.Cleanup(s.Close)
+	s.Run(d)
+	require.NoError(t, s.CheckBlocks())
+	return s
+}
+
+func TestSimulatorHonest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Weights = []pos.Weight{1, 2, 3, 4}
+	s := runSim(t, cfg, 30*time.Second)
+	require.Greater(t, s.MinBlocks(), 10)
+	require.Zero(t, s.Stats().Lost)
+}
+
+func TestSimulatorPacketLoss(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Weights = []pos.Weight{1, 1, 1, 1, 1}
+	cfg.PacketLoss = 0.2
+	s := runSim(t, cfg, 30*time.Second)
+	require.Greater(t, s.MinBlocks(), 5)
+	require.NotZero(t, s.Stats().Lost)
+}
+
+func TestSimulatorPartition(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Weights = []pos.Weight{1, 1, 1, 1}
+	s := runSim(t, cfg, 10*time.Second)
+	before := s.MinBlocks()
+	require.Greater(t, before, 0)
+
+	// no group has a quorum, consensus stalls
+	s.Partition([]idx.ValidatorID{1, 2}, []idx.ValidatorID{3, 4})
+	s.Run(2 * time.Second)
+	stalled := s.MinBlocks()
+	s.Run(10 * time.Second)
+	require.Equal(t, stalled, s.MinBlocks())
+	require.NoError(t, s.CheckBlocks())
+
+	// the network catches up after healing
+	s.Heal()
+	s.Run(20 * time.Second)
+	require.NoError(t, s.CheckBlocks())
+	require.Greater(t, s.MinBlocks(), stalled)
+}
+
+func TestSimulatorForking(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Weights = []pos.Weight{1, 1, 1, 1, 1}
+	cfg.Behaviours = []Behaviour{Honest, Honest, Honest, Honest, Forking}
+	cfg.ForkProbability = 0.5
+	s := runSim(t, cfg, 30*time.Second)
+	require.Greater(t, s.MinBlocks(), 5)
+
+	// the cheater is detected
+	cheaterFound := false
+	for _, b := range s.Node(1).Blocks() {
+		for _, cheater := range b.Cheaters {
+			require.Equal(t, idx.ValidatorID(5), cheater)
+			cheaterFound = true
+		}
+	}
+	require.True(t, cheaterFound)
+}
+
+func TestSimulatorWithholding(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Weights = []pos.Weight{1, 1, 1, 1, 1}
+	cfg.Behaviours = []Behaviour{Honest, Honest, Honest, Honest, Withholding}
+	s := runSim(t, cfg, 30*time.Second)
+	require.Greater(t, s.MinBlocks(), 5)
+}
+
+func TestSimulatorDeterminism(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Seed = 7
+	cfg.Weights = []pos.Weight{1, 2, 3, 4, 5}
+	cfg.Behaviours = []Behaviour{Honest, Honest, Honest, Honest, Forking}
+	cfg.PacketLoss = 0.1
+
+	a := runSim(t, cfg, 15*time.Second)
+	b := runSim(t, cfg, 15*time.Second)
+	require.Equal(t, a.Stats(), b.Stats())
+	for i, n := range a.Nodes() {
+		require.Equal(t, n.Blocks(), b.Nodes()[i].Blocks())
+		require.Equal(t, n.Events(), b.Nodes()[i].Events())
+	}
+
+	cfg.Seed = 8
+	c := runSim(t, cfg, 15*time.Second)
+	require.NotEqual(t, a.Stats(), c.Stats())
+}
+
+func TestSimulatorLateJoiner(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Weights = []pos.Weight{1, 1, 1, 1, 1}
+	s := New(cfg)
+	t.Cleanup(s.Close)
+
+	// the network makes progress without the joiner
+	s.Disconnect(5)
+	s.Run(20 * time.Second)
+	joiner := s.Node(5)
+	require.Zero(t, joiner.Events())
+	require.Empty(t, joiner.Blocks())
+	events := s.Node(1).Events()
+	blocks := len(s.Node(1).Blocks())
+	require.Greater(t, blocks, 5)
+
+	// the joiner doesn't handle the gossip until it's synced through the stream
+	s.Connect(5)
+	require.True(t, joiner.Syncing())
+	s.Run(5 * time.Second)
+	require.False(t, joiner.Syncing())
+	require.GreaterOrEqual(t, joiner.Streamed(), events)
+	require.GreaterOrEqual(t, len(joiner.Blocks()), blocks)
+	require.NoError(t, s.CheckBlocks())
+
+	// the joiner keeps up with the network after the sync
+	s.Run(10 * time.Second)
+	require.NoError(t, s.CheckBlocks())
+	require.Greater(t, s.MinBlocks(), blocks)
+	require.Greater(t, joiner.Events(), joiner.Streamed())
+}
@@ -0,0 +1,494 @@
+package netsim
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/consensus"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/basiccheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/parentscheck"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream/basestreamseeder"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagprocessor"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagstream"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/itemsfetcher"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/types"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/adapters"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/datasemaphore"
+	"github.com/unicornultrafoundation/go-hashgraph/vecfc"
+)
+
+var (
+	ErrMalformedEvent = errors.New("malformed event")
+)
+
+// Block is a block decided by a node
+type Block struct {
+	Frame    idx.Frame
+	Atropos  hash.Event
+	Cheaters types.Cheaters
+}
+
+func (b Block) String() string {
+	return fmt.Sprintf("{frame=%d, atropos=%s, cheaters=%v}", b.Frame, b.Atropos.String(), b.Cheaters)
+}
+
+// eventsDB is an events storage of a node, it implements consensus.EventSource
+type eventsDB map[hash.Event]dag.Event
+
+func (db eventsDB) HasEvent(id hash.Event) bool {
+	_, ok := db[id]
+	return ok
+}
+
+func (db eventsDB) GetEvent(id hash.Event) dag.Event {
+	return db[id]
+}
+
+// Node is a simulated node
+type Node struct {
+	ID        idx.ValidatorID
+	Behaviour Behaviour
+
+	sim *Simulator
+
+	consensus *consensus.Indexed
+	store     *consensus.Store
+	processor *dagprocessor.Processor
+	fetcher   *itemsfetcher.TypedFetcher[hash.Event]
+	seeder    *basestreamseeder.BaseSeeder
+	leecher   *dagstream.Leecher
+
+	serveStreamRequest dagstream.Handler
+	// streamChunks and streamFailed are the results of the seeder
+	streamChunks chan dagstream.Msg
+	streamFailed chan error
+
+	// sentinel is the sentinel ID of the announces batch which is being fetched
+	sentinel  hash.Event
+	sentinels uint64
+
+	// syncing is true while the events stream is being downloaded
+	syncing      bool
+	lastStreamed time.Duration
+	streamed     int
+
+	events eventsDB
+	// forks are the created events which aren't connected by the node itself
+	forks eventsDB
+	// latest are the latest known events by creators
+	latest         map[idx.ValidatorID]dag.Event
+	last           dag.Event
+	highestLamport idx.Lamport
+
+	blocks   []Block
+	released map[string]int
+}
+
+func peerName(id idx.ValidatorID) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func peerID(name string) (idx.ValidatorID, bool) {
+	id, err := strconv.ParseUint(name, 10, 32)
+	return idx.ValidatorID(id), err == nil
+}
+
+func newNode(sim *Simulator, id idx.ValidatorID, behaviour Behaviour, validators *pos.Validators) *Node {
+	n := &Node{
+		ID:        id,
+		Behaviour: behaviour,
+		sim:       sim,
+		events:    make(eventsDB),
+		forks:     make(eventsDB),
+		latest:    make(map[idx.ValidatorID]dag.Event),
+		released:  make(map[string]int),
+	}
+
+	crit := func(err error) {
+		panic(fmt.Errorf("node %d: %w", id, err))
+	}
+	openEDB := func(epoch idx.Epoch) u2udb.Store {
+		return memorydb.New()
+	}
+	n.store = consensus.NewStore(memorydb.New(), openEDB, crit, consensus.LiteStoreConfig())
+	err := n.store.ApplyGenesis(&consensus.Genesis{
+		Validators: validators,
+		Epoch:      consensus.FirstEpoch,
+	})
+	if err != nil {
+		crit(err)
+	}
+	dagIndexer := &adapters.VectorToDagIndexer{Index: vecfc.NewIndex(crit, vecfc.LiteConfig())}
+	n.consensus = consensus.NewIndexed(n.store, n.events, dagIndexer, crit, sim.cfg.Consensus)
+	err = n.consensus.Bootstrap(types.ConsensusCallbacks{
+		BeginBlock: func(block *types.Block) types.BlockCallbacks {
+			return types.BlockCallbacks{
+				EndBlock: func() (sealEpoch *pos.Validators) {
+					n.blocks = append(n.blocks, Block{
+						Frame:    n.store.GetLastDecidedFrame() + 1,
+						Atropos:  block.Event,
+						Cheaters: block.Cheaters,
+					})
+					return nil
+				},
+			}
+		},
+	})
+	if err != nil {
+		crit(err)
+	}
+
+	semaphore := datasemaphore.New(sim.cfg.Processor.EventsBufferLimit, nil)
+	n.processor = dagprocessor.New(semaphore, sim.cfg.Processor, dagprocessor.Callback{
+		Event: dagprocessor.EventCallback{
+			Process: n.processEvent,
+			Released: func(e dag.Event, peer string, err error) {
+				if err != nil {
+					n.released[err.Error()]++
+				}
+			},
+			Get: func(id hash.Event) dag.Event {
+				return n.events[id]
+			},
+			Exists: func(id hash.Event) bool {
+				return n.events.HasEvent(id)
+			},
+			CheckParents: func(e dag.Event, parents dag.Events) error {
+				return parentscheck.New().Validate(e, parents)
+			},
+			CheckParentless: func(e dag.Event, checked func(error)) {
+				checked(basiccheck.New().Validate(e))
+			},
+		},
+		HighestLamport: func() idx.Lamport {
+			return n.highestLamport
+		},
+	})
+	n.processor.Start()
+	n.fetcher = n.newFetcher()
+	n.fetcher.Start()
+	n.startSeeder()
+	return n
+}
+
+// Blocks returns the decided blocks
+func (n *Node) Blocks() []Block {
+	return append([]Block(nil), n.blocks...)
+}
+
+// Events returns number of the connected events
+func (n *Node) Events() int {
+	return len(n.events)
+}
+
+// Streamed returns number of the events received through the events stream
+func (n *Node) Streamed() int {
+	return n.streamed
+}
+
+// Syncing returns true while the node downloads the events stream
+func (n *Node) Syncing() bool {
+	return n.syncing
+}
+
+// Released returns numbers of the events released with errors, by error texts
+func (n *Node) Released() map[string]int {
+	res := make(map[string]int, len(n.released))
+	for k, v := range n.released {
+		res[k] = v
+	}
+	return res
+}
+
+func (n *Node) stop() {
+	n.stopSync()
+	n.seeder.Stop()
+	n.fetcher.Stop()
+	n.processor.Stop()
+}
+
+// processEvent connects an event, it's called by the dagprocessor
+func (n *Node) processEvent(e dag.Event) error {
+	n.events[e.ID()] = e
+	if err := n.consensus.Process(e); err != nil {
+		delete(n.events, e.ID())
+		return err
+	}
+	if prev := n.latest[e.Creator()]; prev == nil || prev.Seq() < e.Seq() {
+		n.latest[e.Creator()] = e
+	}
+	if n.highestLamport < e.Lamport() {
+		n.highestLamport = e.Lamport()
+	}
+	_ = n.fetcher.NotifyReceived([]hash.Event{e.ID()})
+	return nil
+}
+
+// enqueue passes events to the dagprocessor, and waits until they are inserted,
+// so the simulation stays deterministic
+func (n *Node) enqueue(from string, events dag.Events) {
+	done := make(chan struct{})
+	err := n.processor.Enqueue(from, events, true, func(missing hash.Events) {
+		n.fetch(from, missing)
+	}, func() {
+		close(done)
+	})
+	if err != nil {
+		n.released[err.Error()] += len(events)
+		return
+	}
+	<-done
+}
+
+func (n *Node) send(to idx.ValidatorID, code uint64, val interface{}) {
+	msg, err := dagstream.Encode(code, val)
+	if err != nil {
+		panic(err)
+	}
+	n.sim.send(n.ID, to, msg)
+}
+
+func (n *Node) sendEvents(to idx.ValidatorID, events dag.Events) {
+	raws := make([]rlp.RawValue, len(events))
+	for i, e := range events {
+		raws[i] = e.(*tdag.TestEvent).Bytes()
+	}
+	n.send(to, dagstream.EventsMsg, &dagstream.Events{Events: raws})
+}
+
+func decodeEvent(raw rlp.RawValue) (dag.Event, error) {
+	var m tdag.TestEventMarshaling
+	if err := rlp.DecodeBytes(raw, &m); err != nil {
+		return nil, err
+	}
+	e := &tdag.TestEvent{}
+	e.SetEpoch(m.Epoch)
+	e.SetSeq(m.Seq)
+	e.SetFrame(m.Frame)
+	e.SetCreator(m.Creator)
+	e.SetParents(m.Parents)
+	e.SetLamport(m.Lamport)
+	e.Name = m.Name
+	var rID [24]byte
+	copy(rID[:], m.ID[8:])
+	e.SetID(rID)
+	if e.ID() != m.ID {
+		return nil, ErrMalformedEvent
+	}
+	return e, nil
+}
+
+// handle handles a message from a peer
+func (n *Node) handle(from idx.ValidatorID, msg dagstream.Msg) {
+	switch msg.Code {
+	case dagstream.EventsMsg:
+		if n.syncing {
+			return
+		}
+		var m dagstream.Events
+		if err := msg.Decode(&m); err != nil {
+			n.released[err.Error()]++
+			return
+		}
+		events := make(dag.Events, 0, len(m.Events))
+		for _, raw := range m.Events {
+			e, err := decodeEvent(raw)
+			if err != nil {
+				n.released[err.Error()]++
+				continue
+			}
+			events = append(events, e)
+		}
+		known := make(map[hash.Event]bool, len(events))
+		for _, e := range events {
+			known[e.ID()] = n.events.HasEvent(e.ID())
+		}
+		n.enqueue(peerName(from), events)
+		if n.Behaviour == Withholding {
+			return
+		}
+		// relay the newly connected events
+		var connected hash.Events
+		for _, e := range events {
+			if !known[e.ID()] && n.events.HasEvent(e.ID()) {
+				connected = append(connected, e.ID())
+			}
+		}
+		n.announce(from, connected)
+
+	case dagstream.AnnounceHashesMsg:
+		if n.syncing {
+			return
+		}
+		var m dagstream.AnnounceHashes
+		if err := msg.Decode(&m); err != nil {
+			n.released[err.Error()]++
+			return
+		}
+		n.fetch(peerName(from), m.IDs)
+
+	case dagstream.RequestEventsMsg:
+		if n.Behaviour == Withholding {
+			return
+		}
+		var m dagstream.RequestEvents
+		if err := msg.Decode(&m); err != nil {
+			return
+		}
+		var events dag.Events
+		for _, id := range m.IDs {
+			if e := n.events[id]; e != nil {
+				events = append(events, e)
+			} else if e := n.forks[id]; e != nil {
+				events = append(events, e)
+			}
+		}
+		if len(events) != 0 {
+			n.sendEvents(from, events)
+		}
+
+	case dagstream.StreamRequestMsg:
+		if n.Behaviour == Withholding {
+			return
+		}
+		n.serveStream(from, msg)
+
+	case dagstream.StreamResponseMsg:
+		n.handleStream(from, msg)
+	}
+}
+
+// rerequest requests again the missing parents which didn't arrive in time
+func (n *Node) rerequest() {
+	expired := n.processor.ExpiredRequests(0)
+	peers := make([]string, 0, len(expired))
+	for peer := range expired {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		if id, ok := peerID(peer); ok {
+			n.send(id, dagstream.RequestEventsMsg, &dagstream.RequestEvents{IDs: expired[peer]})
+		}
+	}
+}
+
+// chooseParents returns the latest events of random other creators
+func (n *Node) chooseParents() dag.Events {
+	others := make(dag.Events, 0, len(n.latest))
+	for creator, e := range n.latest {
+		if creator != n.ID {
+			others = append(others, e)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Creator() < others[j].Creator()
+	})
+	n.sim.rng.Shuffle(len(others), func(i, j int) {
+		others[i], others[j] = others[j], others[i]
+	})
+	if len(others) > n.sim.cfg.MaxParents-1 {
+		others = others[:n.sim.cfg.MaxParents-1]
+	}
+	return others
+}
+
+func (n *Node) buildEvent(others dag.Events, name string) dag.Event {
+	e := &tdag.TestEvent{}
+	e.SetCreator(n.ID)
+	e.SetEpoch(consensus.FirstEpoch)
+	e.SetSeq(1)
+	e.SetLamport(1)
+	if n.last != nil {
+		e.AddParent(n.last.ID())
+		e.SetSeq(n.last.Seq() + 1)
+		e.SetLamport(n.last.Lamport() + 1)
+	}
+	for _, p := range others {
+		e.AddParent(p.ID())
+		if e.Lamport() <= p.Lamport() {
+			e.SetLamport(p.Lamport() + 1)
+		}
+	}
+	e.Name = name
+	if err := n.consensus.Build(e); err != nil {
+		return nil
+	}
+	// ID is a hash of the event
+	h := sha256.Sum256(e.Bytes())
+	var rID [24]byte
+	copy(rID[:], h[:24])
+	e.SetID(rID)
+	return e
+}
+
+// emit creates a new event and sends it to the peers according to the node's behaviour
+func (n *Node) emit() {
+	if n.sim.offline[n.ID] || n.syncing {
+		return
+	}
+	name := fmt.Sprintf("%d/%d", n.ID, n.sim.clock.Now().Milliseconds())
+	e := n.buildEvent(n.chooseParents(), name)
+	if e == nil {
+		return
+	}
+	peers := n.sim.peersOf(n.ID)
+
+	switch {
+	case n.Behaviour == Forking && n.last != nil && n.sim.rng.Float64() < n.sim.cfg.ForkProbability:
+		fork := n.buildEvent(n.chooseParents(), name+"/fork")
+		if fork == nil {
+			break
+		}
+		n.forks[fork.ID()] = fork
+		if !n.connectOwn(e) {
+			return
+		}
+		for i, peer := range peers {
+			if i < len(peers)/2 {
+				n.sendEvents(peer, dag.Events{e})
+			} else {
+				n.sendEvents(peer, dag.Events{fork})
+			}
+		}
+		return
+	case n.Behaviour == Withholding:
+		if !n.connectOwn(e) {
+			return
+		}
+		if len(peers) != 0 {
+			n.sendEvents(peers[n.sim.rng.Intn(len(peers))], dag.Events{e})
+		}
+		return
+	}
+
+	if !n.connectOwn(e) {
+		return
+	}
+	for _, peer := range peers {
+		n.sendEvents(peer, dag.Events{e})
+	}
+}
+
+// connectOwn connects a self-event
+func (n *Node) connectOwn(e dag.Event) bool {
+	n.enqueue("", dag.Events{e})
+	if !n.events.HasEvent(e.ID()) {
+		return false
+	}
+	n.last = e
+	return true
+}
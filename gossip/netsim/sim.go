@@ -0,0 +1,247 @@
+package netsim
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagstream"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+)
+
+// Stats are counters of the simulated network
+type Stats struct {
+	Sent      int
+	Lost      int
+	Delivered int
+}
+
+// Simulator runs a network of nodes in a virtual time.
+// Every node runs consensus.Indexed behind a dagprocessor.Processor, and gossips with dagstream messages:
+// new events are pushed to peers, and the received events are announced to the other peers.
+// Announced events and missing parents are requested through an itemsfetcher.Fetcher,
+// and requested again if they don't arrive within Config.RequestTimeout.
+// Nodes which get connected catch up through the events stream, served by a basestreamseeder.BaseSeeder.
+// All the randomness is derived from Config.Seed, and every message is handled to completion before
+// the virtual clock moves on, so simulations are deterministic.
+// Simulator isn't safe for concurrent use.
+type Simulator struct {
+	cfg   Config
+	clock Clock
+	rng   *rand.Rand
+
+	nodes []*Node
+	byID  map[idx.ValidatorID]*Node
+
+	// groups are network partitions, nil if the network isn't partitioned
+	groups map[idx.ValidatorID]int
+	// offline are the disconnected nodes
+	offline map[idx.ValidatorID]bool
+
+	stats Stats
+}
+
+// New creates a simulator and schedules the nodes. Call Run to run the simulation.
+func New(cfg Config) *Simulator {
+	s := &Simulator{
+		cfg:     cfg,
+		rng:     rand.New(rand.NewSource(cfg.Seed)), // nolint:gosec
+		byID:    make(map[idx.ValidatorID]*Node),
+		offline: make(map[idx.ValidatorID]bool),
+	}
+
+	builder := pos.NewBuilder()
+	for i, w := range cfg.Weights {
+		builder.Set(idx.ValidatorID(i+1), w)
+	}
+	validators := builder.Build()
+
+	for i := range cfg.Weights {
+		id := idx.ValidatorID(i + 1)
+		n := newNode(s, id, cfg.behaviour(i), validators)
+		s.nodes = append(s.nodes, n)
+		s.byID[id] = n
+	}
+	for _, n := range s.nodes {
+		s.scheduleEvery(time.Duration(s.rng.Int63n(int64(cfg.EmitInterval))), cfg.EmitInterval, n.emit)
+		if cfg.RequestTimeout > 0 {
+			s.scheduleEvery(cfg.RequestTimeout, cfg.RequestTimeout, n.rerequest)
+		}
+	}
+	return s
+}
+
+func (s *Simulator) scheduleEvery(first, period time.Duration, fn func()) {
+	var next func()
+	next = func() {
+		fn()
+		s.clock.Schedule(period, next)
+	}
+	s.clock.Schedule(first, next)
+}
+
+// Now returns the virtual time passed since the simulation start
+func (s *Simulator) Now() time.Duration {
+	return s.clock.Now()
+}
+
+// Nodes returns the simulated nodes
+func (s *Simulator) Nodes() []*Node {
+	return s.nodes
+}
+
+// Node returns the node of the validator
+func (s *Simulator) Node(id idx.ValidatorID) *Node {
+	return s.byID[id]
+}
+
+// Stats returns the network counters
+func (s *Simulator) Stats() Stats {
+	return s.stats
+}
+
+// Run runs the simulation for the given virtual time
+func (s *Simulator) Run(d time.Duration) {
+	s.clock.RunUntil(s.clock.Now() + d)
+}
+
+// Partition splits the network into groups, messages between groups are lost.
+// Every node which isn't mentioned gets isolated.
+func (s *Simulator) Partition(groups ...[]idx.ValidatorID) {
+	s.groups = make(map[idx.ValidatorID]int)
+	for i, group := range groups {
+		for _, id := range group {
+			s.groups[id] = i + 1
+		}
+	}
+}
+
+// Heal removes the network partitions
+func (s *Simulator) Heal() {
+	s.groups = nil
+}
+
+// Disconnect takes the node offline. An offline node doesn't emit events, and messages from and to it are lost.
+func (s *Simulator) Disconnect(id idx.ValidatorID) {
+	s.offline[id] = true
+	s.byID[id].stopSync()
+}
+
+// Connect takes the node online. The node catches up with the network through the events stream,
+// it neither emits events nor handles the gossip until the stream is downloaded.
+func (s *Simulator) Connect(id idx.ValidatorID) {
+	delete(s.offline, id)
+	s.byID[id].startSync()
+}
+
+// SetPacketLoss changes the messages loss probability
+func (s *Simulator) SetPacketLoss(p float64) {
+	s.cfg.PacketLoss = p
+}
+
+func (s *Simulator) connected(a, b idx.ValidatorID) bool {
+	if s.offline[a] || s.offline[b] {
+		return false
+	}
+	if s.groups == nil {
+		return true
+	}
+	ga, gb := s.groups[a], s.groups[b]
+	return ga != 0 && ga == gb
+}
+
+// peersOf returns all the other nodes, regardless of partitions
+func (s *Simulator) peersOf(id idx.ValidatorID) []idx.ValidatorID {
+	peers := make([]idx.ValidatorID, 0, len(s.nodes)-1)
+	for _, n := range s.nodes {
+		if n.ID != id {
+			peers = append(peers, n.ID)
+		}
+	}
+	return peers
+}
+
+func (s *Simulator) send(from, to idx.ValidatorID, msg dagstream.Msg) {
+	s.sendBatch(from, to, []dagstream.Msg{msg})
+}
+
+// sendBatch sends messages, which are either delivered in their order or lost, like a stream of a connection
+func (s *Simulator) sendBatch(from, to idx.ValidatorID, msgs []dagstream.Msg) {
+	if len(msgs) == 0 {
+		return
+	}
+	s.stats.Sent += len(msgs)
+	if !s.connected(from, to) || (s.cfg.PacketLoss > 0 && s.rng.Float64() < s.cfg.PacketLoss) {
+		s.stats.Lost += len(msgs)
+		return
+	}
+	delay := s.cfg.Latency
+	if s.cfg.Jitter > 0 {
+		delay += time.Duration(s.rng.Int63n(int64(s.cfg.Jitter)))
+	}
+	s.clock.Schedule(delay, func() {
+		for _, msg := range msgs {
+			s.stats.Delivered++
+			s.byID[to].handle(from, msg)
+		}
+	})
+}
+
+// CheckBlocks returns an error if honest nodes decided different blocks.
+// Nodes may decide different numbers of blocks, only the common prefix is compared.
+func (s *Simulator) CheckBlocks() error {
+	var sample *Node
+	for _, n := range s.nodes {
+		if n.Behaviour != Honest {
+			continue
+		}
+		if sample == nil {
+			sample = n
+			continue
+		}
+		for i := 0; i < len(n.blocks) && i < len(sample.blocks); i++ {
+			a, b := sample.blocks[i], n.blocks[i]
+			if a.String() != b.String() {
+				return fmt.Errorf("block %d mismatch: node %d decided %s, node %d decided %s", i, sample.ID, a, n.ID, b)
+			}
+		}
+	}
+	return nil
+}
+
+// MinBlocks returns the minimum number of blocks decided by honest nodes
+func (s *Simulator) MinBlocks() int {
+	min := -1
+	for _, n := range s.nodes {
+		if n.Behaviour != Honest {
+			continue
+		}
+		if min < 0 || len(n.blocks) < min {
+			min = len(n.blocks)
+		}
+	}
+	return min
+}
+
+// HonestNodes returns IDs of the honest nodes
+func (s *Simulator) HonestNodes() []idx.ValidatorID {
+	var ids []idx.ValidatorID
+	for _, n := range s.nodes {
+		if n.Behaviour == Honest {
+			ids = append(ids, n.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Close stops the nodes
+func (s *Simulator) Close() {
+	for _, n := range s.nodes {
+		n.stop()
+	}
+}
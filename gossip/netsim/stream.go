@@ -0,0 +1,186 @@
+package netsim
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/unicornultrafoundation/go-u2u/rlp"
+
+	"github.com/unicornultrafoundation/go-hashgraph/consensus"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream/basestreamseeder"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/dagstream"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+)
+
+func (n *Node) startSeeder() {
+	n.seeder = basestreamseeder.New(n.sim.cfg.Seeder, basestreamseeder.Callbacks{
+		ForEachItem: dagstream.ForEachItem(func(start dagstream.Locator, withRaw bool, onEvent func(id hash.Event, raw rlp.RawValue) bool) {
+			ids := make(hash.Events, 0, len(n.events))
+			for id := range n.events {
+				if dagstream.EventLocator(id).Compare(start) >= 0 {
+					ids = append(ids, id)
+				}
+			}
+			sort.Slice(ids, func(i, j int) bool {
+				return bytes.Compare(ids[i].Bytes(), ids[j].Bytes()) < 0
+			})
+			for _, id := range ids {
+				var raw rlp.RawValue
+				if withRaw {
+					raw = n.events[id].(*tdag.TestEvent).Bytes()
+				}
+				if !onEvent(id, raw) {
+					return
+				}
+			}
+		}),
+	})
+	n.seeder.Start()
+
+	n.streamChunks = make(chan dagstream.Msg)
+	n.streamFailed = make(chan error, 1)
+	// sessions of the seeder keep the send function of the first request, so it's shared by all the requests
+	n.serveStreamRequest = dagstream.SeederHandler(n.seeder, func(_ string, msg dagstream.Msg) error {
+		n.streamChunks <- msg
+		return nil
+	}, func(_ string, err error) {
+		select {
+		case n.streamFailed <- err:
+		default:
+		}
+	})
+}
+
+// serveStream serves a stream request with the seeder.
+// The seeder sends chunks asynchronously. To keep the simulation deterministic, all the requested chunks are
+// awaited, and sent at once in their order. The node's events aren't modified meanwhile.
+func (n *Node) serveStream(from idx.ValidatorID, msg dagstream.Msg) {
+	var r dagstream.StreamRequest
+	if err := msg.Decode(&r); err != nil {
+		n.released[err.Error()]++
+		return
+	}
+	// forget an error of a previous request
+	select {
+	case <-n.streamFailed:
+	default:
+	}
+	if err := n.serveStreamRequest(peerName(from), msg); err != nil {
+		return
+	}
+	var chunks []dagstream.Msg
+	defer func() {
+		n.sim.sendBatch(n.ID, from, chunks)
+	}()
+	for i := uint32(0); i < r.MaxChunks; i++ {
+		select {
+		case chunk := <-n.streamChunks:
+			chunks = append(chunks, chunk)
+			var decoded dagstream.StreamResponse
+			if err := chunk.Decode(&decoded); err != nil || decoded.Done {
+				return
+			}
+		case err := <-n.streamFailed:
+			n.released[err.Error()]++
+			return
+		}
+	}
+}
+
+// startSync starts downloading the events stream from a random peer.
+// The node doesn't emit events and doesn't handle the gossip until the stream is downloaded.
+// If the peer doesn't respond within Config.Leecher.SessionTimeout, the download is continued from another peer.
+func (n *Node) startSync() {
+	start := dagstream.EpochLocator(consensus.FirstEpoch)
+	if n.leecher != nil {
+		// continue an interrupted download
+		if !n.leecher.Done() {
+			start = n.leecher.Next()
+		}
+		n.leecher.Terminate()
+	}
+	n.syncing = true
+	n.lastStreamed = n.sim.clock.Now()
+
+	peers := make([]idx.ValidatorID, 0, len(n.sim.nodes))
+	for _, peer := range n.sim.peersOf(n.ID) {
+		if n.sim.connected(n.ID, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	cfg := n.sim.cfg.Leecher
+	// sessions are timed out in the virtual time by the node
+	cfg.SessionTimeout = time.Hour
+	l := dagstream.NewLeecher(cfg, start, dagstream.EpochLocator(consensus.FirstEpoch+1), func(to string, msg dagstream.Msg) error {
+		if id, ok := peerID(to); ok {
+			n.sim.send(n.ID, id, msg)
+		}
+		return nil
+	}, dagstream.LeecherCallbacks{
+		OnPayload: func(peer string, ids hash.Events, raws []rlp.RawValue) error {
+			events := make(dag.Events, 0, len(raws))
+			for _, raw := range raws {
+				e, err := decodeEvent(raw)
+				if err != nil {
+					return err
+				}
+				events = append(events, e)
+			}
+			n.streamed += len(events)
+			n.enqueue(peer, events)
+			return nil
+		},
+		Misbehaviour: func(peer string, err error) {
+			n.released[err.Error()]++
+		},
+	})
+	if len(peers) != 0 {
+		_ = l.RegisterPeer(peerName(peers[n.sim.rng.Intn(len(peers))]))
+	}
+	n.leecher = l
+
+	var routine func()
+	routine = func() {
+		if n.leecher != l || !n.syncing {
+			return
+		}
+		if l.Done() {
+			n.syncing = false
+			return
+		}
+		if n.sim.clock.Now()-n.lastStreamed > n.sim.cfg.Leecher.SessionTimeout {
+			n.startSync()
+			return
+		}
+		l.Mu.Lock()
+		l.Routine()
+		l.Mu.Unlock()
+		n.sim.clock.Schedule(n.sim.cfg.Leecher.RecheckInterval, routine)
+	}
+	routine()
+}
+
+// stopSync stops downloading the events stream
+func (n *Node) stopSync() {
+	if n.leecher != nil {
+		n.leecher.Terminate()
+	}
+	n.leecher = nil
+	n.syncing = false
+}
+
+// handleStream handles a chunk of the events stream
+func (n *Node) handleStream(from idx.ValidatorID, msg dagstream.Msg) {
+	if n.leecher == nil {
+		return
+	}
+	n.lastStreamed = n.sim.clock.Now()
+	_ = n.leecher.Handle(peerName(from), msg)
+	if n.leecher.Done() {
+		n.syncing = false
+	}
+}
@@ -0,0 +1,64 @@
+package peerscore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/basiccheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/epochcheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/parentscheck"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+)
+
+// Penalties of the default classifier
+const (
+	// NoPenalty is for errors which happen during a normal operation of honest peers
+	NoPenalty = 0
+	// MinorPenalty is for errors which may happen with honest peers, but shouldn't happen often
+	MinorPenalty = 1
+	// MediumPenalty is for errors which are likely caused by a misbehaving or a badly synced peer
+	MediumPenalty = 10
+	// MaxPenalty is for errors which are caused only by a malicious or a broken peer
+	MaxPenalty = 100
+)
+
+// Classifier returns a penalty for an error, with which an event from a peer was released
+type Classifier func(err error) float64
+
+// DefaultClassifier classifies errors of eventcheck checkers
+func DefaultClassifier(err error) float64 {
+	switch {
+	case err == nil:
+		return NoPenalty
+	case errors.Is(err, eventcheck.ErrAlreadyConnectedEvent):
+		// events may be received from multiple peers simultaneously
+		return NoPenalty
+	case errors.Is(err, eventcheck.ErrQuarantinedEvent):
+		// event isn't dropped yet, it'll be connected once the parents arrive
+		return NoPenalty
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		basestream.IsLocalError(err):
+		// event is dropped because of the local node
+		return NoPenalty
+	case errors.Is(err, eventcheck.ErrDuplicateEvent),
+		errors.Is(err, eventcheck.ErrSpilledEvent):
+		// duplicates are relayed by honest peers, and events are spilled because of the local buffer pressure
+		return NoPenalty
+	case errors.Is(err, epochcheck.ErrNotRelevant):
+		return MinorPenalty
+	case errors.Is(err, basiccheck.ErrNoParents),
+		errors.Is(err, basiccheck.ErrNotInited),
+		errors.Is(err, basiccheck.ErrHugeValue),
+		errors.Is(err, basiccheck.ErrDoubleParents),
+		errors.Is(err, epochcheck.ErrAuth),
+		errors.Is(err, parentscheck.ErrWrongSeq),
+		errors.Is(err, parentscheck.ErrWrongLamport),
+		errors.Is(err, parentscheck.ErrWrongSelfParent):
+		return MaxPenalty
+	default:
+		// unknown errors may be caused locally, e.g. by a DB failure
+		return NoPenalty
+	}
+}
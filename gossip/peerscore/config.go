@@ -0,0 +1,26 @@
+package peerscore
+
+import (
+	"time"
+)
+
+type Config struct {
+	// HalfLife is a time after which a penalty score is halved
+	HalfLife time.Duration
+	// BanThreshold is a score, at which a peer gets banned
+	BanThreshold float64
+	// BanDuration is a time during which a peer stays banned, regardless of the score decay
+	BanDuration time.Duration
+	// MaxPeers is a max number of tracked peers. Once it's reached, peers with negligible scores
+	// are forgotten, and then the lowest-scored ones
+	MaxPeers int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		HalfLife:     10 * time.Minute,
+		BanThreshold: 100,
+		BanDuration:  1 * time.Hour,
+		MaxPeers:     1000,
+	}
+}
@@ -0,0 +1,167 @@
+package peerscore
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+)
+
+// negligibleScore is a score, below which a not banned peer may be forgotten
+const negligibleScore = 0.1
+
+type peerScore struct {
+	score       float64
+	updated     time.Time
+	bannedUntil time.Time
+}
+
+// Scores tracks penalty scores of peers. The scores decay exponentially over time.
+// A peer gets banned for Config.BanDuration once its score reaches Config.BanThreshold.
+// Scores is safe for concurrent use.
+type Scores struct {
+	cfg      Config
+	classify Classifier
+
+	mu    sync.Mutex
+	peers map[string]*peerScore
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// New creates Scores instance. If classify is nil, then DefaultClassifier is used.
+func New(cfg Config, classify Classifier) *Scores {
+	if classify == nil {
+		classify = DefaultClassifier
+	}
+	return &Scores{
+		cfg:      cfg,
+		classify: classify,
+		peers:    make(map[string]*peerScore),
+		now:      time.Now,
+	}
+}
+
+// decay applies the exponential decay to the peer's score
+func (s *Scores) decay(p *peerScore, now time.Time) {
+	elapsed := now.Sub(p.updated)
+	if elapsed <= 0 {
+		return
+	}
+	if s.cfg.HalfLife > 0 {
+		p.score *= math.Pow(0.5, float64(elapsed)/float64(s.cfg.HalfLife))
+	}
+	p.updated = now
+}
+
+// Released is compatible with dagprocessor.EventCallback.Released, it penalizes the peer according to the error.
+// Events with an empty peer (i.e. events created locally) are ignored.
+func (s *Scores) Released(_ dag.Event, peer string, err error) {
+	if peer == "" || err == nil {
+		return
+	}
+	s.Penalize(peer, s.classify(err))
+}
+
+// Misbehaviour penalizes the peer according to the error.
+func (s *Scores) Misbehaviour(peer string, err error) {
+	s.Penalize(peer, s.classify(err))
+}
+
+// Penalize increases the peer's score.
+// Returns true if the peer is banned.
+func (s *Scores) Penalize(peer string, penalty float64) bool {
+	if penalty <= 0 {
+		return s.Banned(peer)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	p, ok := s.peers[peer]
+	if !ok {
+		s.evict(now)
+		p = &peerScore{
+			updated: now,
+		}
+		s.peers[peer] = p
+	}
+	s.decay(p, now)
+	p.score += penalty
+	if p.score >= s.cfg.BanThreshold {
+		p.bannedUntil = now.Add(s.cfg.BanDuration)
+	}
+	return now.Before(p.bannedUntil)
+}
+
+// Score returns the current peer's score
+func (s *Scores) Score(peer string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.peers[peer]
+	if !ok {
+		return 0
+	}
+	s.decay(p, s.now())
+	return p.score
+}
+
+// Banned returns true if the peer is banned.
+// It's compatible with Banned callbacks of itemsfetcher and basestreamseeder.
+func (s *Scores) Banned(peer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.peers[peer]
+	if !ok {
+		return false
+	}
+	return s.now().Before(p.bannedUntil)
+}
+
+// Forget erases the peer's score and ban
+func (s *Scores) Forget(peer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.peers, peer)
+}
+
+// evict erases not banned peers with negligible scores, if too many peers are tracked.
+// If it's not enough, then the lowest-scored peers are erased, banned peers are erased last.
+func (s *Scores) evict(now time.Time) {
+	if len(s.peers) < s.cfg.MaxPeers {
+		return
+	}
+	for peer, p := range s.peers {
+		s.decay(p, now)
+		if p.score < negligibleScore && !now.Before(p.bannedUntil) {
+			delete(s.peers, peer)
+		}
+	}
+	for len(s.peers) != 0 && len(s.peers) >= s.cfg.MaxPeers {
+		var victim string
+		var victimScore *peerScore
+		for peer, p := range s.peers {
+			if victimScore == nil || lessValuable(p, victimScore, now) {
+				victim, victimScore = peer, p
+			}
+		}
+		delete(s.peers, victim)
+	}
+}
+
+// lessValuable returns true if a is less worth tracking than b
+func lessValuable(a, b *peerScore, now time.Time) bool {
+	aBanned, bBanned := now.Before(a.bannedUntil), now.Before(b.bannedUntil)
+	if aBanned != bBanned {
+		return !aBanned
+	}
+	if aBanned && !a.bannedUntil.Equal(b.bannedUntil) {
+		return a.bannedUntil.Before(b.bannedUntil)
+	}
+	return a.score < b.score
+}
@@ -0,0 +1,134 @@
+package peerscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/basiccheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/epochcheck"
+	"github.com/unicornultrafoundation/go-hashgraph/eventcheck/parentscheck"
+	"github.com/unicornultrafoundation/go-hashgraph/gossip/basestream"
+)
+
+func newTestScores(cfg Config) (*Scores, *time.Time) {
+	now := time.Unix(1000, 0)
+	s := New(cfg, nil)
+	s.now = func() time.Time {
+		return now
+	}
+	return s, &now
+}
+
+func TestDefaultClassifier(t *testing.T) {
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(nil))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(eventcheck.ErrQuarantinedEvent))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(eventcheck.ErrAlreadyConnectedEvent))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(context.Canceled))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(eventcheck.ErrSpilledEvent))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(eventcheck.ErrDuplicateEvent))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(&basestream.LocalError{Err: basiccheck.ErrHugeValue}))
+	assert.Equal(t, float64(MinorPenalty), DefaultClassifier(epochcheck.ErrNotRelevant))
+	assert.Equal(t, float64(MaxPenalty), DefaultClassifier(basiccheck.ErrHugeValue))
+	assert.Equal(t, float64(MaxPenalty), DefaultClassifier(fmt.Errorf("wrapped: %w", parentscheck.ErrWrongLamport)))
+	assert.Equal(t, float64(NoPenalty), DefaultClassifier(errors.New("unknown")))
+}
+
+func TestScores(t *testing.T) {
+	cfg := DefaultConfig()
+	s, now := newTestScores(cfg)
+
+	// honest errors
+	s.Released(nil, "peer1", eventcheck.ErrAlreadyConnectedEvent)
+	s.Released(nil, "peer1", nil)
+	assert.Equal(t, 0.0, s.Score("peer1"))
+
+	// locally created events are ignored
+	s.Released(nil, "", basiccheck.ErrHugeValue)
+	assert.False(t, s.Banned(""))
+
+	// decay
+	s.Penalize("peer1", MediumPenalty)
+	assert.Equal(t, float64(MediumPenalty), s.Score("peer1"))
+	*now = now.Add(cfg.HalfLife)
+	assert.InDelta(t, MediumPenalty/2.0, s.Score("peer1"), 0.0001)
+	*now = now.Add(cfg.HalfLife)
+	assert.InDelta(t, MediumPenalty/4.0, s.Score("peer1"), 0.0001)
+	assert.False(t, s.Banned("peer1"))
+
+	// ban
+	s.Released(nil, "peer2", basiccheck.ErrDoubleParents)
+	assert.True(t, s.Banned("peer2"))
+	assert.False(t, s.Banned("peer1"))
+	// ban isn't lifted by the score decay
+	*now = now.Add(cfg.BanDuration - time.Second)
+	assert.Less(t, s.Score("peer2"), cfg.BanThreshold)
+	assert.True(t, s.Banned("peer2"))
+	*now = now.Add(time.Second)
+	assert.False(t, s.Banned("peer2"))
+
+	// many minor penalties lead to a ban
+	for i := 0; i < int(cfg.BanThreshold)-1; i++ {
+		assert.False(t, s.Penalize("peer3", MinorPenalty))
+	}
+	assert.True(t, s.Penalize("peer3", MinorPenalty))
+
+	s.Forget("peer3")
+	assert.False(t, s.Banned("peer3"))
+	assert.Equal(t, 0.0, s.Score("peer3"))
+}
+
+func TestScoresForgetNegligible(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxPeers = 10
+	s, now := newTestScores(cfg)
+
+	s.Penalize("banned", MaxPenalty)
+	for i := 0; i < cfg.MaxPeers; i++ {
+		s.Penalize(fmt.Sprintf("peer%d", i), MinorPenalty)
+	}
+	*now = now.Add(cfg.HalfLife * 5)
+	s.Penalize("new", MinorPenalty)
+
+	assert.Len(t, s.peers, 2)
+	assert.True(t, s.Banned("banned"))
+	assert.Equal(t, float64(MinorPenalty), s.Score("new"))
+}
+
+func TestScoresNoBanForHonestErrors(t *testing.T) {
+	cfg := DefaultConfig()
+	s, now := newTestScores(cfg)
+
+	// a busy peer relays duplicates, and its events are spilled, for hours
+	for i := 0; i < 100000; i++ {
+		s.Released(nil, "peer", eventcheck.ErrDuplicateEvent)
+		s.Released(nil, "peer", eventcheck.ErrSpilledEvent)
+		s.Released(nil, "peer", errors.New("local DB failure"))
+		*now = now.Add(100 * time.Millisecond)
+	}
+	assert.False(t, s.Banned("peer"))
+	assert.Equal(t, 0.0, s.Score("peer"))
+}
+
+func TestScoresMaxPeers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxPeers = 10
+	s, _ := newTestScores(cfg)
+
+	s.Penalize("banned", MaxPenalty)
+	for i := 0; i < cfg.MaxPeers*3; i++ {
+		s.Penalize(fmt.Sprintf("peer%d", i), float64(MinorPenalty+i))
+		assert.LessOrEqual(t, len(s.peers), cfg.MaxPeers)
+	}
+	// the lowest-scored peers are evicted
+	assert.True(t, s.Banned("banned"))
+	assert.Equal(t, 0.0, s.Score("peer0"))
+	last := fmt.Sprintf("peer%d", cfg.MaxPeers*3-1)
+	assert.Equal(t, float64(MinorPenalty+cfg.MaxPeers*3-1), s.Score(last))
+}
@@ -1,6 +1,7 @@
 package datasemaphore
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -11,8 +12,10 @@ type DataSemaphore struct {
 	processing    dag.Metric
 	maxProcessing dag.Metric
 
-	mu   sync.Mutex
-	cond *sync.Cond
+	mu sync.Mutex
+	// released is closed and replaced once the processing metric decreases or the semaphore is terminated
+	released chan struct{}
+	waiting  bool
 
 	warning func(received dag.Metric, processing dag.Metric, releasing dag.Metric)
 }
@@ -21,22 +24,39 @@ func New(maxProcessing dag.Metric, warning func(received dag.Metric, processing
 	s := &DataSemaphore{
 		maxProcessing: maxProcessing,
 		warning:       warning,
+		released:      make(chan struct{}),
 	}
-	s.cond = sync.NewCond(&s.mu)
 	return s
 }
 
 func (s *DataSemaphore) Acquire(weight dag.Metric, timeout time.Duration) bool {
-	deadline := time.Now().Add(timeout)
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	for !s.tryAcquire(weight) {
-		if weight.Size > s.maxProcessing.Size || weight.Num > s.maxProcessing.Num || time.Now().After(deadline) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.AcquireContext(ctx, weight)
+}
+
+// AcquireContext waits until the weight is acquired, or until ctx is done or the semaphore is terminated.
+func (s *DataSemaphore) AcquireContext(ctx context.Context, weight dag.Metric) bool {
+	for {
+		s.mu.Lock()
+		if s.tryAcquire(weight) {
+			s.mu.Unlock()
+			return true
+		}
+		if weight.Size > s.maxProcessing.Size || weight.Num > s.maxProcessing.Num || ctx.Err() != nil {
+			s.mu.Unlock()
+			return false
+		}
+		released := s.released
+		s.waiting = true
+		s.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
 			return false
 		}
-		s.cond.Wait()
 	}
-	return true
 }
 
 func (s *DataSemaphore) TryAcquire(weight dag.Metric) bool {
@@ -68,14 +88,24 @@ func (s *DataSemaphore) Release(weight dag.Metric) {
 		s.processing.Num -= weight.Num
 		s.processing.Size -= weight.Size
 	}
-	s.cond.Broadcast()
+	s.broadcast()
 }
 
 func (s *DataSemaphore) Terminate() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.maxProcessing = dag.Metric{}
-	s.cond.Broadcast()
+	s.broadcast()
+}
+
+// broadcast wakes up all the waiting acquirers. Must be called under the lock.
+func (s *DataSemaphore) broadcast() {
+	if !s.waiting {
+		return
+	}
+	close(s.released)
+	s.released = make(chan struct{})
+	s.waiting = false
 }
 
 func (s *DataSemaphore) Processing() dag.Metric {
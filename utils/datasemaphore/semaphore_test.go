@@ -0,0 +1,80 @@
+package datasemaphore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+)
+
+func TestAcquireContextCancel(t *testing.T) {
+	require := require.New(t)
+
+	s := New(dag.Metric{Num: 1, Size: 10}, nil)
+	require.True(s.TryAcquire(dag.Metric{Num: 1, Size: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan bool, 1)
+	go func() {
+		acquired <- s.AcquireContext(ctx, dag.Metric{Num: 1, Size: 1})
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired over the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	require.False(<-acquired)
+	require.Equal(dag.Metric{Num: 1, Size: 1}, s.Processing())
+
+	// already cancelled ctx
+	require.False(s.AcquireContext(ctx, dag.Metric{Num: 1, Size: 1}))
+	// weight over the max is never acquired
+	require.False(s.AcquireContext(context.Background(), dag.Metric{Num: 1, Size: 11}))
+}
+
+func TestAcquireContextCancelledWaiter(t *testing.T) {
+	require := require.New(t)
+
+	s := New(dag.Metric{Num: 1, Size: 10}, nil)
+	require.True(s.TryAcquire(dag.Metric{Num: 1, Size: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan bool, 1)
+	go func() {
+		cancelled <- s.AcquireContext(ctx, dag.Metric{Num: 1, Size: 1})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	require.False(<-cancelled)
+
+	waiting := make(chan bool, 1)
+	go func() {
+		waiting <- s.AcquireContext(context.Background(), dag.Metric{Num: 1, Size: 2})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Release(dag.Metric{Num: 1, Size: 1})
+
+	// the release is taken by the live waiter, not by the cancelled one
+	require.True(<-waiting)
+	require.Equal(dag.Metric{Num: 1, Size: 2}, s.Processing())
+	require.False(s.TryAcquire(dag.Metric{Num: 1, Size: 1}))
+}
+
+func TestAcquireContextTerminate(t *testing.T) {
+	require := require.New(t)
+
+	s := New(dag.Metric{Num: 1, Size: 10}, nil)
+	require.True(s.TryAcquire(dag.Metric{Num: 1, Size: 1}))
+
+	acquired := make(chan bool, 1)
+	go func() {
+		acquired <- s.AcquireContext(context.Background(), dag.Metric{Num: 1, Size: 1})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Terminate()
+	require.False(<-acquired)
+}
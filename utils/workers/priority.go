@@ -0,0 +1,113 @@
+package workers
+
+import (
+	"context"
+	"sync"
+)
+
+// PriorityWorkers is like Workers, but tasks are executed according to their priorities.
+// A task is picked only if there are no pending tasks with a higher priority.
+// Tasks with the same priority are executed in FIFO order.
+type PriorityWorkers struct {
+	quit chan struct{}
+	wg   *sync.WaitGroup
+	// tasks by priority, higher index is a higher priority
+	tasks []chan func()
+	// pending has a token per each enqueued task, tokens are sent after tasks
+	pending chan struct{}
+}
+
+// NewPriority creates PriorityWorkers with priorities from 0 to levels-1.
+// maxTasks is a capacity of each priority queue.
+func NewPriority(wg *sync.WaitGroup, quit chan struct{}, maxTasks int, levels int) *PriorityWorkers {
+	tasks := make([]chan func(), levels)
+	for i := range tasks {
+		tasks[i] = make(chan func(), maxTasks)
+	}
+	return &PriorityWorkers{
+		tasks:   tasks,
+		pending: make(chan struct{}, maxTasks*levels),
+		quit:    quit,
+		wg:      wg,
+	}
+}
+
+func (w *PriorityWorkers) Start(workersN int) {
+	for i := 0; i < workersN; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.worker()
+		}()
+	}
+}
+
+// Enqueue adds a task with the given priority. The priority is clamped to [0, levels-1].
+func (w *PriorityWorkers) Enqueue(priority int, fn func()) error {
+	return w.EnqueueContext(context.Background(), priority, fn)
+}
+
+// EnqueueContext is like Enqueue, but it stops waiting for a free slot once ctx is done.
+func (w *PriorityWorkers) EnqueueContext(ctx context.Context, priority int, fn func()) error {
+	if priority < 0 {
+		priority = 0
+	}
+	if priority >= len(w.tasks) {
+		priority = len(w.tasks) - 1
+	}
+	select {
+	case w.tasks[priority] <- fn:
+		// never blocks, because capacity of pending is a sum of tasks capacities
+		w.pending <- struct{}{}
+		return nil
+	case <-w.quit:
+		return errTerminated
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *PriorityWorkers) Drain() {
+	for {
+		select {
+		case <-w.pending:
+			w.pop()
+		default:
+			return
+		}
+	}
+}
+
+func (w *PriorityWorkers) TasksCount() int {
+	return len(w.pending)
+}
+
+// PriorityTasksCount returns number of pending tasks with the given priority
+func (w *PriorityWorkers) PriorityTasksCount(priority int) int {
+	return len(w.tasks[priority])
+}
+
+// pop takes a task with the highest priority.
+// It must be called only after a token is taken from pending, so at least one task is guaranteed to exist.
+func (w *PriorityWorkers) pop() func() {
+	for {
+		for i := len(w.tasks) - 1; i >= 0; i-- {
+			select {
+			case job := <-w.tasks[i]:
+				return job
+			default:
+			}
+		}
+	}
+}
+
+func (w *PriorityWorkers) worker() {
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-w.pending:
+			w.pop()()
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityWorkersOrder(t *testing.T) {
+	require := require.New(t)
+
+	quit := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	w := NewPriority(wg, quit, 10, 3)
+
+	var executed []int
+	done := make(chan struct{})
+	// enqueue before start, so all the tasks are pending when the worker picks them
+	for i := 0; i < 3; i++ {
+		for _, priority := range []int{0, 2, 1} {
+			priority, i := priority, i
+			require.NoError(w.Enqueue(priority, func() {
+				executed = append(executed, priority*10+i)
+			}))
+		}
+	}
+	require.NoError(w.Enqueue(-1, func() {
+		close(done)
+	}))
+	require.Equal(10, w.TasksCount())
+	require.Equal(4, w.PriorityTasksCount(0))
+	require.Equal(3, w.PriorityTasksCount(2))
+
+	w.Start(1)
+	<-done
+	close(quit)
+	wg.Wait()
+
+	require.Equal([]int{20, 21, 22, 10, 11, 12, 0, 1, 2}, executed)
+	require.Equal(0, w.TasksCount())
+}
+
+func TestPriorityWorkersMaxTasks(t *testing.T) {
+	require := require.New(t)
+
+	quit := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	w := NewPriority(wg, quit, 2, 2)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(w.Enqueue(0, func() {}))
+		require.NoError(w.Enqueue(1, func() {}))
+	}
+	require.Equal(4, w.TasksCount())
+
+	// the queue of the priority is full
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(context.DeadlineExceeded, w.EnqueueContext(ctx, 1, func() {}))
+	require.Equal(4, w.TasksCount())
+
+	w.Drain()
+	require.Equal(0, w.TasksCount())
+	require.NoError(w.Enqueue(1, func() {}))
+	close(quit)
+}
+
+func TestPriorityWorkersQuit(t *testing.T) {
+	require := require.New(t)
+
+	quit := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	w := NewPriority(wg, quit, 1, 1)
+	w.Start(1)
+
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	require.NoError(w.Enqueue(0, func() {
+		close(blocked)
+		<-release
+	}))
+	<-blocked
+	require.NoError(w.Enqueue(0, func() {}))
+
+	// a blocked enqueue is interrupted by quit
+	errC := make(chan error, 1)
+	go func() {
+		errC <- w.Enqueue(0, func() {})
+	}()
+	close(quit)
+	require.Equal(errTerminated, <-errC)
+	require.Equal(errTerminated, w.Enqueue(0, func() {}))
+
+	close(release)
+	wg.Wait()
+}
+
+func TestPriorityWorkersEnqueueContext(t *testing.T) {
+	require := require.New(t)
+
+	quit := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	w := NewPriority(wg, quit, 1, 2)
+
+	require.NoError(w.Enqueue(1, func() {}))
+
+	// the waiting for a free slot is interrupted by ctx cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		errC <- w.EnqueueContext(ctx, 1, func() {})
+	}()
+	select {
+	case err := <-errC:
+		t.Fatalf("enqueued into a full queue, err=%v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	require.Equal(context.Canceled, <-errC)
+	require.Equal(1, w.TasksCount())
+
+	// queues of other priorities aren't affected
+	require.NoError(w.EnqueueContext(context.Background(), 0, func() {}))
+	require.Equal(2, w.TasksCount())
+	close(quit)
+}
@@ -51,3 +51,16 @@ func (vi *Engine) AtLeastOneFork() bool {
 func (vi *Engine) BranchesInfo() *BranchesInfo {
 	return vi.bi
 }
+
+// Copy returns a deep copy of BranchesInfo
+func (bi *BranchesInfo) Copy() *BranchesInfo {
+	cp := &BranchesInfo{
+		BranchIDLastSeq:     append([]idx.Event(nil), bi.BranchIDLastSeq...),
+		BranchIDCreatorIdxs: append([]idx.Validator(nil), bi.BranchIDCreatorIdxs...),
+		BranchIDByCreators:  make([][]idx.Validator, len(bi.BranchIDByCreators)),
+	}
+	for i, branches := range bi.BranchIDByCreators {
+		cp.BranchIDByCreators[i] = append([]idx.Validator(nil), branches...)
+	}
+	return cp
+}
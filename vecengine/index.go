@@ -20,6 +20,17 @@ type Callbacks struct {
 	NewHighestBefore func(idx.Validator) HighestBeforeI
 	NewLowestAfter   func(idx.Validator) LowestAfterI
 	OnDropNotFlushed func()
+	// Branches keep the events' branch IDs and BranchesInfo instead of the DB. Optional.
+	Branches *BranchesCallbacks
+}
+
+// BranchesCallbacks keep the events' global branch IDs and BranchesInfo.
+// BranchesInfo is modified by Engine, so GetBranchesInfo must return a copy, and SetBranchesInfo must store a copy.
+type BranchesCallbacks struct {
+	GetEventBranchID func(hash.Event) (idx.Validator, bool)
+	SetEventBranchID func(hash.Event, idx.Validator)
+	GetBranchesInfo  func() *BranchesInfo
+	SetBranchesInfo  func(*BranchesInfo)
 }
 
 type Engine struct {
@@ -33,6 +44,9 @@ type Engine struct {
 
 	callback Callbacks
 
+	// pruning is true if vectors of old events may be pruned
+	pruning bool
+
 	vecDb u2udb.FlushableKVStore
 	table struct {
 		EventBranch  u2udb.Store `table:"b"`
@@ -51,6 +65,7 @@ func NewIndex(crit func(error), callbacks Callbacks) *Engine {
 }
 
 // Reset resets buffers.
+// db may be nil if Callbacks.Branches is set.
 func (vi *Engine) Reset(validators *pos.Validators, db u2udb.FlushableKVStore, getEvent func(hash.Event) dag.Event) {
 	// use wrapper to be able to drop failed events by dropping cache
 	vi.getEvent = getEvent
@@ -59,7 +74,9 @@ func (vi *Engine) Reset(validators *pos.Validators, db u2udb.FlushableKVStore, g
 	vi.validatorIdxs = validators.Idxs()
 	vi.DropNotFlushed()
 
-	table.MigrateTables(&vi.table, vi.vecDb)
+	if vi.vecDb != nil {
+		table.MigrateTables(&vi.table, vi.vecDb)
+	}
 }
 
 // Add calculates vector clocks for the event and saves into DB.
@@ -74,6 +91,9 @@ func (vi *Engine) Flush() {
 	if vi.bi != nil {
 		vi.setBranchesInfo(vi.bi)
 	}
+	if vi.vecDb == nil {
+		return
+	}
 	if err := vi.vecDb.Flush(); err != nil {
 		vi.crit(err)
 	}
@@ -82,7 +102,7 @@ func (vi *Engine) Flush() {
 // DropNotFlushed not connected clocks. Call it if event has failed.
 func (vi *Engine) DropNotFlushed() {
 	vi.bi = nil
-	if vi.vecDb.NotFlushedPairs() != 0 {
+	if vi.vecDb != nil && vi.vecDb.NotFlushedPairs() != 0 {
 		vi.vecDb.DropNotFlushed()
 		if vi.callback.OnDropNotFlushed != nil {
 			vi.callback.OnDropNotFlushed()
@@ -143,20 +163,21 @@ func (vi *Engine) fillEventVectors(e dag.Event) (allVecs, error) {
 		after:  vi.callback.NewLowestAfter(idx.Validator(len(vi.bi.BranchIDCreatorIdxs))),
 	}
 
-	meBranchID, err := vi.fillGlobalBranchID(e, meIdx)
-	if err != nil {
-		return myVecs, err
-	}
-
 	// pre-load parents into RAM for quick access
+	// parents are checked before the global branch ID is assigned, because a parent may be pruned
 	parentsVecs := make([]HighestBeforeI, len(e.Parents()))
 	parentsBranchIDs := make([]idx.Validator, len(e.Parents()))
 	for i, p := range e.Parents() {
-		parentsBranchIDs[i] = vi.GetEventBranchID(p)
 		parentsVecs[i] = vi.callback.GetHighestBefore(p)
 		if parentsVecs[i] == nil {
-			return myVecs, fmt.Errorf("processed out of order, parent not found (inconsistent DB), parent=%s", p.String())
+			return myVecs, fmt.Errorf("processed out of order, parent not found (inconsistent DB or pruned), parent=%s", p.String())
 		}
+		parentsBranchIDs[i] = vi.GetEventBranchID(p)
+	}
+
+	meBranchID, err := vi.fillGlobalBranchID(e, meIdx)
+	if err != nil {
+		return myVecs, err
 	}
 
 	// observed by himself
@@ -210,6 +231,13 @@ func (vi *Engine) fillEventVectors(e dag.Event) (allVecs, error) {
 	// graph traversal starting from e, but excluding e
 	onWalk := func(walk hash.Event) (godeeper bool) {
 		wLowestAfterSeq := vi.callback.GetLowestAfter(walk)
+		if wLowestAfterSeq == nil {
+			if !vi.pruning {
+				vi.crit(fmt.Errorf("event=%s not found (inconsistent DB)", walk.String()))
+			}
+			// vectors of the event are pruned, its ancestors are pruned too
+			return false
+		}
 
 		// update LowestAfter vector of the old event, because newly-connected event observes it
 		if wLowestAfterSeq.Visit(meBranchID, e) {
@@ -236,6 +264,9 @@ func (vi *Engine) GetMergedHighestBefore(id hash.Event) HighestBeforeI {
 
 	if vi.AtLeastOneFork() {
 		scatteredBefore := vi.callback.GetHighestBefore(id)
+		if scatteredBefore == nil {
+			return nil
+		}
 
 		mergedBefore := vi.callback.NewHighestBefore(vi.validators.Len())
 
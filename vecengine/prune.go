@@ -0,0 +1,26 @@
+package vecengine
+
+import (
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+)
+
+// EnablePruning makes the index tolerate missing vectors of the pruned events.
+// Without it, a missing vector means an inconsistent DB.
+func (vi *Engine) EnablePruning() {
+	vi.pruning = true
+}
+
+// Prune drops global branch IDs of the events.
+// Pruned events cannot be used as parents or as arguments of the index queries anymore,
+// so only events which are confirmed long ago may be pruned, along with all their ancestors.
+// Branch IDs kept by Callbacks.Branches are pruned by the owner of the callbacks.
+func (vi *Engine) Prune(ids hash.Events) {
+	if vi.callback.Branches != nil {
+		return
+	}
+	for _, id := range ids {
+		if err := vi.table.EventBranch.Delete(id.Bytes()); err != nil {
+			vi.crit(err)
+		}
+	}
+}
@@ -55,12 +55,19 @@ func (vi *Engine) setBytes(table u2udb.Store, id hash.Event, b []byte) {
 }
 
 func (vi *Engine) setBranchesInfo(info *BranchesInfo) {
+	if vi.callback.Branches != nil {
+		vi.callback.Branches.SetBranchesInfo(info)
+		return
+	}
 	key := []byte("c")
 
 	vi.setRlp(vi.table.BranchesInfo, key, info)
 }
 
 func (vi *Engine) getBranchesInfo() *BranchesInfo {
+	if vi.callback.Branches != nil {
+		return vi.callback.Branches.GetBranchesInfo()
+	}
 	key := []byte("c")
 
 	w, exists := vi.getRlp(vi.table.BranchesInfo, key, &BranchesInfo{}).(*BranchesInfo)
@@ -71,13 +78,36 @@ func (vi *Engine) getBranchesInfo() *BranchesInfo {
 	return w
 }
 
+// DropBranchesInfo erases BranchesInfo from the store, it'll be re-initialized on the next event
+func (vi *Engine) DropBranchesInfo() {
+	vi.bi = nil
+	if vi.callback.Branches != nil {
+		vi.callback.Branches.SetBranchesInfo(nil)
+		return
+	}
+	if err := vi.table.BranchesInfo.Delete([]byte("c")); err != nil {
+		vi.crit(err)
+	}
+}
+
 // SetEventBranchID stores the event's global branch ID
 func (vi *Engine) SetEventBranchID(id hash.Event, branchID idx.Validator) {
+	if vi.callback.Branches != nil {
+		vi.callback.Branches.SetEventBranchID(id, branchID)
+		return
+	}
 	vi.setBytes(vi.table.EventBranch, id, branchID.Bytes())
 }
 
 // GetEventBranchID reads the event's global branch ID
 func (vi *Engine) GetEventBranchID(id hash.Event) idx.Validator {
+	if vi.callback.Branches != nil {
+		branchID, ok := vi.callback.Branches.GetEventBranchID(id)
+		if !ok {
+			vi.crit(errors.New("failed to read event's branch ID (inconsistent DB)"))
+		}
+		return branchID
+	}
 	b := vi.getBytes(vi.table.EventBranch, id)
 	if b == nil {
 		vi.crit(errors.New("failed to read event's branch ID (inconsistent DB)"))
@@ -58,6 +58,19 @@ func (w *backedMap) add(key string, val []byte) {
 	}
 }
 
+func (w *backedMap) delete(key string) error {
+	if val, ok := w.cache[key]; ok {
+		delete(w.cache, key)
+		rmS := mapMemEst(len(key), len(val))
+		if rmS <= w.memSize {
+			w.memSize -= rmS
+		} else {
+			w.memSize = 0
+		}
+	}
+	return w.backup.Delete([]byte(key))
+}
+
 // mayUnload evicts and flushes one batch of data
 func (w *backedMap) mayUnload() error {
 	for w.memSize > w.maxMemSize {
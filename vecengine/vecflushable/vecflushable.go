@@ -21,9 +21,10 @@ func mapMemEst(keyS, valueS int) int {
 	return mapConst + keyS + valueS
 }
 
-// VecFlushable is a fast, append only, Flushable intended for the vecengine.
+// VecFlushable is a fast, mostly append only, Flushable intended for the vecengine.
 // It does not implement all of the Flushable interface, just what is needed by
 // the vecengine.
+// Deleted keys are kept as nil values until Flush is called.
 type VecFlushable struct {
 	modified   map[string][]byte
 	underlying backedMap
@@ -53,9 +54,9 @@ func (w *VecFlushable) Has(key []byte) (bool, error) {
 	if w.modified == nil {
 		return false, errClosed
 	}
-	_, ok := w.modified[string(key)]
+	val, ok := w.modified[string(key)]
 	if ok {
-		return true, nil
+		return val != nil, nil
 	}
 	return w.underlying.has(key)
 }
@@ -65,6 +66,9 @@ func (w *VecFlushable) Get(key []byte) ([]byte, error) {
 		return nil, errClosed
 	}
 	if val, ok := w.modified[string(key)]; ok {
+		if val == nil {
+			return nil, nil
+		}
 		return common.CopyBytes(val), nil
 	}
 	return w.underlying.get(key)
@@ -74,6 +78,9 @@ func (w *VecFlushable) Put(key []byte, value []byte) error {
 	if value == nil || key == nil {
 		return errors.New("vecflushable: key or value is nil")
 	}
+	if w.modified == nil {
+		return errClosed
+	}
 	w.modified[string(key)] = common.CopyBytes(value)
 	w.memSize += mapMemEst(len(key), len(value))
 	return nil
@@ -93,6 +100,13 @@ func (w *VecFlushable) Flush() error {
 	}
 
 	for key, val := range w.modified {
+		if val == nil {
+			err := w.underlying.delete(key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		w.underlying.add(key, val)
 	}
 
@@ -119,16 +133,29 @@ func (w *VecFlushable) Close() error {
 	return w.underlying.close()
 }
 
+// Delete removes key-value pair by key. In parent DB, key won't be deleted until .Flush() is called.
+func (w *VecFlushable) Delete(key []byte) error {
+	if key == nil {
+		return errors.New("vecflushable: key is nil")
+	}
+	if w.modified == nil {
+		return errClosed
+	}
+	if prev, ok := w.modified[string(key)]; ok {
+		// the pending entry is replaced
+		w.memSize -= mapMemEst(len(key), len(prev))
+	}
+	w.modified[string(key)] = nil
+	w.memSize += mapMemEst(len(key), 0)
+	return nil
+}
+
 func (w *VecFlushable) Drop() {
 	panic(errNotImplemented)
 }
 
 /* Some methods are not implemented and panic when called */
 
-func (w *VecFlushable) Delete(key []byte) error {
-	panic(errNotImplemented)
-}
-
 func (w *VecFlushable) GetSnapshot() (u2udb.Snapshot, error) {
 	panic(errNotImplemented)
 }
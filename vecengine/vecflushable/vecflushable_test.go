@@ -165,6 +165,67 @@ func TestVecflushableUpdateValue(t *testing.T) {
 	assert.Equal(t, 356, vecflushable.underlying.memSize)
 }
 
+// TestVecflushableDelete tests that deleted keys are hidden before flush, and
+// removed from both the underlying cache and the backup store after flush.
+func TestVecflushableDelete(t *testing.T) {
+	backupDB, _ := tempLevelDB()
+	vecflushable := wrap(backupDB, 696-1, 48)
+
+	loopOp(func(key []byte, val []byte) {
+		if err := vecflushable.Put(key, val); err != nil {
+			t.Error(err)
+		}
+		if err := vecflushable.Flush(); err != nil {
+			t.Error(err)
+		}
+	}, 10)
+	// some items are unloaded into the backup store
+	assert.Equal(t, 4, len(vecflushable.underlying.cache))
+
+	loopOp(func(key []byte, val []byte) {
+		if err := vecflushable.Delete(key); err != nil {
+			t.Error(err)
+		}
+	}, 10)
+
+	checkDeleted := func() {
+		loopOp(func(key []byte, val []byte) {
+			v, err := vecflushable.Get(key)
+			assert.NoError(t, err)
+			assert.Nil(t, v)
+			ok, err := vecflushable.Has(key)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+		}, 10)
+	}
+	checkDeleted()
+
+	assert.NoError(t, vecflushable.Flush())
+	assert.Equal(t, 0, vecflushable.NotFlushedPairs())
+	assert.Equal(t, 0, len(vecflushable.underlying.cache))
+	assert.Equal(t, 0, vecflushable.underlying.memSize)
+	checkDeleted()
+}
+
+func TestVecflushableDeleteNotFlushed(t *testing.T) {
+	backupDB, _ := tempLevelDB()
+	vecflushable := wrap(backupDB, 1000, 48)
+
+	key, val := []byte("key"), []byte("value")
+	assert.NoError(t, vecflushable.Put(key, val))
+	assert.Equal(t, mapMemEst(len(key), len(val)), vecflushable.NotFlushedSizeEst())
+
+	// the pending Put is replaced by the deletion
+	assert.NoError(t, vecflushable.Delete(key))
+	assert.NoError(t, vecflushable.Delete(key))
+	assert.Equal(t, mapMemEst(len(key), 0), vecflushable.NotFlushedSizeEst())
+	assert.Equal(t, 1, vecflushable.NotFlushedPairs())
+
+	assert.NoError(t, vecflushable.Close())
+	assert.Equal(t, errClosed, vecflushable.Delete(key))
+	assert.Equal(t, errClosed, vecflushable.Put(key, val))
+}
+
 func TestSizeBenchmark(t *testing.T) {
 	return // remove to benchmark
 	for _, numItems := range []int{10, 100, 1000, 10000, 100000, 1000000, 10000000} {
@@ -0,0 +1,146 @@
+package vecfc
+
+import (
+	"sync/atomic"
+
+	"github.com/unicornultrafoundation/go-hashgraph/utils/simplewlru"
+)
+
+const (
+	// forklessCausePairMemEst is an estimated memory usage of one cached ForklessCause result, in bytes
+	forklessCausePairMemEst = 160
+	// adaptPeriod is a number of cache lookups between adaptive re-sizings
+	adaptPeriod = 10000
+	// adaptMinShareDiv defines a minimum share of memory budget of every cache as 1/adaptMinShareDiv
+	adaptMinShareDiv = 10
+)
+
+// CacheStats contains effectiveness counters of a cache
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// MaxWeight is a current size limit of the cache
+	MaxWeight uint
+}
+
+// IndexCacheStats contains effectiveness counters of Index caches
+type IndexCacheStats struct {
+	ForklessCause    CacheStats
+	HighestBeforeSeq CacheStats
+	LowestAfterSeq   CacheStats
+}
+
+// countingCache is a simplewlru.Cache which counts hits, misses and evictions
+type countingCache struct {
+	*simplewlru.Cache
+
+	maxWeight uint64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newCountingCache(maxWeight uint, maxSize int) *countingCache {
+	c, _ := simplewlru.New(maxWeight, maxSize)
+	return &countingCache{
+		Cache:     c,
+		maxWeight: uint64(maxWeight),
+	}
+}
+
+// Get looks up a key's value from the cache.
+func (c *countingCache) Get(key interface{}) (value interface{}, ok bool) {
+	value, ok = c.Cache.Get(key)
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return value, ok
+}
+
+// Add adds a value to the cache. Returns number of evicted entries.
+func (c *countingCache) Add(key, value interface{}, weight uint) (evicted int) {
+	evicted = c.Cache.Add(key, value, weight)
+	atomic.AddUint64(&c.evictions, uint64(evicted))
+	return evicted
+}
+
+// Resize changes the cache size. Returns number of evicted entries.
+func (c *countingCache) Resize(maxWeight uint, maxSize int) (evicted int) {
+	evicted = c.Cache.Resize(maxWeight, maxSize)
+	atomic.StoreUint64(&c.maxWeight, uint64(maxWeight))
+	atomic.AddUint64(&c.evictions, uint64(evicted))
+	return evicted
+}
+
+// Stats returns the cache counters
+func (c *countingCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		MaxWeight: uint(atomic.LoadUint64(&c.maxWeight)),
+	}
+}
+
+// CacheStats returns effectiveness counters of the caches.
+// Safe to call concurrently with the index operations.
+func (vi *Index) CacheStats() IndexCacheStats {
+	return IndexCacheStats{
+		ForklessCause:    vi.cache.ForklessCause.Stats(),
+		HighestBeforeSeq: vi.cache.HighestBeforeSeq.Stats(),
+		LowestAfterSeq:   vi.cache.LowestAfterSeq.Stats(),
+	}
+}
+
+// memoryBudget returns the memory budget of the caches in adaptive mode
+func (c IndexCacheConfig) memoryBudget() uint {
+	if c.MemoryBudget != 0 {
+		return c.MemoryBudget
+	}
+	return uint(c.ForklessCausePairs)*forklessCausePairMemEst + c.HighestBeforeSeqSize + c.LowestAfterSeqSize
+}
+
+// adaptCaches re-distributes the memory budget between the caches proportionally to their misses
+// since the last re-distribution. Every cache gets at least 1/adaptMinShareDiv of the budget.
+func (vi *Index) adaptCaches() {
+	if !vi.cfg.Caches.Adaptive {
+		return
+	}
+	caches := []*countingCache{vi.cache.ForklessCause, vi.cache.HighestBeforeSeq, vi.cache.LowestAfterSeq}
+
+	var lookups, missesSum uint64
+	misses := make([]uint64, len(caches))
+	for i, c := range caches {
+		stats := c.Stats()
+		lookups += stats.Hits + stats.Misses
+		misses[i] = stats.Misses - vi.adaptive.lastMisses[i]
+		missesSum += misses[i]
+	}
+	if lookups-vi.adaptive.lastLookups < adaptPeriod {
+		return
+	}
+	vi.adaptive.lastLookups = lookups
+	for i, c := range caches {
+		vi.adaptive.lastMisses[i] = c.Stats().Misses
+	}
+	if missesSum == 0 {
+		return
+	}
+
+	budget := vi.cfg.Caches.memoryBudget()
+	minShare := budget / adaptMinShareDiv
+	rest := budget - minShare*uint(len(caches))
+	shares := make([]uint, len(caches))
+	for i := range caches {
+		shares[i] = minShare + uint(uint64(rest)*misses[i]/missesSum)
+	}
+
+	fcPairs := shares[0] / forklessCausePairMemEst
+	vi.cache.ForklessCause.Resize(fcPairs, int(fcPairs))
+	vi.cache.HighestBeforeSeq.Resize(shares[1], int(shares[1]))
+	vi.cache.LowestAfterSeq.Resize(shares[2], int(shares[2]))
+}
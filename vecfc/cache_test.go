@@ -0,0 +1,96 @@
+package vecfc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
+	"github.com/unicornultrafoundation/go-hashgraph/vecengine/vecflushable"
+)
+
+func testCachesIndex(t *testing.T, cfg IndexConfig) (*Index, dag.Events) {
+	nodes := tdag.GenNodes(10)
+	validators := pos.EqualWeightValidators(nodes, 1)
+
+	ordered := make(dag.Events, 0)
+	events := make(map[hash.Event]dag.Event)
+	getEvent := func(id hash.Event) dag.Event {
+		return events[id]
+	}
+
+	vi := NewIndex(tCrit, cfg)
+	vi.Reset(validators, vecflushable.Wrap(memorydb.New(), vecflushable.TestSizeLimit), getEvent)
+
+	tdag.ForEachRandEvent(nodes, 30, 3, nil, tdag.ForEachEvent{
+		Process: func(e dag.Event, name string) {
+			events[e.ID()] = e
+			ordered = append(ordered, e)
+			assert.NoError(t, vi.Add(e))
+			vi.Flush()
+		},
+	})
+	return vi, ordered
+}
+
+func TestIndexCacheStats(t *testing.T) {
+	cfg := LiteConfig()
+	cfg.Caches.ForklessCausePairs = 100
+	vi, ordered := testCachesIndex(t, cfg)
+
+	before := vi.CacheStats()
+	for i := 0; i < 2; i++ {
+		for _, a := range ordered {
+			vi.ForklessCause(a.ID(), ordered[0].ID())
+		}
+	}
+	stats := vi.CacheStats()
+	n := uint64(len(ordered))
+	assert.Equal(t, before.ForklessCause.Hits+before.ForklessCause.Misses+2*n, stats.ForklessCause.Hits+stats.ForklessCause.Misses)
+	// cache is too small to keep all the pairs
+	assert.Greater(t, stats.ForklessCause.Evictions, before.ForklessCause.Evictions)
+	assert.Equal(t, uint(100), stats.ForklessCause.MaxWeight)
+	assert.NotZero(t, stats.HighestBeforeSeq.Hits+stats.HighestBeforeSeq.Misses)
+	assert.NotZero(t, stats.LowestAfterSeq.Hits+stats.LowestAfterSeq.Misses)
+}
+
+func TestDefaultMemoryBudget(t *testing.T) {
+	for _, cfg := range []IndexConfig{DefaultConfig(cachescale.Identity), LiteConfig()} {
+		caches := cfg.Caches
+		caches.MemoryBudget = 0
+		assert.InDelta(t, caches.memoryBudget(), cfg.Caches.memoryBudget(), float64(forklessCausePairMemEst))
+	}
+}
+
+func TestIndexAdaptiveCaches(t *testing.T) {
+	cfg := LiteConfig()
+	cfg.Caches.Adaptive = true
+	cfg.Caches.MemoryBudget = 1024 * 1024
+	cfg.Caches.HighestBeforeSeqSize = 256 * 1024
+	cfg.Caches.LowestAfterSeqSize = 256 * 1024
+	vi, ordered := testCachesIndex(t, cfg)
+
+	// ForklessCause lookups with many misses
+	vi.cache.ForklessCause.Resize(10, 10)
+	for i := 0; i < adaptPeriod/len(ordered)+1; i++ {
+		for _, a := range ordered {
+			vi.ForklessCause(a.ID(), ordered[i%len(ordered)].ID())
+		}
+	}
+	vi.Flush()
+
+	stats := vi.CacheStats()
+	budget := cfg.Caches.MemoryBudget
+	total := stats.ForklessCause.MaxWeight*forklessCausePairMemEst + stats.HighestBeforeSeq.MaxWeight + stats.LowestAfterSeq.MaxWeight
+	assert.LessOrEqual(t, total, budget)
+	assert.GreaterOrEqual(t, stats.HighestBeforeSeq.MaxWeight, budget/adaptMinShareDiv)
+	assert.GreaterOrEqual(t, stats.LowestAfterSeq.MaxWeight, budget/adaptMinShareDiv)
+	// most of the memory is given to the cache with most misses
+	assert.Greater(t, stats.ForklessCause.MaxWeight*forklessCausePairMemEst, stats.HighestBeforeSeq.MaxWeight)
+	assert.Greater(t, stats.ForklessCause.MaxWeight*forklessCausePairMemEst, stats.LowestAfterSeq.MaxWeight)
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/unicornultrafoundation/go-hashgraph/u2udb"
 	"github.com/unicornultrafoundation/go-hashgraph/u2udb/table"
 	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
-	"github.com/unicornultrafoundation/go-hashgraph/utils/simplewlru"
 	"github.com/unicornultrafoundation/go-hashgraph/vecengine"
 )
 
@@ -17,6 +16,13 @@ type IndexCacheConfig struct {
 	ForklessCausePairs   int
 	HighestBeforeSeqSize uint
 	LowestAfterSeqSize   uint
+
+	// Adaptive enables periodical re-sizing of the caches within MemoryBudget, according to their misses.
+	// The cache sizes above are used as initial sizes.
+	Adaptive bool
+	// MemoryBudget is a total memory limit of the caches in adaptive mode, in bytes.
+	// If zero, then the budget is a sum of the initial cache sizes.
+	MemoryBudget uint
 }
 
 // IndexConfig - Engine config (cache sizes)
@@ -38,12 +44,17 @@ type Index struct {
 	table struct {
 		HighestBeforeSeq u2udb.Store `table:"S"`
 		LowestAfterSeq   u2udb.Store `table:"s"`
+		Rebuild          u2udb.Store `table:"r"`
 	}
 
 	cache struct {
-		HighestBeforeSeq *simplewlru.Cache
-		LowestAfterSeq   *simplewlru.Cache
-		ForklessCause    *simplewlru.Cache
+		HighestBeforeSeq *countingCache
+		LowestAfterSeq   *countingCache
+		ForklessCause    *countingCache
+	}
+	adaptive struct {
+		lastLookups uint64
+		lastMisses  [3]uint64
 	}
 
 	cfg IndexConfig
@@ -56,6 +67,7 @@ func DefaultConfig(scale cachescale.Func) IndexConfig {
 			ForklessCausePairs:   scale.I(20000),
 			HighestBeforeSeqSize: scale.U(160 * 1024),
 			LowestAfterSeqSize:   scale.U(160 * 1024),
+			MemoryBudget:         scale.U(20000*forklessCausePairMemEst + 2*160*1024),
 		},
 	}
 }
@@ -89,9 +101,9 @@ func NewIndexWithEngine(crit func(error), config IndexConfig, engine *vecengine.
 }
 
 func (vi *Index) initCaches() {
-	vi.cache.ForklessCause, _ = simplewlru.New(uint(vi.cfg.Caches.ForklessCausePairs), vi.cfg.Caches.ForklessCausePairs)
-	vi.cache.HighestBeforeSeq, _ = simplewlru.New(vi.cfg.Caches.HighestBeforeSeqSize, int(vi.cfg.Caches.HighestBeforeSeqSize))
-	vi.cache.LowestAfterSeq, _ = simplewlru.New(vi.cfg.Caches.LowestAfterSeqSize, int(vi.cfg.Caches.HighestBeforeSeqSize))
+	vi.cache.ForklessCause = newCountingCache(uint(vi.cfg.Caches.ForklessCausePairs), vi.cfg.Caches.ForklessCausePairs)
+	vi.cache.HighestBeforeSeq = newCountingCache(vi.cfg.Caches.HighestBeforeSeqSize, int(vi.cfg.Caches.HighestBeforeSeqSize))
+	vi.cache.LowestAfterSeq = newCountingCache(vi.cfg.Caches.LowestAfterSeqSize, int(vi.cfg.Caches.HighestBeforeSeqSize))
 }
 
 // Reset resets buffers.
@@ -106,13 +118,26 @@ func (vi *Index) Reset(validators *pos.Validators, db u2udb.FlushableKVStore, ge
 	vi.onDropNotFlushed()
 }
 
+// Flush writes vector clocks to persistent store.
+func (vi *Index) Flush() {
+	vi.Engine.Flush()
+	vi.adaptCaches()
+}
+
 func (vi *Index) GetEngineCallbacks() vecengine.Callbacks {
 	return vecengine.Callbacks{
 		GetHighestBefore: func(event hash.Event) vecengine.HighestBeforeI {
-			return vi.GetHighestBefore(event)
+			// avoid returning a typed nil, engine has to be able to detect a missing vector
+			if v := vi.GetHighestBefore(event); v != nil {
+				return v
+			}
+			return nil
 		},
 		GetLowestAfter: func(event hash.Event) vecengine.LowestAfterI {
-			return vi.GetLowestAfter(event)
+			if v := vi.GetLowestAfter(event); v != nil {
+				return v
+			}
+			return nil
 		},
 		SetHighestBefore: func(event hash.Event, b vecengine.HighestBeforeI) {
 			vi.SetHighestBefore(event, b.(*HighestBeforeSeq))
@@ -137,5 +162,9 @@ func (vi *Index) onDropNotFlushed() {
 
 // GetMergedHighestBefore returns HighestBefore vector clock without branches, where branches are merged into one
 func (vi *Index) GetMergedHighestBefore(id hash.Event) *HighestBeforeSeq {
-	return vi.Engine.GetMergedHighestBefore(id).(*HighestBeforeSeq)
+	merged := vi.Engine.GetMergedHighestBefore(id)
+	if merged == nil {
+		return nil
+	}
+	return merged.(*HighestBeforeSeq)
 }
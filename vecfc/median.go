@@ -0,0 +1,77 @@
+package vecfc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/wmedian"
+)
+
+// ValueFn returns a value (e.g. time or gas price) of the validator's event with the specified seq.
+type ValueFn func(validator idx.ValidatorID, seq idx.Event) uint64
+
+type weightedValue struct {
+	value  uint64
+	weight pos.Weight
+}
+
+func (wv weightedValue) Weight() pos.Weight {
+	return wv.weight
+}
+
+// observedValues returns values of the highest events of every validator, observed by the event.
+// defaultValue is used for validators, which aren't observed by the event or observed as cheaters.
+func (vi *Index) observedValues(id hash.Event, defaultValue uint64, valueFn ValueFn) []wmedian.WeightedValue {
+	before := vi.GetMergedHighestBefore(id)
+	if before == nil {
+		vi.crit(fmt.Errorf("Event=%s not found", id.String()))
+		return nil
+	}
+
+	values := make([]wmedian.WeightedValue, 0, vi.validators.Len())
+	for i := idx.Validator(0); i < vi.validators.Len(); i++ {
+		branchSeq := before.Get(i)
+		value := defaultValue
+		if !branchSeq.IsForkDetected() && branchSeq.Seq != 0 {
+			value = valueFn(vi.validators.GetID(i), branchSeq.Seq)
+		}
+		values = append(values, weightedValue{
+			value:  value,
+			weight: vi.validators.GetWeightByIdx(i),
+		})
+	}
+	return values
+}
+
+// MedianOf returns stake-weighted median of the values of the highest events of every validator, observed by the event.
+// defaultValue is used for validators, which aren't observed by the event or observed as cheaters.
+// The result is BFT-safe: it's bounded by values of honest validators, unless more than 1/3W are Byzantine.
+func (vi *Index) MedianOf(id hash.Event, defaultValue uint64, valueFn ValueFn) uint64 {
+	values := vi.observedValues(id, defaultValue, valueFn)
+	if values == nil {
+		return defaultValue
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i].(weightedValue), values[j].(weightedValue)
+		return a.value < b.value
+	})
+	return wmedian.Of(values, vi.validators.TotalWeight()/2).(weightedValue).value
+}
+
+// QuorumOf returns the highest value, such that the highest events of {QUORUM} validators, observed by the event,
+// have a value which is greater or equal to it.
+// defaultValue is used for validators, which aren't observed by the event or observed as cheaters.
+func (vi *Index) QuorumOf(id hash.Event, defaultValue uint64, valueFn ValueFn) uint64 {
+	values := vi.observedValues(id, defaultValue, valueFn)
+	if values == nil {
+		return defaultValue
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i].(weightedValue), values[j].(weightedValue)
+		return a.value > b.value
+	})
+	return wmedian.Of(values, vi.validators.Quorum()).(weightedValue).value
+}
@@ -0,0 +1,103 @@
+package vecfc
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
+	"github.com/unicornultrafoundation/go-hashgraph/vecengine/vecflushable"
+)
+
+func TestIndexMedianOf(t *testing.T) {
+	r := rand.New(rand.NewSource(0)) // nolint:gosec
+	nodes := tdag.GenNodes(5)
+	validatorsBuilder := pos.NewBuilder()
+	for i, v := range nodes {
+		validatorsBuilder.Set(v, pos.Weight(i+1))
+	}
+	validators := validatorsBuilder.Build()
+
+	ordered := make(dag.Events, 0)
+	events := make(map[hash.Event]dag.Event)
+	getEvent := func(id hash.Event) dag.Event {
+		return events[id]
+	}
+
+	vi := NewIndex(tCrit, LiteConfig())
+	vi.Reset(validators, vecflushable.Wrap(memorydb.New(), vecflushable.TestSizeLimit), getEvent)
+
+	tdag.ForEachRandEvent(nodes, 10, 2, r, tdag.ForEachEvent{
+		Process: func(e dag.Event, name string) {
+			events[e.ID()] = e
+			ordered = append(ordered, e)
+			assert.NoError(t, vi.Add(e))
+			vi.Flush()
+		},
+	})
+
+	valueFn := func(validator idx.ValidatorID, seq idx.Event) uint64 {
+		return uint64(seq)*1000 + uint64(validator)
+	}
+	const defaultValue = 7
+
+	// naive calculation of the highest observed events
+	observed := func(e dag.Event) map[idx.ValidatorID]idx.Event {
+		highest := map[idx.ValidatorID]idx.Event{}
+		stack := hash.Events{e.ID()}
+		for len(stack) != 0 {
+			walk := events[stack[len(stack)-1]]
+			stack = stack[:len(stack)-1]
+			if highest[walk.Creator()] < walk.Seq() {
+				highest[walk.Creator()] = walk.Seq()
+			}
+			stack = append(stack, walk.Parents()...)
+		}
+		return highest
+	}
+	type pair struct {
+		value  uint64
+		weight pos.Weight
+	}
+	naive := func(e dag.Event, desc bool, stop pos.Weight) uint64 {
+		highest := observed(e)
+		pairs := make([]pair, 0, len(nodes))
+		for _, v := range nodes {
+			value := uint64(defaultValue)
+			if seq, ok := highest[v]; ok {
+				value = valueFn(v, seq)
+			}
+			pairs = append(pairs, pair{value, validators.Get(v)})
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			if desc {
+				return pairs[i].value > pairs[j].value
+			}
+			return pairs[i].value < pairs[j].value
+		})
+		var sum pos.Weight
+		for _, p := range pairs {
+			sum += p.weight
+			if sum >= stop {
+				return p.value
+			}
+		}
+		return 0
+	}
+
+	for _, e := range ordered {
+		assert.Equal(t, naive(e, false, validators.TotalWeight()/2), vi.MedianOf(e.ID(), defaultValue, valueFn), e.String())
+		assert.Equal(t, naive(e, true, validators.Quorum()), vi.QuorumOf(e.ID(), defaultValue, valueFn), e.String())
+	}
+
+	// the first event observes only itself
+	first := ordered[0]
+	assert.Equal(t, uint64(defaultValue), vi.QuorumOf(first.ID(), defaultValue, valueFn))
+}
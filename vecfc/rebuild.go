@@ -0,0 +1,157 @@
+package vecfc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/unicornultrafoundation/go-hashgraph/common/bigendian"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+)
+
+var rebuildMarkerKey = []byte("p")
+
+// RebuildConfig - config of the index rebuilding
+type RebuildConfig struct {
+	// CheckpointEvents is a number of events between checkpoints.
+	// Each checkpoint flushes the index along with the rebuild progress, and reports the progress.
+	CheckpointEvents int
+}
+
+// DefaultRebuildConfig returns default rebuild config
+func DefaultRebuildConfig() RebuildConfig {
+	return RebuildConfig{
+		CheckpointEvents: 1000,
+	}
+}
+
+// RebuildProgress is called on every checkpoint with the number of indexed events and the last indexed event
+type RebuildProgress func(indexed int, last hash.Event)
+
+// EventsIterator iterates over all the events of the epoch in topological order (parents first).
+// The order must be the same on every call. Iteration stops if onEvent returns false.
+type EventsIterator func(onEvent func(dag.Event) bool)
+
+type rebuildMarker struct {
+	indexed int
+	last    hash.Event
+}
+
+func (vi *Index) getRebuildMarker() *rebuildMarker {
+	b, err := vi.table.Rebuild.Get(rebuildMarkerKey)
+	if err != nil {
+		vi.crit(err)
+	}
+	if b == nil {
+		return nil
+	}
+	if len(b) != 8+32 {
+		vi.crit(fmt.Errorf("rebuild marker: incorrect len=%d", len(b)))
+		return nil
+	}
+	return &rebuildMarker{
+		indexed: int(bigendian.BytesToUint64(b[:8])),
+		last:    hash.BytesToEvent(b[8:]),
+	}
+}
+
+func (vi *Index) setRebuildMarker(m rebuildMarker) {
+	b := append(bigendian.Uint64ToBytes(uint64(m.indexed)), m.last.Bytes()...)
+	if err := vi.table.Rebuild.Put(rebuildMarkerKey, b); err != nil {
+		vi.crit(err)
+	}
+}
+
+func (vi *Index) delRebuildMarker() {
+	if err := vi.table.Rebuild.Delete(rebuildMarkerKey); err != nil {
+		vi.crit(err)
+	}
+}
+
+// Rebuild re-calculates the index (branches info, HighestBefore and LowestAfter vectors) from the events of the epoch.
+// Use it if the index is corrupted. The index has to be Reset with the epoch validators and DB beforehand.
+//
+// Rebuild is resumable. The progress is flushed atomically with the vectors on every checkpoint,
+// so if the process is interrupted, then the next call with the same events continues from the last checkpoint.
+// Otherwise, the existing vectors of the events are erased first.
+func (vi *Index) Rebuild(forEach EventsIterator, cfg RebuildConfig, progress RebuildProgress) error {
+	if cfg.CheckpointEvents <= 0 {
+		return errors.New("checkpoint interval must be positive")
+	}
+	vi.DropNotFlushed()
+
+	marker := vi.getRebuildMarker()
+	if marker == nil {
+		vi.wipe(forEach, cfg)
+		marker = &rebuildMarker{}
+		vi.setRebuildMarker(*marker)
+		vi.Flush()
+	}
+
+	var (
+		pos      int
+		reported int
+		err      error
+	)
+	report := func() {
+		if progress != nil && reported != marker.indexed {
+			progress(marker.indexed, marker.last)
+		}
+		reported = marker.indexed
+	}
+	forEach(func(e dag.Event) bool {
+		pos++
+		if pos <= marker.indexed {
+			// skip already indexed events
+			if pos == marker.indexed && e.ID() != marker.last {
+				err = fmt.Errorf("rebuild checkpoint mismatch, expected event=%s at position %d, got %s", marker.last.String(), pos, e.ID().String())
+				return false
+			}
+			return true
+		}
+		err = vi.Add(e)
+		if err != nil {
+			err = fmt.Errorf("failed to index event=%s: %v", e.ID().String(), err)
+			return false
+		}
+		marker.indexed = pos
+		marker.last = e.ID()
+		if marker.indexed%cfg.CheckpointEvents == 0 {
+			vi.setRebuildMarker(*marker)
+			vi.Flush()
+			report()
+		}
+		return true
+	})
+	if err != nil {
+		vi.DropNotFlushed()
+		return err
+	}
+	if pos < marker.indexed {
+		vi.DropNotFlushed()
+		return fmt.Errorf("rebuild checkpoint mismatch, expected at least %d events, got %d", marker.indexed, pos)
+	}
+
+	// done, erase the marker along with flushing the last vectors
+	vi.delRebuildMarker()
+	vi.Flush()
+	report()
+	return nil
+}
+
+// wipe erases the vectors of the events and branches info
+func (vi *Index) wipe(forEach EventsIterator, cfg RebuildConfig) {
+	batch := make(hash.Events, 0, cfg.CheckpointEvents)
+	forEach(func(e dag.Event) bool {
+		batch = append(batch, e.ID())
+		if len(batch) >= cfg.CheckpointEvents {
+			vi.Prune(batch)
+			vi.Flush()
+			batch = batch[:0]
+		}
+		return true
+	})
+	vi.Prune(batch)
+	vi.Engine.DropBranchesInfo()
+	vi.cache.ForklessCause.Purge()
+}
@@ -0,0 +1,105 @@
+package vecfc
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/flushable"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
+)
+
+func TestIndexRebuild(t *testing.T) {
+	r := rand.New(rand.NewSource(0)) // nolint:gosec
+	nodes := tdag.GenNodes(5)
+	validators := pos.EqualWeightValidators(nodes, 1)
+
+	ordered := make(dag.Events, 0)
+	events := make(map[hash.Event]dag.Event)
+	getEvent := func(id hash.Event) dag.Event {
+		return events[id]
+	}
+	forEach := func(onEvent func(dag.Event) bool) {
+		for _, e := range ordered {
+			if !onEvent(e) {
+				return
+			}
+		}
+	}
+
+	expected := NewIndex(tCrit, LiteConfig())
+	expected.Reset(validators, flushable.Wrap(memorydb.New()), getEvent)
+
+	db := memorydb.New()
+	corrupted := NewIndex(tCrit, LiteConfig())
+	corrupted.Reset(validators, flushable.Wrap(db), getEvent)
+
+	tdag.ForEachRandFork(nodes, nodes[:2], 20, 3, 5, r, tdag.ForEachEvent{
+		Process: func(e dag.Event, name string) {
+			if _, ok := events[e.ID()]; ok {
+				return
+			}
+			events[e.ID()] = e
+			ordered = append(ordered, e)
+			require.NoError(t, expected.Add(e))
+			expected.Flush()
+			require.NoError(t, corrupted.Add(e))
+			corrupted.Flush()
+		},
+	})
+	// corrupt the index
+	for _, e := range ordered {
+		if r.Intn(2) == 0 {
+			corrupted.SetHighestBefore(e.ID(), NewHighestBeforeSeq(1))
+			corrupted.SetLowestAfter(e.ID(), NewLowestAfterSeq(1))
+		}
+	}
+	corrupted.Flush()
+
+	const checkpoint = 10
+	cfg := RebuildConfig{CheckpointEvents: checkpoint}
+
+	// interrupt the rebuilding after the first checkpoint
+	crashed := NewIndex(tCrit, LiteConfig())
+	crashed.Reset(validators, flushable.Wrap(db), getEvent)
+	func() {
+		defer func() {
+			assert.Equal(t, "crash", recover())
+		}()
+		_ = crashed.Rebuild(forEach, cfg, func(indexed int, last hash.Event) {
+			panic("crash")
+		})
+	}()
+
+	// resume the rebuilding
+	var reports []int
+	rebuilt := NewIndex(tCrit, LiteConfig())
+	rebuilt.Reset(validators, flushable.Wrap(db), getEvent)
+	require.NoError(t, rebuilt.Rebuild(forEach, cfg, func(indexed int, last hash.Event) {
+		reports = append(reports, indexed)
+		assert.Equal(t, ordered[indexed-1].ID(), last)
+	}))
+	assert.Equal(t, 2*checkpoint, reports[0])
+	assert.Equal(t, len(ordered), reports[len(reports)-1])
+	assert.Nil(t, rebuilt.getRebuildMarker())
+
+	assert.Equal(t, expected.BranchesInfo(), rebuilt.BranchesInfo())
+	for _, a := range ordered {
+		assert.Equal(t, *expected.GetHighestBefore(a.ID()), *rebuilt.GetHighestBefore(a.ID()), a.String())
+		assert.Equal(t, *expected.GetLowestAfter(a.ID()), *rebuilt.GetLowestAfter(a.ID()), a.String())
+		for _, b := range ordered {
+			assert.Equal(t, expected.ForklessCause(a.ID(), b.ID()), rebuilt.ForklessCause(a.ID(), b.ID()))
+		}
+	}
+
+	// resuming with other events fails
+	rebuilt.setRebuildMarker(rebuildMarker{indexed: 1, last: ordered[1].ID()})
+	rebuilt.Flush()
+	assert.Error(t, rebuilt.Rebuild(forEach, cfg, nil))
+}
@@ -22,6 +22,14 @@ func (vi *Index) setBytes(table u2udb.Store, id hash.Event, b []byte) {
 	}
 }
 
+func (vi *Index) delBytes(table u2udb.Store, id hash.Event) {
+	key := id.Bytes()
+	err := table.Delete(key)
+	if err != nil {
+		vi.crit(err)
+	}
+}
+
 // GetLowestAfter reads the vector from DB
 func (vi *Index) GetLowestAfter(id hash.Event) *LowestAfterSeq {
 	if bVal, okGet := vi.cache.LowestAfterSeq.Get(id); okGet {
@@ -63,3 +71,15 @@ func (vi *Index) SetHighestBefore(id hash.Event, seq *HighestBeforeSeq) {
 
 	vi.cache.HighestBeforeSeq.Add(id, seq, uint(len(*seq)))
 }
+
+// Prune drops vectors of the events.
+// Only events which are confirmed long ago may be pruned, along with all their ancestors.
+func (vi *Index) Prune(ids hash.Events) {
+	for _, id := range ids {
+		vi.delBytes(vi.table.HighestBeforeSeq, id)
+		vi.delBytes(vi.table.LowestAfterSeq, id)
+		vi.cache.HighestBeforeSeq.Remove(id)
+		vi.cache.LowestAfterSeq.Remove(id)
+	}
+	vi.Engine.Prune(ids)
+}
@@ -0,0 +1,77 @@
+package vecmem
+
+import (
+	"fmt"
+
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+)
+
+type kv struct {
+	a, b hash.Event
+}
+
+// ForklessCause calculates "sufficient coherence" between the events.
+// See vecfc.Index.ForklessCause for the detailed description.
+func (vi *Index) ForklessCause(aID, bID hash.Event) bool {
+	if res, ok := vi.cache.ForklessCause.Get(kv{aID, bID}); ok {
+		return res.(bool)
+	}
+
+	vi.Engine.InitBranchesInfo()
+	res := vi.forklessCause(aID, bID)
+
+	vi.cache.ForklessCause.Add(kv{aID, bID}, res, 1)
+	if vi.isDirty(aID) || vi.isDirty(bID) {
+		vi.dirtyPairs[kv{aID, bID}] = struct{}{}
+	}
+	return res
+}
+
+func (vi *Index) forklessCause(aID, bID hash.Event) bool {
+	// Get events by hash
+	a := vi.GetHighestBefore(aID)
+	if a == nil {
+		vi.crit(fmt.Errorf("Event A=%s not found", aID.String()))
+		return false
+	}
+
+	// check A doesn't observe any forks from B
+	if vi.Engine.AtLeastOneFork() {
+		bBranchID := vi.Engine.GetEventBranchID(bID)
+		if a.Get(bBranchID).IsForkDetected() { // B is observed as cheater by A
+			return false
+		}
+	}
+
+	// check A observes that {QUORUM} non-cheater-validators observe B
+	b := vi.GetLowestAfter(bID)
+	if b == nil {
+		vi.crit(fmt.Errorf("Event B=%s not found", bID.String()))
+		return false
+	}
+
+	yes := vi.validators.NewCounter()
+	// calculate forkless causing using the indexes
+	for branchIDint, creatorIdx := range vi.Engine.BranchesInfo().BranchIDCreatorIdxs {
+		branchID := idx.Validator(branchIDint)
+
+		bLowestAfter := b.Get(branchID)   // lowest event from creator on branchID, which observes B
+		aHighestBefore := a.Get(branchID) // highest event from creator, observed by A
+
+		// if lowest event from branchID which observes B <= highest from branchID observed by A
+		// then {highest from branchID observed by A} observes B
+		if bLowestAfter <= aHighestBefore.Seq && bLowestAfter != 0 && !aHighestBefore.IsForkDetected() {
+			// we may count the same creator multiple times (on different branches)!
+			// so not every call increases the counter
+			yes.CountByIdx(creatorIdx)
+		}
+	}
+	return yes.HasQuorum()
+}
+
+// isDirty returns true if the event's vectors are modified in the not flushed snapshot
+func (vi *Index) isDirty(id hash.Event) bool {
+	_, ok := vi.dirty[id]
+	return ok
+}
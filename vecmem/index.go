@@ -0,0 +1,245 @@
+package vecmem
+
+import (
+	"fmt"
+
+	"github.com/unicornultrafoundation/go-hashgraph/consensus/dagidx"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/adapters"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/cachescale"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/simplewlru"
+	"github.com/unicornultrafoundation/go-hashgraph/vecengine"
+	"github.com/unicornultrafoundation/go-hashgraph/vecfc"
+)
+
+// IndexCacheConfig - config for cache sizes of Index
+type IndexCacheConfig struct {
+	ForklessCausePairs int
+}
+
+// IndexConfig - Index config (cache sizes)
+type IndexConfig struct {
+	Caches IndexCacheConfig
+}
+
+// eventVecs contains the vectors and the global branch ID of an event
+type eventVecs struct {
+	before   *vecfc.HighestBeforeSeq
+	after    *vecfc.LowestAfterSeq
+	branchID idx.Validator
+}
+
+// Index is an in-memory equivalent of vecfc.Index, which keeps the vectors and the branches in maps instead of a KV store.
+// Not flushed vectors are kept in a copy-on-write snapshot on top of the flushed ones,
+// so DropNotFlushed is cheap and doesn't touch the flushed data.
+type Index struct {
+	*vecengine.Engine
+
+	crit       func(error)
+	validators *pos.Validators
+
+	flushed map[hash.Event]*eventVecs
+	dirty   map[hash.Event]*eventVecs
+	// flushedBranches is the flushed BranchesInfo, the not flushed one is modified by the engine
+	flushedBranches *vecengine.BranchesInfo
+
+	cache struct {
+		ForklessCause *simplewlru.Cache
+	}
+	// dirtyPairs are the cached ForklessCause pairs, which are calculated with not flushed events
+	dirtyPairs map[kv]struct{}
+
+	cfg IndexConfig
+}
+
+// DefaultConfig returns default index config
+func DefaultConfig(scale cachescale.Func) IndexConfig {
+	return IndexConfig{
+		Caches: IndexCacheConfig{
+			ForklessCausePairs: scale.I(20000),
+		},
+	}
+}
+
+// LiteConfig returns default index config for tests
+func LiteConfig() IndexConfig {
+	return DefaultConfig(cachescale.Ratio{Base: 100, Target: 1})
+}
+
+// NewIndex creates Index instance.
+func NewIndex(crit func(error), config IndexConfig) *Index {
+	vi := &Index{
+		cfg:  config,
+		crit: crit,
+	}
+	vi.Engine = vecengine.NewIndex(crit, vi.GetEngineCallbacks())
+	vi.cache.ForklessCause, _ = simplewlru.New(uint(vi.cfg.Caches.ForklessCausePairs), vi.cfg.Caches.ForklessCausePairs)
+
+	return vi
+}
+
+// Reset resets buffers.
+// The DB is ignored, everything is kept in memory.
+func (vi *Index) Reset(validators *pos.Validators, _ u2udb.FlushableKVStore, getEvent func(hash.Event) dag.Event) {
+	vi.validators = validators
+	vi.flushed = make(map[hash.Event]*eventVecs)
+	vi.dirty = make(map[hash.Event]*eventVecs)
+	vi.flushedBranches = nil
+	vi.dirtyPairs = make(map[kv]struct{})
+	vi.Engine.Reset(validators, nil, getEvent)
+	vi.cache.ForklessCause.Purge()
+}
+
+// Flush merges the not flushed snapshot into the flushed state.
+func (vi *Index) Flush() {
+	vi.Engine.Flush()
+	for id, vecs := range vi.dirty {
+		vi.flushed[id] = vecs
+	}
+	vi.dirty = make(map[hash.Event]*eventVecs)
+	vi.dirtyPairs = make(map[kv]struct{})
+}
+
+// DropNotFlushed not connected clocks. Call it if event has failed.
+func (vi *Index) DropNotFlushed() {
+	vi.Engine.DropNotFlushed()
+	vi.dirty = make(map[hash.Event]*eventVecs)
+	// the results calculated with the dropped events are evicted
+	for pair := range vi.dirtyPairs {
+		vi.cache.ForklessCause.Remove(pair)
+	}
+	vi.dirtyPairs = make(map[kv]struct{})
+}
+
+// Prune drops vectors of the events.
+// Only events which are confirmed long ago may be pruned, along with all their ancestors.
+func (vi *Index) Prune(ids hash.Events) {
+	for _, id := range ids {
+		delete(vi.flushed, id)
+		delete(vi.dirty, id)
+	}
+}
+
+func (vi *Index) GetEngineCallbacks() vecengine.Callbacks {
+	return vecengine.Callbacks{
+		GetHighestBefore: func(event hash.Event) vecengine.HighestBeforeI {
+			// avoid returning a typed nil, engine has to be able to detect a missing vector
+			if v := vi.GetHighestBefore(event); v != nil {
+				return v
+			}
+			return nil
+		},
+		GetLowestAfter: func(event hash.Event) vecengine.LowestAfterI {
+			v := vi.GetLowestAfter(event)
+			if v == nil {
+				return nil
+			}
+			// engine modifies the vector before it's set, so the flushed one mustn't be returned
+			after := make(vecfc.LowestAfterSeq, len(*v))
+			copy(after, *v)
+			return &after
+		},
+		SetHighestBefore: func(event hash.Event, b vecengine.HighestBeforeI) {
+			vi.getVecsForUpdate(event).before = b.(*vecfc.HighestBeforeSeq)
+		},
+		SetLowestAfter: func(event hash.Event, b vecengine.LowestAfterI) {
+			vi.getVecsForUpdate(event).after = b.(*vecfc.LowestAfterSeq)
+		},
+		NewHighestBefore: func(size idx.Validator) vecengine.HighestBeforeI {
+			return vecfc.NewHighestBeforeSeq(size)
+		},
+		NewLowestAfter: func(size idx.Validator) vecengine.LowestAfterI {
+			return vecfc.NewLowestAfterSeq(size)
+		},
+		Branches: &vecengine.BranchesCallbacks{
+			GetEventBranchID: func(event hash.Event) (idx.Validator, bool) {
+				vecs := vi.getVecs(event)
+				if vecs == nil {
+					return 0, false
+				}
+				return vecs.branchID, true
+			},
+			SetEventBranchID: func(event hash.Event, branchID idx.Validator) {
+				vi.getVecsForUpdate(event).branchID = branchID
+			},
+			GetBranchesInfo: func() *vecengine.BranchesInfo {
+				if vi.flushedBranches == nil {
+					return nil
+				}
+				return vi.flushedBranches.Copy()
+			},
+			SetBranchesInfo: func(info *vecengine.BranchesInfo) {
+				if info == nil {
+					vi.flushedBranches = nil
+					return
+				}
+				vi.flushedBranches = info.Copy()
+			},
+		},
+	}
+}
+
+func (vi *Index) getVecs(id hash.Event) *eventVecs {
+	if vecs, ok := vi.dirty[id]; ok {
+		return vecs
+	}
+	return vi.flushed[id]
+}
+
+// getVecsForUpdate returns event's vectors in the not flushed snapshot, creating them if needed.
+// The vectors are shared with the flushed snapshot until they are set.
+func (vi *Index) getVecsForUpdate(id hash.Event) *eventVecs {
+	if vecs, ok := vi.dirty[id]; ok {
+		return vecs
+	}
+	vecs := &eventVecs{}
+	if prev := vi.flushed[id]; prev != nil {
+		*vecs = *prev
+	}
+	vi.dirty[id] = vecs
+	return vecs
+}
+
+// GetHighestBefore returns HighestBefore vector of the event, or nil if not found
+func (vi *Index) GetHighestBefore(id hash.Event) *vecfc.HighestBeforeSeq {
+	vecs := vi.getVecs(id)
+	if vecs == nil {
+		return nil
+	}
+	return vecs.before
+}
+
+// GetLowestAfter returns LowestAfter vector of the event, or nil if not found
+func (vi *Index) GetLowestAfter(id hash.Event) *vecfc.LowestAfterSeq {
+	vecs := vi.getVecs(id)
+	if vecs == nil {
+		return nil
+	}
+	return vecs.after
+}
+
+// BranchesInfo returns the current BranchesInfo. The result must not be modified.
+func (vi *Index) BranchesInfo() *vecengine.BranchesInfo {
+	vi.Engine.InitBranchesInfo()
+	return vi.Engine.BranchesInfo()
+}
+
+// AtLeastOneFork returns true if at least one fork is observed
+func (vi *Index) AtLeastOneFork() bool {
+	vi.Engine.InitBranchesInfo()
+	return vi.Engine.AtLeastOneFork()
+}
+
+// GetMergedHighestBefore returns HighestBefore vector clock without branches, where branches are merged into one
+func (vi *Index) GetMergedHighestBefore(id hash.Event) dagidx.HighestBeforeSeq {
+	merged := vi.Engine.GetMergedHighestBefore(id)
+	if merged == nil {
+		vi.crit(fmt.Errorf("event=%s not found", id.String()))
+		return nil
+	}
+	return adapters.VectorSeqToDagIndexSeq{HighestBeforeSeq: merged.(*vecfc.HighestBeforeSeq)}
+}
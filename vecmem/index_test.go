@@ -0,0 +1,172 @@
+package vecmem
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicornultrafoundation/go-hashgraph/consensus"
+	"github.com/unicornultrafoundation/go-hashgraph/hash"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/dag/tdag"
+	"github.com/unicornultrafoundation/go-hashgraph/native/idx"
+	"github.com/unicornultrafoundation/go-hashgraph/native/pos"
+	"github.com/unicornultrafoundation/go-hashgraph/u2udb/memorydb"
+	"github.com/unicornultrafoundation/go-hashgraph/utils/adapters"
+	"github.com/unicornultrafoundation/go-hashgraph/vecengine/vecflushable"
+	"github.com/unicornultrafoundation/go-hashgraph/vecfc"
+)
+
+var (
+	_ consensus.DagIndexer     = (*Index)(nil)
+	_ consensus.DagIndexPruner = (*Index)(nil)
+)
+
+func tCrit(err error) { panic(err) }
+
+// TestIndexMatchesVecfc checks that the in-memory index produces the same results as vecfc.Index,
+// including the case when not flushed changes are dropped.
+func TestIndexMatchesVecfc(t *testing.T) {
+	for i, test := range []struct {
+		nodesNum    int
+		cheatersNum int
+		eventsNum   int
+		forksNum    int
+		parentsNum  int
+	}{
+		{nodesNum: 4, cheatersNum: 0, eventsNum: 30, forksNum: 0, parentsNum: 3},
+		{nodesNum: 5, cheatersNum: 2, eventsNum: 20, forksNum: 10, parentsNum: 4},
+		{nodesNum: 10, cheatersNum: 3, eventsNum: 10, forksNum: 3, parentsNum: 4},
+	} {
+		t.Run(fmt.Sprintf("Test #%d", i), func(t *testing.T) {
+			testIndexMatchesVecfc(t, int64(i), test.nodesNum, test.cheatersNum, test.eventsNum, test.forksNum, test.parentsNum)
+		})
+	}
+}
+
+func testIndexMatchesVecfc(t *testing.T, seed int64, nodesNum, cheatersNum, eventsNum, forksNum, parentsNum int) {
+	assertar := assert.New(t)
+	r := rand.New(rand.NewSource(seed)) // nolint:gosec
+
+	nodes := tdag.GenNodes(nodesNum)
+	validators := pos.EqualWeightValidators(nodes, 1)
+
+	processedArr := dag.Events{}
+	processed := make(map[hash.Event]dag.Event)
+	getEvent := func(id hash.Event) dag.Event {
+		return processed[id]
+	}
+
+	expected := &adapters.VectorToDagIndexer{Index: vecfc.NewIndex(tCrit, vecfc.LiteConfig())}
+	expected.Reset(validators, vecflushable.Wrap(memorydb.New(), vecflushable.TestSizeLimit), getEvent)
+	got := NewIndex(tCrit, LiteConfig())
+	got.Reset(validators, nil, getEvent)
+
+	_ = tdag.ForEachRandFork(nodes, nodes[:cheatersNum], eventsNum, parentsNum, forksNum, r, tdag.ForEachEvent{
+		Process: func(e dag.Event, name string) {
+			if _, ok := processed[e.ID()]; ok {
+				return
+			}
+			processed[e.ID()] = e
+			processedArr = append(processedArr, e)
+
+			if r.Intn(3) == 0 {
+				expected.Flush()
+				got.Flush()
+				// speculative insertion of the same event, which must not affect the index
+				assertar.NoError(got.Add(e))
+				got.DropNotFlushed()
+				assertar.Nil(got.GetHighestBefore(e.ID()))
+			}
+
+			assertar.NoError(expected.Add(e))
+			assertar.NoError(got.Add(e))
+		},
+	})
+	expected.Flush()
+	got.Flush()
+
+	idxs := validators.Idxs()
+	for _, a := range processedArr {
+		expectedBefore := expected.GetMergedHighestBefore(a.ID())
+		gotBefore := got.GetMergedHighestBefore(a.ID())
+		for _, n := range nodes {
+			assertar.Equal(*expectedBefore.Get(idxs[n]).(*adapters.BranchSeq), *gotBefore.Get(idxs[n]).(*adapters.BranchSeq), a.String())
+		}
+		assertar.Equal(*expected.GetLowestAfter(a.ID()), *got.GetLowestAfter(a.ID()), a.String())
+		for _, b := range processedArr {
+			assertar.Equal(expected.ForklessCause(a.ID(), b.ID()), got.ForklessCause(a.ID(), b.ID()), "%s %s", a.String(), b.String())
+		}
+	}
+	assertar.Equal(expected.BranchesInfo(), got.BranchesInfo())
+	assertar.Equal(cheatersNum != 0 && forksNum != 0, got.AtLeastOneFork())
+
+	// pruned events are removed
+	got.Prune(hash.Events{processedArr[0].ID()})
+	assertar.Nil(got.GetHighestBefore(processedArr[0].ID()))
+	assertar.Equal(idx.Validator(len(expected.BranchesInfo().BranchIDCreatorIdxs)), idx.Validator(len(got.BranchesInfo().BranchIDCreatorIdxs)))
+}
+
+// TestIndexDropNotFlushedForklessCause checks that ForklessCause results of dropped events aren't cached.
+func TestIndexDropNotFlushedForklessCause(t *testing.T) {
+	nodes := tdag.GenNodes(3)
+	validators := pos.EqualWeightValidators(nodes, 1)
+
+	processed := make(map[hash.Event]dag.Event)
+	newEvent := func(creator idx.ValidatorID, seq idx.Event, id byte, parents ...dag.Event) dag.Event {
+		e := &tdag.TestEvent{}
+		e.SetCreator(creator)
+		e.SetSeq(seq)
+		e.SetEpoch(1)
+		lamport := idx.Lamport(0)
+		for _, p := range parents {
+			e.AddParent(p.ID())
+			if lamport < p.Lamport() {
+				lamport = p.Lamport()
+			}
+		}
+		e.SetLamport(lamport + 1)
+		e.SetID([24]byte{id})
+		return e
+	}
+
+	vi := NewIndex(tCrit, LiteConfig())
+	vi.Reset(validators, nil, func(id hash.Event) dag.Event {
+		return processed[id]
+	})
+	add := func(e dag.Event) {
+		processed[e.ID()] = e
+		assert.NoError(t, vi.Add(e))
+	}
+
+	var roots, heads dag.Events
+	for i, n := range nodes {
+		roots = append(roots, newEvent(n, 1, byte(i+1)))
+		add(roots[i])
+	}
+	for i, n := range nodes {
+		parents := append(dag.Events{roots[i]}, roots[:i]...)
+		parents = append(parents, roots[i+1:]...)
+		heads = append(heads, newEvent(n, 2, byte(i+10), parents...))
+		add(heads[i])
+	}
+	vi.Flush()
+
+	// speculative event observes the quorum of heads, so it forkless-causes the first root
+	speculative := newEvent(nodes[0], 3, 100, heads...)
+	add(speculative)
+	assert.True(t, vi.ForklessCause(speculative.ID(), roots[0].ID()))
+	assert.False(t, vi.ForklessCause(heads[0].ID(), roots[1].ID()))
+	vi.DropNotFlushed()
+	// only the results calculated with the dropped events are evicted
+	assert.False(t, vi.cache.ForklessCause.Contains(kv{speculative.ID(), roots[0].ID()}))
+	assert.True(t, vi.cache.ForklessCause.Contains(kv{heads[0].ID(), roots[1].ID()}))
+
+	// a different event with the same ID observes only its self-parent
+	another := newEvent(nodes[0], 3, 100, heads[0])
+	assert.Equal(t, speculative.ID(), another.ID())
+	add(another)
+	assert.False(t, vi.ForklessCause(another.ID(), roots[0].ID()))
+}